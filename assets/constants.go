@@ -4,14 +4,28 @@ package assets
 const (
 	// AvatarURL is a link to the profile picture used in embed on behalf of Flamingo
 	AvatarURL = "https://s3.amazonaws.com/flamingo-bot/pfp"
+	// CloudWatchNameSpace is the root of the namespace of all metrics emitted by Flamingo
+	CloudWatchNamespace = "Flamingo/"
+)
+
+// Resource names default to the production deployment and are overridden by configuration at startup
+var (
 	// BucketName is the S3 bucket where Flamingo stores assets
 	BucketName = "flamingo-bot"
+	// BucketURL is where the objects in the bucket are served from, followed by their key
+	BucketURL = "https://s3.amazonaws.com/flamingo-bot"
 	// StrikeTableName is the name of the table where strikes are persisted
 	StrikeTableName = "FlamingoStrikes"
 	// PastaTableName is the name of the table where pastas are persisted
 	PastaTableName = "FlamingoPasta"
 	// AuthTableName is the name of the table where permissions are persisted
 	AuthTableName = "FlamingoAuth"
-	// CloudWatchNameSpace is the root of the namespace of all metrics emitted by Flamingo
-	CloudWatchNamespace = "Flamingo/"
+	// ReactionTableName is the name of the table where reaction metadata is persisted
+	ReactionTableName = "FlamingoReactions"
+	// PromotionTableName is the name of the table where reactions promoted to emojis and stickers are tracked
+	PromotionTableName = "FlamingoPromotions"
+	// SettingsTableName is the name of the table where guild and user settings are persisted
+	SettingsTableName = "FlamingoSettings"
+	// AuditTableName is the name of the table where audit entries are persisted
+	AuditTableName = "FlamingoAudit"
 )
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"FlamingoV2/flamingoapi"
+	"FlamingoV2/flamingoconfig"
 	"FlamingoV2/flamingolog"
 	"FlamingoV2/flamingoservice"
-	"flag"
+	"FlamingoV2/flamingoweb"
+	"context"
 	"log"
+	"math/rand"
+	"net/url"
 	"os"
 	"os/signal"
 	"strings"
@@ -13,6 +18,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
@@ -21,78 +27,183 @@ import (
 )
 
 var (
-	DISCORD_TOKEN, AWS_ACCESS_KEY, AWS_SECRET_KEY, REGION string
-	local                                                 bool
-	flamingoLogger                                        *log.Logger
-	flamingoErrLogger                                     *log.Logger
-	commandServices                                       []flamingoservice.FlamingoService
-	spoilerService                                        *flamingoservice.SpoilerClient
+	// version is set at build time with -ldflags "-X main.version=..."
+	version = "dev"
+	// started is when the process started, for reporting uptime
+	started = time.Now()
+
+	config            *flamingoconfig.Config
+	flamingoLogger    *log.Logger
+	flamingoErrLogger *log.Logger
+	commandServices   []commandService
+	spoilerService    *flamingoservice.SpoilerClient
+	supervisor        *flamingoservice.Supervisor
+	rateLimiter       *flamingoservice.RateLimiter
+	dispatchClient    *flamingoservice.DispatchClient
 )
 
 func init() {
+	rand.Seed(time.Now().UnixNano())
 	flamingoLogger = flamingolog.BuildServiceLogger("Flamingo")
 	flamingoErrLogger = flamingolog.BuildServiceErrorLogger("Flamingo")
-	//Dumb and lazy hack
-	flag.BoolVar(&local, "local", false, "Flag for running waimote in local test mode.")
-	flag.StringVar(&DISCORD_TOKEN, "t", "", "Discord bot token.")
-	flag.StringVar(&AWS_ACCESS_KEY, "ak", "", "AWS Access Key")
-	flag.StringVar(&AWS_SECRET_KEY, "sk", "", "AWS Secret Key")
-	flag.StringVar(&REGION, "r", "", "AWS Region")
-	flag.Parse()
-	if local {
-		flamingoLogger.Println("Running locally")
-		//Running locally, pass creds as flags
-	} else {
-		//Run with creds in environment
-		flamingoLogger.Println("Running remotely")
-		DISCORD_TOKEN = os.Getenv("DISCORD_TOKEN")
-		AWS_ACCESS_KEY = os.Getenv("AWS_ACCESS_KEY")
-		AWS_SECRET_KEY = os.Getenv("AWS_SECRET_KEY")
-		REGION = os.Getenv("REGION")
-	}
 }
 
 func main() {
-	discord, err := discordgo.New("Bot " + DISCORD_TOKEN)
+	var err error
+	config, err = flamingoconfig.Load(os.Args[1:])
 	if err != nil {
-		flamingoErrLogger.Println("Error creating Discord session: ", err)
-		return
+		flamingoErrLogger.Println(err)
+		os.Exit(2)
 	}
+	config.Apply()
+	flamingoLogger.Println("Starting Flamingo " + version)
 	//Initialize services before starting Flamingo
 	//AWS service client construction
-	awsSess := session.Must(session.NewSession(
-		aws.NewConfig().
-			WithCredentials(credentials.NewStaticCredentials(AWS_ACCESS_KEY, AWS_SECRET_KEY, "")).
-			WithMaxRetries(3),
-	))
-	ddb := dynamodb.New(awsSess, aws.NewConfig().WithRegion(REGION))
+	awsSess, err := buildAWSSession(config.AWS)
+	if err != nil {
+		flamingoErrLogger.Println("Error creating AWS session: ", err)
+		return
+	}
+	ddb := dynamodb.New(awsSess, awsClientConfig(config.AWS.Endpoints.DynamoDB))
 	// Create S3 service client with a specific Region.
-	s3 := s3.New(awsSess, aws.NewConfig().WithRegion(REGION))
-	cw := cloudwatch.New(awsSess, aws.NewConfig().WithRegion(REGION))
+	s3 := s3.New(awsSess, awsClientConfig(config.AWS.Endpoints.S3).WithS3ForcePathStyle(config.AWS.S3PathStyle))
+	cw := cloudwatch.New(awsSess, awsClientConfig(config.AWS.Endpoints.CloudWatch))
 	//Flamingo service Client construction
 	metricsClient := &flamingolog.FlamingoMetricsClient{
 		CloudWatchAgent: cw,
-		Local:           local,
+		Local:           config.Sinks.Metrics == flamingoconfig.MetricsSinkNone,
+	}
+	stopReporting := make(chan struct{})
+	go metricsClient.FlushEvery(time.Minute, stopReporting)
+	shards, err := flamingoservice.NewShards("Bot "+config.Token, config.Shards.Count, config.Shards.IDs, metricsClient)
+	if err != nil {
+		flamingoErrLogger.Println("Error creating Discord sessions: ", err)
+		return
 	}
-	authClient := flamingoservice.NewAuthClient(discord, ddb, metricsClient)
-	spoilerService = flamingoservice.NewSpoilerClient(authClient)
+	discord := shards.Primary()
+	go shards.ReportEvery(time.Minute, stopReporting)
+	supervisor = flamingoservice.NewSupervisor(metricsClient, config.Workers.Concurrency, config.Workers.GuildConcurrency, config.Workers.GuildQueue)
+	go supervisor.ReportEvery(time.Minute, stopReporting)
+	rateLimits := make(map[string]flamingoservice.RateLimit, len(config.RateLimits.Commands))
+	for key, limit := range config.RateLimits.Commands {
+		rateLimits[key] = flamingoservice.RateLimit{Burst: limit.Burst, Every: limit.Every.Duration}
+	}
+	rateLimiter = flamingoservice.NewRateLimiter(metricsClient,
+		flamingoservice.RateLimit{Burst: config.RateLimits.Default.Burst, Every: config.RateLimits.Default.Every.Duration},
+		rateLimits,
+		config.RateLimits.ExemptRoles)
+	settingsClient := flamingoservice.NewSettingsClient(ddb, metricsClient)
+	auditClient := flamingoservice.NewAuditClient(shards, ddb, metricsClient, settingsClient)
+	authClient := flamingoservice.NewAuthClient(shards, ddb, metricsClient, auditClient, newCache("Auth", metricsClient), config.Auth.Enforce)
+	spoilerService = flamingoservice.NewSpoilerClient(authClient, settingsClient, newCache("Spoiler", metricsClient))
+
+	pastaClient := flamingoservice.NewPastaClient(ddb, metricsClient, authClient, auditClient, newCache("Pasta", metricsClient))
+	templateClient := flamingoservice.NewTemplateClient(ddb, metricsClient, authClient, auditClient, newCache("Template", metricsClient))
+	strikeClient := flamingoservice.NewStrikeClient(ddb, metricsClient, authClient, auditClient)
+	reactClient := flamingoservice.NewReactClient(s3, ddb, metricsClient, authClient, settingsClient, auditClient)
+	dispatchClient = flamingoservice.NewDispatchClient(settingsClient, auditClient, metricsClient, newCache("Dispatch", metricsClient))
+	archiveClient := flamingoservice.NewArchiveClient(ddb, metricsClient, authClient, settingsClient, pastaClient, templateClient, strikeClient, reactClient, auditClient, shards, dispatchClient)
 
-	commandServices = []flamingoservice.FlamingoService{
-		flamingoservice.NewStrikeClient(ddb, metricsClient, authClient),
-		flamingoservice.NewPastaClient(ddb, metricsClient, authClient),
-		flamingoservice.NewTemplateClient(ddb, metricsClient, authClient),
-		flamingoservice.NewReactClient(s3, metricsClient, authClient),
-		authClient,
+	services := map[string]flamingoservice.FlamingoService{
+		"strike":   strikeClient,
+		"pasta":    pastaClient,
+		"template": templateClient,
+		"react":    reactClient,
+		"spoiler":  spoilerService,
+		"auth":     authClient,
+		"flamingo": archiveClient,
+		"audit":    auditClient,
+	}
+	for _, name := range config.Services {
+		commandServices = append(commandServices, commandService{name: name, service: services[name]})
+	}
+	flamingoLogger.Println("Enabled services: " + strings.Join(config.Services, ", "))
+	if config.ServiceEnabled("spoiler") {
+		//Reactions and interactions share their guild's queue with commands, so only those that can reveal spoilers are queued
+		shards.AddHandler(func(session *discordgo.Session, reaction *discordgo.MessageReactionAdd) {
+			if !spoilerService.CanRevealReaction(session, reaction) {
+				return
+			}
+			supervise("spoiler", reaction.GuildID, func(ctx context.Context) {
+				if dispatchClient.ServiceEnabled(ctx, reaction.GuildID, "spoiler") {
+					spoilerService.RevealReaction(ctx, session, reaction)
+				}
+			})
+		})
+		shards.AddHandler(func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			if !spoilerService.IsRevealInteraction(interaction) {
+				return
+			}
+			supervise("spoiler", interaction.GuildID, func(ctx context.Context) {
+				if dispatchClient.ServiceEnabled(ctx, interaction.GuildID, "spoiler") {
+					spoilerService.RevealInteraction(ctx, session, interaction)
+				}
+			})
+		})
+	}
+	onboardingClient := flamingoservice.NewOnboardingClient(authClient, settingsClient, archiveClient, metricsClient, config.PurgeGrace.Duration)
+	//Every handler is registered before connecting so no events are missed, guilds joined while offline are bootstrapped from the initial GuildCreates
+	shards.AddHandler(func(session *discordgo.Session, guildCreate *discordgo.GuildCreate) {
+		supervise("auth", guildCreate.ID, func(ctx context.Context) { onboardingClient.GuildCreate(ctx, session, guildCreate) })
+	})
+	shards.AddHandler(func(session *discordgo.Session, guildDelete *discordgo.GuildDelete) {
+		supervise("auth", guildDelete.ID, func(ctx context.Context) { onboardingClient.GuildDelete(ctx, session, guildDelete) })
+	})
+	shards.AddHandler(commandListener)
+	//Served before connecting so orchestrators see Flamingo is alive, if not yet ready, while it connects
+	var healthServer *flamingoapi.HealthServer
+	if config.Health.Listen != "" {
+		healthServer = flamingoapi.NewHealthServer(config.Health.Listen, version, started, shards, ddb, s3, metricsClient)
+		go func() {
+			if err := healthServer.ListenAndServe(); err != nil {
+				flamingoErrLogger.Println("Error serving health probes: ", err)
+			}
+		}()
 	}
 	//Start Flamingo
-	err = discord.Open()
+	err = shards.Open()
 	if err != nil {
 		flamingoErrLogger.Println("Error opening Discord session: ", err)
+		shards.Close()
 		return
 	}
 	flamingoLogger.Println("Authenticated")
-	discord.AddHandler(commandListener)
-	discord.AddHandler(authSetup(authClient))
+	//Commands are global, so only the process running shard 0 registers them
+	if config.ServiceEnabled("spoiler") && shards.Runs(0) {
+		spoilerService.RegisterCommands(discord)
+	}
+	//Due purges are shared by every process, so only the one running shard 0 carries them out
+	if config.PurgeGrace.Duration > 0 && shards.Runs(0) {
+		go archiveClient.PurgeEvery(discord, time.Hour, stopReporting)
+	}
+	var apiServer *flamingoapi.Server
+	if config.API.Listen != "" {
+		apiServer = flamingoapi.NewServer(config.API.Listen, config.API.Token, config.CommandTimeout.Duration,
+			metricsClient, authClient, pastaClient, templateClient, strikeClient, reactClient)
+		go func() {
+			if err := apiServer.ListenAndServe(); err != nil {
+				flamingoErrLogger.Println("Error serving admin API: ", err)
+			}
+		}()
+	}
+	var dashboard *flamingoweb.Dashboard
+	if config.Dashboard.Listen != "" {
+		//validated with the rest of the config
+		dashboardURL, _ := url.Parse(config.Dashboard.URL)
+		dashboard = flamingoweb.NewDashboard(config.Dashboard.Listen, dashboardURL,
+			flamingoweb.OAuth2Config{
+				ClientID:     config.Dashboard.ClientID,
+				ClientSecret: config.Dashboard.ClientSecret,
+				DiscordAPI:   strings.TrimSuffix(config.Dashboard.DiscordAPI, "/"),
+			},
+			config.Dashboard.SessionTTL.Duration, config.CommandTimeout.Duration,
+			shards, metricsClient, authClient, pastaClient, templateClient, strikeClient, auditClient)
+		go func() {
+			if err := dashboard.ListenAndServe(); err != nil {
+				flamingoErrLogger.Println("Error serving dashboard: ", err)
+			}
+		}()
+	}
 
 	// Wait here until CTRL-C or other term signal is received.
 	flamingoLogger.Println("Flamingo is now running.  Press CTRL-C to exit.")
@@ -100,8 +211,90 @@ func main() {
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
 	<-sc
 
-	// Cleanly close down the Discord session.
-	discord.Close()
+	// Stop accepting commands and let in-flight ones finish before closing down the Discord session.
+	flamingoLogger.Println("Shutting down")
+	if apiServer != nil {
+		if err := apiServer.Shutdown(config.ShutdownTimeout.Duration); err != nil {
+			flamingoErrLogger.Println(err)
+		}
+	}
+	if dashboard != nil {
+		if err := dashboard.Shutdown(config.ShutdownTimeout.Duration); err != nil {
+			flamingoErrLogger.Println(err)
+		}
+	}
+	if err := supervisor.Shutdown(config.ShutdownTimeout.Duration); err != nil {
+		flamingoErrLogger.Println(err)
+	}
+	if healthServer != nil {
+		if err := healthServer.Shutdown(config.ShutdownTimeout.Duration); err != nil {
+			flamingoErrLogger.Println(err)
+		}
+	}
+	close(stopReporting)
+	if err := metricsClient.Flush(); err != nil {
+		flamingoErrLogger.Println("Error flushing metrics: ", err)
+	}
+	shards.Close()
+}
+
+// buildAWSSession uses static credentials when configured, otherwise the default credential chain
+// if a role is configured, it is assumed using the credentials resolved above
+func buildAWSSession(awsConfig flamingoconfig.AWSConfig) (*session.Session, error) {
+	sessConfig := aws.NewConfig().
+		WithRegion(awsConfig.Region).
+		WithMaxRetries(3)
+	if awsConfig.AccessKey != "" {
+		sessConfig = sessConfig.WithCredentials(credentials.NewStaticCredentials(awsConfig.AccessKey, awsConfig.SecretKey, ""))
+	}
+	awsSess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *sessConfig,
+		Profile:           awsConfig.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if awsConfig.RoleARN != "" {
+		awsSess = awsSess.Copy(aws.NewConfig().WithCredentials(stscreds.NewCredentials(awsSess, awsConfig.RoleARN)))
+	}
+	return awsSess, nil
+}
+
+// awsClientConfig builds the config for an AWS client in the configured region
+// endpoint takes precedence over the endpoint shared by every client, if neither is set the default endpoint is used
+func awsClientConfig(endpoint string) *aws.Config {
+	clientConfig := aws.NewConfig().WithRegion(config.AWS.Region)
+	if endpoint == "" {
+		endpoint = config.AWS.Endpoint
+	}
+	if endpoint != "" {
+		clientConfig = clientConfig.WithEndpoint(endpoint)
+	}
+	return clientConfig
+}
+
+// newCache builds a cache sized by the configuration, nil if caching is disabled
+func newCache(name string, metricsClient *flamingolog.FlamingoMetricsClient) *flamingoservice.Cache {
+	if config.Cache.Capacity < 1 {
+		return nil
+	}
+	return flamingoservice.NewCache(name, metricsClient, config.Cache.Capacity, config.Cache.TTL.Duration)
+}
+
+// commandService is a command service and the name it is configured by
+type commandService struct {
+	name    string
+	service flamingoservice.FlamingoService
+}
+
+// supervise runs handler under the supervisor, cancelling it once the service's command timeout elapses
+func supervise(service, guildID string, handler func(ctx context.Context)) error {
+	return supervisor.Go(guildID, func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, config.TimeoutFor(service))
+		defer cancel()
+		handler(ctx)
+	})
 }
 
 func commandListener(session *discordgo.Session, m *discordgo.MessageCreate) {
@@ -111,36 +304,42 @@ func commandListener(session *discordgo.Session, m *discordgo.MessageCreate) {
 	}
 
 	if strings.HasPrefix(m.Message.Content, flamingoservice.CommandPrefix) {
+		//Command services are unaware of the prefix
+		content := m.Content[len(flamingoservice.CommandPrefix):]
 		for _, v := range commandServices {
-			//Command services are unaware of the prefix
-			if v.IsCommand(m.Content[len(flamingoservice.CommandPrefix):]) {
-				go v.Handle(session, m.Message)
+			if v.service.IsCommand(content) {
+				service, name := v.service, v.name
+				action := flamingoservice.CommandAction(name, content)
+				err := supervise(v.name, m.GuildID, func(ctx context.Context) {
+					//Disabled services ignore their commands, as if they weren't running
+					if !dispatchClient.ServiceEnabled(ctx, m.GuildID, name) {
+						return
+					}
+					if !dispatchClient.ChannelAllowed(ctx, session, m.Message) {
+						session.MessageReactionAdd(m.ChannelID, m.ID, flamingoservice.RestrictedEmoji)
+						return
+					}
+					//Only commands that are about to run are charged, exempt members never are
+					if !rateLimiter.Exempt(session, m.Message) && !rateLimiter.Allow(m.GuildID, m.Author.ID, name, action) {
+						session.MessageReactionAdd(m.ChannelID, m.ID, flamingoservice.ThrottledEmoji)
+						return
+					}
+					service.Handle(ctx, session, m.Message)
+				})
+				//Commands shed because the guild's queue is full are dropped silently, replying would only add to the load
+				if err == flamingoservice.ErrShuttingDown {
+					session.ChannelMessageSend(m.ChannelID, "Flamingo is restarting, try again in a moment.")
+				}
 				return
 			}
 		}
 	} else {
-		if spoilerService.IsCommand(m.Content) {
-			go spoilerService.Handle(session, m.Message)
-		}
-	}
-}
-
-func authSetup(authClient *flamingoservice.AuthClient) func(*discordgo.Session, *discordgo.GuildCreate) {
-	return func(session *discordgo.Session, gc *discordgo.GuildCreate) {
-		timeStamp, err := gc.JoinedAt.Parse()
-		if err != nil {
-			flamingoErrLogger.Println(err)
-			return
-		}
-		//Join time <30s is an indicator of joining recently as opposed to reconnecting
-		if timeStamp.Unix() > time.Now().Unix()-30 {
-			flamingoLogger.Printf("Joined %s. Setting permissive flag.\n", gc.Guild.ID)
-			err := authClient.SetPermissiveFlagValue(gc.Guild.ID, true)
-			if err != nil {
-				flamingoErrLogger.Printf("An error occured while setting permissive flag for %s", gc.Guild.ID)
-				flamingoErrLogger.Println(err)
-			}
-			authClient.SetPermission(gc.Guild.ID, gc.OwnerID, "auth", "", false, true)
+		if config.ServiceEnabled("spoiler") && spoilerService.IsSpoiler(m.Message) {
+			supervise("spoiler", m.GuildID, func(ctx context.Context) {
+				if dispatchClient.ServiceEnabled(ctx, m.GuildID, "spoiler") {
+					spoilerService.Reveal(ctx, session, m.Message)
+				}
+			})
 		}
 	}
 }
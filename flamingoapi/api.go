@@ -0,0 +1,200 @@
+package flamingoapi
+
+import (
+	"FlamingoV2/flamingolog"
+	"FlamingoV2/flamingoservice"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	apiServiceName = "API"
+	// apiPrefix is the path every API route is served under
+	apiPrefix = "/api/"
+	// apiMaxBodyBytes bounds request bodies, the largest resource is a pasta
+	apiMaxBodyBytes = 64 << 10
+)
+
+var (
+	snowflake = regexp.MustCompile(`^\d+$`)
+)
+
+// Server is responsible for serving the admin API, which manages the same data as the chat commands over HTTP
+// every request must present the configured bearer token, which grants access to every guild
+type Server struct {
+	Token            string
+	Timeout          time.Duration
+	MetricsClient    *flamingolog.FlamingoMetricsClient
+	AuthClient       *flamingoservice.AuthClient
+	PastaClient      *flamingoservice.PastaClient
+	TemplateClient   *flamingoservice.TemplateClient
+	StrikeClient     *flamingoservice.StrikeClient
+	ReactClient      *flamingoservice.ReactClient
+	HTTPServer       *http.Server
+	APIServiceLogger *log.Logger
+	APIErrorLogger   *log.Logger
+}
+
+// apiError is the body of every unsuccessful response
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// NewServer constructs a Server listening on listen
+// timeout bounds how long a request may run, like the command timeout does for chat commands
+func NewServer(listen, token string,
+	timeout time.Duration,
+	metricsClient *flamingolog.FlamingoMetricsClient,
+	authClient *flamingoservice.AuthClient,
+	pastaClient *flamingoservice.PastaClient,
+	templateClient *flamingoservice.TemplateClient,
+	strikeClient *flamingoservice.StrikeClient,
+	reactClient *flamingoservice.ReactClient) *Server {
+	server := &Server{
+		Token:            token,
+		Timeout:          timeout,
+		MetricsClient:    metricsClient,
+		AuthClient:       authClient,
+		PastaClient:      pastaClient,
+		TemplateClient:   templateClient,
+		StrikeClient:     strikeClient,
+		ReactClient:      reactClient,
+		APIServiceLogger: flamingolog.BuildServiceLogger(apiServiceName),
+		APIErrorLogger:   flamingolog.BuildServiceErrorLogger(apiServiceName),
+	}
+	server.HTTPServer = &http.Server{
+		Addr:              listen,
+		Handler:           server,
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          server.APIErrorLogger,
+	}
+	return server
+}
+
+// ListenAndServe serves the API until it is shut down
+func (server *Server) ListenAndServe() error {
+	server.APIServiceLogger.Println("Listening on " + server.HTTPServer.Addr)
+	err := server.HTTPServer.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting requests and waits up to timeout for in-flight requests to finish
+func (server *Server) Shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return server.HTTPServer.Shutdown(ctx)
+}
+
+// ServeHTTP authenticates a request and routes it to the resource it names
+func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if !strings.HasPrefix(request.URL.Path, apiPrefix) {
+		writeError(writer, http.StatusNotFound, "not found")
+		return
+	}
+	if !server.authenticate(request) {
+		server.MetricsClient.Count(apiServiceName, "Unauthorized", nil)
+		writer.Header().Set("WWW-Authenticate", `Bearer realm="flamingo"`)
+		writeError(writer, http.StatusUnauthorized, "a valid bearer token is required")
+		return
+	}
+	ctx, cancel := context.WithTimeout(request.Context(), server.Timeout)
+	defer cancel()
+	request = request.WithContext(ctx)
+	request.Body = http.MaxBytesReader(writer, request.Body, apiMaxBodyBytes)
+
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, apiPrefix), "/"), "/")
+	switch {
+	case len(segments) >= 3 && segments[0] == "guilds":
+		if !snowflake.MatchString(segments[1]) {
+			writeError(writer, http.StatusBadRequest, "guild must be a Discord ID")
+			return
+		}
+		server.MetricsClient.Count(apiServiceName, "Request", map[string]string{"Resource": segments[2]})
+		switch segments[2] {
+		case "pastas":
+			server.handlePastas(writer, request, segments[1], segments[3:])
+			return
+		case "templates":
+			server.handleTemplates(writer, request, segments[1], segments[3:])
+			return
+		case "strikes":
+			server.handleStrikes(writer, request, segments[1], segments[3:])
+			return
+		case "permissions":
+			if len(segments) == 3 {
+				server.handlePermissions(writer, request, segments[1])
+				return
+			}
+		case "permissive":
+			if len(segments) == 3 {
+				server.handlePermissive(writer, request, segments[1])
+				return
+			}
+		}
+	case len(segments) == 3 && segments[0] == "users" && segments[2] == "reactions":
+		if !snowflake.MatchString(segments[1]) {
+			writeError(writer, http.StatusBadRequest, "user must be a Discord ID")
+			return
+		}
+		server.MetricsClient.Count(apiServiceName, "Request", map[string]string{"Resource": segments[2]})
+		server.handleReactions(writer, request, segments[1])
+		return
+	}
+	writeError(writer, http.StatusNotFound, "not found")
+}
+
+// authenticate checks a request's bearer token in constant time
+func (server *Server) authenticate(request *http.Request) bool {
+	token := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	return server.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(server.Token)) == 1
+}
+
+// writeFailure replies to a request whose service call failed
+// services log their own errors, so only the reply is written here
+func (server *Server) writeFailure(writer http.ResponseWriter, err error) {
+	if userErr, ok := err.(*flamingoservice.UserError); ok {
+		writeError(writer, http.StatusBadRequest, userErr.Reason)
+		return
+	}
+	if flamingoservice.IsTimeout(err) {
+		writeError(writer, http.StatusGatewayTimeout, "the request timed out")
+		return
+	}
+	server.MetricsClient.Count(apiServiceName, "Failed", nil)
+	writeError(writer, http.StatusInternalServerError, "an error occurred, please try again later")
+}
+
+// readJSON decodes a request body, replying with a bad request if it can't be decoded
+func readJSON(writer http.ResponseWriter, request *http.Request, body interface{}) bool {
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(body); err != nil {
+		writeError(writer, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(writer http.ResponseWriter, status int, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(body)
+}
+
+func writeError(writer http.ResponseWriter, status int, message string) {
+	writeJSON(writer, status, apiError{Error: message})
+}
+
+func writeMethodNotAllowed(writer http.ResponseWriter, allowed ...string) {
+	writer.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(writer, http.StatusMethodNotAllowed, "method not allowed")
+}
@@ -0,0 +1,183 @@
+package flamingoapi
+
+import (
+	"FlamingoV2/assets"
+	"FlamingoV2/flamingolog"
+	"FlamingoV2/flamingoservice"
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	healthServiceName = "Health"
+	// heartbeatAckTimeout is how long the gateway may go without acknowledging a heartbeat before Flamingo is unready
+	// Discord asks for a heartbeat roughly every 41 seconds, so this allows a couple to be missed
+	heartbeatAckTimeout = 2 * time.Minute
+	// storagePingTimeout bounds each storage check, so a hung dependency fails readiness rather than the probe
+	storagePingTimeout = 3 * time.Second
+)
+
+// HealthServer is responsible for serving liveness and readiness probes for container orchestrators
+// probes are unauthenticated, so it listens separately from the admin API
+type HealthServer struct {
+	Version             string
+	Started             time.Time
+	Shards              *flamingoservice.Shards
+	DynamoClient        *dynamodb.DynamoDB
+	S3Client            *s3.S3
+	MetricsClient       *flamingolog.FlamingoMetricsClient
+	HTTPServer          *http.Server
+	HealthServiceLogger *log.Logger
+	HealthErrorLogger   *log.Logger
+}
+
+// healthCheck is the outcome of one readiness check
+type healthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// healthStatus is the body of every probe response
+type healthStatus struct {
+	Status  string                 `json:"status"`
+	Version string                 `json:"version"`
+	Uptime  string                 `json:"uptime"`
+	Checks  map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// NewHealthServer constructs a HealthServer listening on listen, reporting version and uptime since started
+func NewHealthServer(listen, version string,
+	started time.Time,
+	shards *flamingoservice.Shards,
+	dynamoClient *dynamodb.DynamoDB,
+	s3Client *s3.S3,
+	metricsClient *flamingolog.FlamingoMetricsClient) *HealthServer {
+	server := &HealthServer{
+		Version:             version,
+		Started:             started,
+		Shards:              shards,
+		DynamoClient:        dynamoClient,
+		S3Client:            s3Client,
+		MetricsClient:       metricsClient,
+		HealthServiceLogger: flamingolog.BuildServiceLogger(healthServiceName),
+		HealthErrorLogger:   flamingolog.BuildServiceErrorLogger(healthServiceName),
+	}
+	server.HTTPServer = &http.Server{
+		Addr:              listen,
+		Handler:           server,
+		ReadHeaderTimeout: 5 * time.Second,
+		ErrorLog:          server.HealthErrorLogger,
+	}
+	return server
+}
+
+// ListenAndServe serves the probes until they are shut down
+func (server *HealthServer) ListenAndServe() error {
+	server.HealthServiceLogger.Println("Listening on " + server.HTTPServer.Addr)
+	err := server.HTTPServer.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting probes and waits up to timeout for in-flight probes to finish
+func (server *HealthServer) Shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return server.HTTPServer.Shutdown(ctx)
+}
+
+// ServeHTTP answers /healthz while the process is running, and /readyz once Flamingo can handle commands
+func (server *HealthServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writeMethodNotAllowed(writer, http.MethodGet, http.MethodHead)
+		return
+	}
+	status := healthStatus{
+		Status:  "ok",
+		Version: server.Version,
+		Uptime:  time.Since(server.Started).Truncate(time.Second).String(),
+	}
+	switch request.URL.Path {
+	case "/healthz":
+		writeJSON(writer, http.StatusOK, status)
+	case "/readyz":
+		status.Checks = server.checkReadiness(request.Context())
+		for name, check := range status.Checks {
+			if !check.OK {
+				status.Status = "unready"
+				server.MetricsClient.Count(healthServiceName, "Unready", map[string]string{"Check": name})
+			}
+		}
+		if status.Status != "ok" {
+			writeJSON(writer, http.StatusServiceUnavailable, status)
+			return
+		}
+		writeJSON(writer, http.StatusOK, status)
+	default:
+		writeError(writer, http.StatusNotFound, "not found")
+	}
+}
+
+// checkReadiness checks every shard is connected and heartbeating, and that storage is reachable
+func (server *HealthServer) checkReadiness(ctx context.Context) map[string]healthCheck {
+	checks := make(map[string]healthCheck, 4)
+	disconnected := make([]string, 0)
+	stale := make([]string, 0)
+	var slowest time.Duration
+	for _, id := range server.Shards.IDs {
+		session := server.Shards.Sessions[id]
+		session.RLock()
+		ready := session.DataReady
+		lastAck := session.LastHeartbeatAck
+		session.RUnlock()
+		if !ready {
+			disconnected = append(disconnected, strconv.Itoa(id))
+		}
+		since := time.Since(lastAck)
+		if lastAck.IsZero() || since > heartbeatAckTimeout {
+			stale = append(stale, strconv.Itoa(id))
+		} else if since > slowest {
+			slowest = since
+		}
+	}
+	if len(disconnected) == 0 {
+		checks["gateway"] = healthCheck{OK: true, Detail: strconv.Itoa(len(server.Shards.IDs)) + " of " + strconv.Itoa(server.Shards.Count) + " shard(s) connected"}
+	} else {
+		checks["gateway"] = healthCheck{Detail: "shard(s) " + strings.Join(disconnected, ", ") + " not connected to the Discord gateway"}
+	}
+	if len(stale) == 0 {
+		checks["heartbeat"] = healthCheck{OK: true, Detail: "acknowledged within " + slowest.Truncate(time.Second).String()}
+	} else {
+		checks["heartbeat"] = healthCheck{Detail: "shard(s) " + strings.Join(stale, ", ") + " have not had a heartbeat acknowledged in " + heartbeatAckTimeout.String()}
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, storagePingTimeout)
+	defer cancel()
+	_, err := server.DynamoClient.DescribeTableWithContext(pingCtx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(assets.SettingsTableName),
+	})
+	checks["dynamodb"] = server.storageCheck(err)
+	_, err = server.S3Client.HeadBucketWithContext(pingCtx, &s3.HeadBucketInput{
+		Bucket: aws.String(assets.BucketName),
+	})
+	checks["s3"] = server.storageCheck(err)
+	return checks
+}
+
+func (server *HealthServer) storageCheck(err error) healthCheck {
+	if err != nil {
+		server.HealthErrorLogger.Println(err)
+		return healthCheck{Detail: "unreachable"}
+	}
+	return healthCheck{OK: true}
+}
@@ -0,0 +1,466 @@
+package flamingoapi
+
+import (
+	"FlamingoV2/flamingoservice"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// contentResource is the representation of a pasta or template
+type contentResource struct {
+	Alias   string `json:"alias"`
+	Owner   string `json:"owner"`
+	Content string `json:"content"`
+}
+
+// strikeResource is the representation of a user's strikes
+type strikeResource struct {
+	User    string `json:"user"`
+	Strikes int    `json:"strikes"`
+}
+
+// permissionRule is the representation of a permission rule, naming either a user or a role
+// an empty action applies to every action of the command, and a rule without a channel or category applies guild-wide
+type permissionRule struct {
+	Command  string `json:"command"`
+	Action   string `json:"action,omitempty"`
+	User     string `json:"user,omitempty"`
+	Role     string `json:"role,omitempty"`
+	Channel  string `json:"channel,omitempty"`
+	Category string `json:"category,omitempty"`
+	Allow    bool   `json:"allow"`
+}
+
+// permissiveResource is the representation of a guild's permissive flag
+type permissiveResource struct {
+	Permissive bool `json:"permissive"`
+}
+
+// reactionResource is the representation of a user's reaction
+type reactionResource struct {
+	Alias string   `json:"alias"`
+	Tags  []string `json:"tags"`
+	Saved int64    `json:"saved"`
+	URL   string   `json:"url"`
+}
+
+// handlePastas serves /guilds/{guild}/pastas and /guilds/{guild}/pastas/{alias}
+func (server *Server) handlePastas(writer http.ResponseWriter, request *http.Request, guildID string, rest []string) {
+	ctx := request.Context()
+	if len(rest) == 0 {
+		switch request.Method {
+		case http.MethodGet:
+			pastas, err := server.PastaClient.ListPastas(ctx, guildID)
+			if err != nil {
+				server.writeFailure(writer, err)
+				return
+			}
+			resources := make([]contentResource, 0, len(pastas))
+			for _, pasta := range pastas {
+				resources = append(resources, contentResource{Alias: pasta.Alias, Owner: pasta.Owner, Content: pasta.Pasta})
+			}
+			writeJSON(writer, http.StatusOK, resources)
+		case http.MethodPost:
+			resource := contentResource{}
+			if !readJSON(writer, request, &resource) || !validateContent(writer, resource, true) {
+				return
+			}
+			saved, err := server.PastaClient.SavePasta(ctx, guildID, resource.Owner, resource.Alias, resource.Content)
+			if err != nil {
+				server.writeFailure(writer, err)
+				return
+			}
+			if !saved {
+				writeError(writer, http.StatusConflict, "a pasta with alias "+resource.Alias+" already exists")
+				return
+			}
+			writeJSON(writer, http.StatusCreated, resource)
+		default:
+			writeMethodNotAllowed(writer, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+	if len(rest) > 1 {
+		writeError(writer, http.StatusNotFound, "not found")
+		return
+	}
+	alias := rest[0]
+	switch request.Method {
+	case http.MethodGet:
+		pasta, err := server.PastaClient.LookupPasta(ctx, guildID, alias)
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		if pasta == nil {
+			writeError(writer, http.StatusNotFound, "no pasta with alias "+alias)
+			return
+		}
+		writeJSON(writer, http.StatusOK, contentResource{Alias: pasta.Alias, Owner: pasta.Owner, Content: pasta.Pasta})
+	case http.MethodPut:
+		resource := contentResource{}
+		if !readJSON(writer, request, &resource) {
+			return
+		}
+		resource.Alias = alias
+		if !validateContent(writer, resource, false) {
+			return
+		}
+		replaced, err := server.PastaClient.ReplacePasta(ctx, guildID, flamingoservice.AuditAPI, alias, resource.Content)
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		if !replaced {
+			writeError(writer, http.StatusNotFound, "no pasta with alias "+alias)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		deleted, err := server.PastaClient.DeletePasta(ctx, guildID, flamingoservice.AuditAPI, alias)
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		if !deleted {
+			writeError(writer, http.StatusNotFound, "no pasta with alias "+alias)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(writer, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// handleTemplates serves /guilds/{guild}/templates and /guilds/{guild}/templates/{alias}
+func (server *Server) handleTemplates(writer http.ResponseWriter, request *http.Request, guildID string, rest []string) {
+	ctx := request.Context()
+	if len(rest) == 0 {
+		switch request.Method {
+		case http.MethodGet:
+			templates, err := server.TemplateClient.ListTemplates(ctx, guildID)
+			if err != nil {
+				server.writeFailure(writer, err)
+				return
+			}
+			resources := make([]contentResource, 0, len(templates))
+			for _, template := range templates {
+				resources = append(resources, contentResource{Alias: template.Alias, Owner: template.Owner, Content: template.Template})
+			}
+			writeJSON(writer, http.StatusOK, resources)
+		case http.MethodPost:
+			resource := contentResource{}
+			if !readJSON(writer, request, &resource) || !validateContent(writer, resource, true) {
+				return
+			}
+			saved, err := server.TemplateClient.SaveTemplate(ctx, guildID, resource.Owner, resource.Alias, resource.Content)
+			if err != nil {
+				server.writeFailure(writer, err)
+				return
+			}
+			if !saved {
+				writeError(writer, http.StatusConflict, "a template with alias "+resource.Alias+" already exists")
+				return
+			}
+			writeJSON(writer, http.StatusCreated, resource)
+		default:
+			writeMethodNotAllowed(writer, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+	if len(rest) > 1 {
+		writeError(writer, http.StatusNotFound, "not found")
+		return
+	}
+	alias := rest[0]
+	switch request.Method {
+	case http.MethodGet:
+		template, err := server.TemplateClient.LookupTemplate(ctx, guildID, alias)
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		if template == nil {
+			writeError(writer, http.StatusNotFound, "no template with alias "+alias)
+			return
+		}
+		writeJSON(writer, http.StatusOK, contentResource{Alias: template.Alias, Owner: template.Owner, Content: template.Template})
+	case http.MethodPut:
+		resource := contentResource{}
+		if !readJSON(writer, request, &resource) {
+			return
+		}
+		resource.Alias = alias
+		if !validateContent(writer, resource, false) {
+			return
+		}
+		replaced, err := server.TemplateClient.ReplaceTemplate(ctx, guildID, flamingoservice.AuditAPI, alias, resource.Content)
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		if !replaced {
+			writeError(writer, http.StatusNotFound, "no template with alias "+alias)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		deleted, err := server.TemplateClient.DeleteTemplate(ctx, guildID, flamingoservice.AuditAPI, alias)
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		if !deleted {
+			writeError(writer, http.StatusNotFound, "no template with alias "+alias)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(writer, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// handleStrikes serves /guilds/{guild}/strikes and /guilds/{guild}/strikes/{user}
+func (server *Server) handleStrikes(writer http.ResponseWriter, request *http.Request, guildID string, rest []string) {
+	ctx := request.Context()
+	if len(rest) == 0 {
+		if request.Method != http.MethodGet {
+			writeMethodNotAllowed(writer, http.MethodGet)
+			return
+		}
+		strikes, err := server.StrikeClient.ListStrikes(ctx, guildID)
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		resources := make([]strikeResource, 0, len(strikes))
+		for _, strike := range strikes {
+			resources = append(resources, strikeResource{User: strings.TrimPrefix(strike.ID, guildID+"!"), Strikes: strike.Strikes})
+		}
+		//Most struck first
+		sort.SliceStable(resources, func(i, j int) bool { return resources[i].Strikes > resources[j].Strikes })
+		writeJSON(writer, http.StatusOK, resources)
+		return
+	}
+	if len(rest) > 1 || !snowflake.MatchString(rest[0]) {
+		writeError(writer, http.StatusNotFound, "not found")
+		return
+	}
+	userID := rest[0]
+	switch request.Method {
+	case http.MethodGet:
+		strikes, err := server.StrikeClient.CountStrikes(ctx, guildID, userID)
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, strikeResource{User: userID, Strikes: strikes})
+	case http.MethodPost:
+		resource := strikeResource{}
+		if !readJSON(writer, request, &resource) {
+			return
+		}
+		if resource.Strikes < 1 {
+			writeError(writer, http.StatusBadRequest, "strikes must be at least 1, use DELETE to clear strikes")
+			return
+		}
+		strikes, err := server.StrikeClient.AddStrikes(ctx, guildID, flamingoservice.AuditAPI, userID, resource.Strikes)
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, strikeResource{User: userID, Strikes: strikes})
+	case http.MethodDelete:
+		_, err := server.StrikeClient.ClearStrikesForUser(ctx, guildID, "", flamingoservice.AuditAPI, userID)
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(writer, http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+}
+
+// handlePermissions serves /guilds/{guild}/permissions
+// rules are deleted by naming them in the query, e.g. ?command=pasta&action=get&role=ID
+func (server *Server) handlePermissions(writer http.ResponseWriter, request *http.Request, guildID string) {
+	ctx := request.Context()
+	switch request.Method {
+	case http.MethodGet:
+		permissions, err := server.AuthClient.ListPermissionRules(ctx, guildID)
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		rules := make([]permissionRule, 0, len(permissions))
+		for _, permission := range permissions {
+			//guild!command!action, the permissive flag has no command and is served separately
+			hashKey := strings.SplitN(permission.Guild, "!", 3)
+			scope, kind, ID := flamingoservice.SplitPermission(permission.Permission)
+			if len(hashKey) < 3 || hashKey[1] == "" || kind == "" {
+				continue
+			}
+			rule := permissionRule{Command: hashKey[1], Action: hashKey[2], Allow: permission.Allow}
+			if kind == "role" {
+				rule.Role = ID
+			} else {
+				rule.User = ID
+			}
+			if scope.Kind == flamingoservice.CategoryScope {
+				rule.Category = scope.ID
+			} else {
+				rule.Channel = scope.ID
+			}
+			rules = append(rules, rule)
+		}
+		sort.SliceStable(rules, func(i, j int) bool {
+			return rules[i].Command+" "+rules[i].Action < rules[j].Command+" "+rules[j].Action
+		})
+		writeJSON(writer, http.StatusOK, rules)
+	case http.MethodPut:
+		rule := permissionRule{}
+		if !readJSON(writer, request, &rule) || !validateRule(writer, rule) {
+			return
+		}
+		err := server.AuthClient.SetPermission(ctx, guildID, flamingoservice.AuditAPI, rule.User+rule.Role, rule.Command, rule.Action, rule.scope(), rule.Role != "", rule.Allow)
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, rule)
+	case http.MethodDelete:
+		query := request.URL.Query()
+		rule := permissionRule{
+			Command:  query.Get("command"),
+			Action:   query.Get("action"),
+			User:     query.Get("user"),
+			Role:     query.Get("role"),
+			Channel:  query.Get("channel"),
+			Category: query.Get("category"),
+		}
+		if !validateRule(writer, rule) {
+			return
+		}
+		err := server.AuthClient.DeletePermission(ctx, guildID, flamingoservice.AuditAPI, rule.User+rule.Role, rule.Command, rule.Action, rule.scope(), rule.Role != "")
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(writer, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// handlePermissive serves /guilds/{guild}/permissive
+func (server *Server) handlePermissive(writer http.ResponseWriter, request *http.Request, guildID string) {
+	ctx := request.Context()
+	switch request.Method {
+	case http.MethodGet:
+		permissive, err := server.AuthClient.GetPermissiveFlagValue(ctx, guildID)
+		if errors.Is(err, flamingoservice.ErrPermissiveFlagNotFound) {
+			writeError(writer, http.StatusNotFound, "the permissive flag has never been set for this guild")
+			return
+		}
+		if err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, permissiveResource{Permissive: permissive})
+	case http.MethodPut:
+		resource := permissiveResource{}
+		if !readJSON(writer, request, &resource) {
+			return
+		}
+		if err := server.AuthClient.SetPermissiveFlagValue(ctx, guildID, flamingoservice.AuditAPI, resource.Permissive); err != nil {
+			server.writeFailure(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, resource)
+	default:
+		writeMethodNotAllowed(writer, http.MethodGet, http.MethodPut)
+	}
+}
+
+// handleReactions serves /users/{user}/reactions, optionally filtered by ?tag=
+// reactions belong to users rather than guilds, and can only be listed
+func (server *Server) handleReactions(writer http.ResponseWriter, request *http.Request, userID string) {
+	if request.Method != http.MethodGet {
+		writeMethodNotAllowed(writer, http.MethodGet)
+		return
+	}
+	reactions, err := server.ReactClient.ListIndexedReactions(request.Context(), userID, request.URL.Query().Get("tag"))
+	if err != nil {
+		server.writeFailure(writer, err)
+		return
+	}
+	resources := make([]reactionResource, 0, len(reactions))
+	for _, reaction := range reactions {
+		tags := reaction.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		resources = append(resources, reactionResource{Alias: reaction.Alias, Tags: tags, Saved: reaction.Saved, URL: reaction.URL()})
+	}
+	writeJSON(writer, http.StatusOK, resources)
+}
+
+// validateContent checks a pasta or template, the owner is only required when it is created
+func validateContent(writer http.ResponseWriter, resource contentResource, creating bool) bool {
+	switch {
+	case resource.Alias == "" || strings.ContainsAny(resource.Alias, " \t\n/"):
+		writeError(writer, http.StatusBadRequest, "alias must be non-empty and contain no whitespace or slashes")
+	case resource.Content == "":
+		writeError(writer, http.StatusBadRequest, "content cannot be empty")
+	case creating && !snowflake.MatchString(resource.Owner):
+		writeError(writer, http.StatusBadRequest, "owner must be a Discord ID")
+	default:
+		return true
+	}
+	return false
+}
+
+// validateRule checks a permission rule names a known command and action, exactly one user or role, and at most one channel or category
+func validateRule(writer http.ResponseWriter, rule permissionRule) bool {
+	actions, ok := flamingoservice.Commands[rule.Command]
+	switch {
+	case !ok:
+		writeError(writer, http.StatusBadRequest, "unknown command \""+rule.Command+"\"")
+	case rule.Action != "" && !containsString(actions, rule.Action):
+		writeError(writer, http.StatusBadRequest, "unknown action \""+rule.Action+"\" for "+rule.Command+", expected one of "+strings.Join(actions, ", "))
+	case (rule.User == "") == (rule.Role == ""):
+		writeError(writer, http.StatusBadRequest, "exactly one of user or role is required")
+	case !snowflake.MatchString(rule.User + rule.Role):
+		writeError(writer, http.StatusBadRequest, "user and role must be Discord IDs")
+	case rule.Channel != "" && rule.Category != "":
+		writeError(writer, http.StatusBadRequest, "at most one of channel or category is allowed")
+	case rule.Channel+rule.Category != "" && !snowflake.MatchString(rule.Channel+rule.Category):
+		writeError(writer, http.StatusBadRequest, "channel and category must be Discord IDs")
+	default:
+		return true
+	}
+	return false
+}
+
+// scope resolves where a permission rule applies, guild-wide unless it names a channel or category
+func (rule permissionRule) scope() flamingoservice.PermissionScope {
+	switch {
+	case rule.Channel != "":
+		return flamingoservice.PermissionScope{Kind: flamingoservice.ChannelScope, ID: rule.Channel}
+	case rule.Category != "":
+		return flamingoservice.PermissionScope{Kind: flamingoservice.CategoryScope, ID: rule.Category}
+	}
+	return flamingoservice.PermissionScope{}
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
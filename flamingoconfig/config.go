@@ -0,0 +1,673 @@
+package flamingoconfig
+
+import (
+	"FlamingoV2/assets"
+	"FlamingoV2/flamingoservice"
+	"errors"
+	"flag"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+/*
+Configuration is resolved in the following order, each source overriding the last:
+
+1. Defaults (the production deployment)
+2. The TOML file named by -config or FLAMINGO_CONFIG
+3. Environment variables
+4. Command line flags
+*/
+
+const (
+	// MetricsSinkCloudWatch publishes metrics to CloudWatch
+	MetricsSinkCloudWatch = "cloudwatch"
+	// MetricsSinkNone discards metrics
+	MetricsSinkNone = "none"
+)
+
+// Config is the complete runtime configuration of Flamingo
+type Config struct {
+	Token      string           `toml:"token"`
+	Prefix     string           `toml:"prefix"`
+	Services   []string         `toml:"services"`
+	AWS        AWSConfig        `toml:"aws"`
+	Bucket     string           `toml:"bucket"`
+	Tables     TablesConfig     `toml:"tables"`
+	Sinks      SinksConfig      `toml:"sinks"`
+	Workers    WorkersConfig    `toml:"workers"`
+	RateLimits RateLimitsConfig `toml:"rate_limits"`
+	Cache      CacheConfig      `toml:"cache"`
+	API        APIConfig        `toml:"api"`
+	Dashboard  DashboardConfig  `toml:"dashboard"`
+	Health     HealthConfig     `toml:"health"`
+	Shards     ShardsConfig     `toml:"shards"`
+	Auth       AuthConfig       `toml:"auth"`
+	// ShutdownTimeout bounds how long in-flight commands are given to finish on shutdown
+	ShutdownTimeout Duration `toml:"shutdown_timeout"`
+	// CommandTimeout bounds how long a command may run before it is cancelled
+	CommandTimeout Duration `toml:"command_timeout"`
+	// CommandTimeouts overrides CommandTimeout for individual services
+	CommandTimeouts map[string]Duration `toml:"command_timeouts"`
+	// PurgeGrace is how long a guild's data is kept after Flamingo is removed from it, zero keeps it forever
+	PurgeGrace Duration `toml:"purge_grace"`
+	// unknownKeys are the keys of the config file that match no setting, usually typos
+	unknownKeys []string
+}
+
+// AWSConfig describes how Flamingo authenticates with AWS
+// if no access key is given, the default credential chain (environment, shared config, ECS task role, instance profile) is used
+type AWSConfig struct {
+	Region    string `toml:"region"`
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+	Profile   string `toml:"profile"`
+	RoleARN   string `toml:"role_arn"`
+	// Endpoint overrides the endpoint of every AWS client, e.g. to use LocalStack
+	Endpoint    string          `toml:"endpoint"`
+	Endpoints   EndpointsConfig `toml:"endpoints"`
+	S3PathStyle bool            `toml:"s3_path_style"`
+}
+
+// EndpointsConfig overrides the endpoint of individual AWS clients, e.g. to use DynamoDB Local or MinIO
+type EndpointsConfig struct {
+	DynamoDB   string `toml:"dynamodb"`
+	S3         string `toml:"s3"`
+	CloudWatch string `toml:"cloudwatch"`
+}
+
+// TablesConfig names the DDB tables Flamingo persists to
+type TablesConfig struct {
+	Strike    string `toml:"strike"`
+	Pasta     string `toml:"pasta"`
+	Auth      string `toml:"auth"`
+	Reaction  string `toml:"reaction"`
+	Promotion string `toml:"promotion"`
+	Settings  string `toml:"settings"`
+	Audit     string `toml:"audit"`
+}
+
+// WorkersConfig bounds how many commands are handled at once
+type WorkersConfig struct {
+	// Concurrency is the number of commands handled at once across every guild
+	Concurrency int `toml:"concurrency"`
+	// GuildConcurrency is the number of commands handled at once for a single guild
+	GuildConcurrency int `toml:"guild_concurrency"`
+	// GuildQueue is the number of commands that may wait in a single guild before more are dropped
+	GuildQueue int `toml:"guild_queue"`
+}
+
+// RateLimitsConfig sets cooldowns on commands per guild and user
+type RateLimitsConfig struct {
+	Default RateLimitConfig `toml:"default"`
+	// Commands overrides Default, keyed by command or command and action separated by a space, e.g. "strike super"
+	Commands map[string]RateLimitConfig `toml:"commands"`
+	// ExemptRoles are the IDs of roles that are never rate limited, server administrators are always exempt
+	ExemptRoles []string `toml:"exempt_roles"`
+}
+
+// RateLimitConfig allows bursts of up to Burst commands, refilling one every Every
+// a zero Burst disables rate limiting
+type RateLimitConfig struct {
+	Burst int      `toml:"burst"`
+	Every Duration `toml:"every"`
+}
+
+// CacheConfig sizes the in-process caches of pastas, templates and permissions
+type CacheConfig struct {
+	// Capacity is the number of entries each cache holds, 0 disables caching
+	Capacity int      `toml:"capacity"`
+	TTL      Duration `toml:"ttl"`
+}
+
+// APIConfig configures the admin HTTP API, which is disabled unless Listen is set
+type APIConfig struct {
+	// Listen is the address the API listens on, e.g. ":8080"
+	Listen string `toml:"listen"`
+	// Token is the bearer token every request must present
+	Token string `toml:"token"`
+}
+
+// DashboardConfig configures the web dashboard, which is disabled unless Listen is set
+type DashboardConfig struct {
+	// Listen is the address the dashboard listens on, e.g. ":8081"
+	Listen string `toml:"listen"`
+	// URL is where users reach the dashboard, Discord redirects back to URL/callback after login
+	URL string `toml:"url"`
+	// ClientID and ClientSecret identify Flamingo's Discord application
+	ClientID     string `toml:"client_id"`
+	ClientSecret string `toml:"client_secret"`
+	// DiscordAPI is the base URL of the Discord API
+	DiscordAPI string `toml:"discord_api"`
+	// SessionTTL is how long a login lasts
+	SessionTTL Duration `toml:"session_ttl"`
+}
+
+// HealthConfig configures the liveness and readiness probes, which are disabled unless Listen is set
+type HealthConfig struct {
+	// Listen is the address the probes are served on, e.g. ":8082"
+	Listen string `toml:"listen"`
+}
+
+// ShardsConfig configures gateway sharding, by default every shard Discord recommends is run in one process
+type ShardsConfig struct {
+	// Count is the number of shards across every process, 0 uses the count Discord recommends
+	Count int `toml:"count"`
+	// IDs are the shards this process runs, empty runs every shard
+	IDs []int `toml:"ids"`
+}
+
+// AuthConfig configures permission rules
+type AuthConfig struct {
+	// Enforce applies permission rules to commands, every command is allowed while it is unset
+	Enforce bool `toml:"enforce"`
+}
+
+// SinksConfig selects where telemetry is sent
+type SinksConfig struct {
+	Metrics string `toml:"metrics"`
+}
+
+// binding ties a config field to the environment variables and flag that can set it
+type binding struct {
+	value flag.Value
+	env   []string
+	flag  string
+	usage string
+}
+
+// pendingFlag records a flag so it can be applied after the config file and environment
+type pendingFlag struct {
+	raw    string
+	isBool bool
+}
+
+func (pending *pendingFlag) String() string       { return pending.raw }
+func (pending *pendingFlag) Set(raw string) error { pending.raw = raw; return nil }
+func (pending *pendingFlag) IsBoolFlag() bool     { return pending.isBool }
+
+// Default constructs the configuration of the production deployment
+func Default() *Config {
+	return &Config{
+		Prefix:   flamingoservice.CommandPrefix,
+		Services: serviceNames(),
+		Bucket:   assets.BucketName,
+		Tables: TablesConfig{
+			Strike:    assets.StrikeTableName,
+			Pasta:     assets.PastaTableName,
+			Auth:      assets.AuthTableName,
+			Reaction:  assets.ReactionTableName,
+			Promotion: assets.PromotionTableName,
+			Settings:  assets.SettingsTableName,
+			Audit:     assets.AuditTableName,
+		},
+		Sinks: SinksConfig{
+			Metrics: MetricsSinkCloudWatch,
+		},
+		Workers: WorkersConfig{
+			Concurrency:      32,
+			GuildConcurrency: 4,
+			GuildQueue:       16,
+		},
+		RateLimits: RateLimitsConfig{
+			Default: RateLimitConfig{Burst: 5, Every: Duration{5 * time.Second}},
+		},
+		Cache: CacheConfig{
+			Capacity: 1000,
+			TTL:      Duration{5 * time.Minute},
+		},
+		Dashboard: DashboardConfig{
+			DiscordAPI: "https://discord.com/api",
+			SessionTTL: Duration{12 * time.Hour},
+		},
+		ShutdownTimeout: Duration{30 * time.Second},
+		CommandTimeout:  Duration{10 * time.Second},
+		//Reactions are downloaded and resized, which can be slow for large images
+		//and archives read or write every item a guild has saved
+		CommandTimeouts: map[string]Duration{
+			"react":    {30 * time.Second},
+			"flamingo": {2 * time.Minute},
+		},
+		PurgeGrace: Duration{30 * 24 * time.Hour},
+	}
+}
+
+// Load resolves the configuration from defaults, the config file, the environment and args, in that order
+func Load(args []string) (*Config, error) {
+	config := Default()
+	var configPath string
+	var local bool
+	flags := flag.NewFlagSet("flamingo", flag.ContinueOnError)
+	flags.StringVar(&configPath, "config", os.Getenv("FLAMINGO_CONFIG"), "Path to a TOML config file.")
+	flags.BoolVar(&local, "local", false, "Deprecated. Equivalent to -metrics=none.")
+	bindings := config.bindings()
+	pending := make(map[string]*pendingFlag, len(bindings))
+	for _, b := range bindings {
+		_, isBool := b.value.(*boolValue)
+		pending[b.flag] = &pendingFlag{isBool: isBool}
+		flags.Var(pending[b.flag], b.flag, b.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if configPath != "" {
+		metadata, err := toml.DecodeFile(configPath, config)
+		if err != nil {
+			return nil, errors.New("could not read config file " + configPath + ": " + err.Error())
+		}
+		for _, key := range metadata.Undecoded() {
+			config.unknownKeys = append(config.unknownKeys, key.String())
+		}
+	}
+
+	for _, b := range bindings {
+		for _, env := range b.env {
+			if raw, ok := os.LookupEnv(env); ok {
+				if err := b.value.Set(raw); err != nil {
+					return nil, errors.New(env + ": " + err.Error())
+				}
+			}
+		}
+	}
+
+	//Only flags that were passed override, so empty defaults don't clobber other sources
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "local" {
+			if local {
+				config.Sinks.Metrics = MetricsSinkNone
+			}
+			return
+		}
+		for _, b := range bindings {
+			if b.flag == f.Name {
+				if err := b.value.Set(pending[f.Name].raw); err != nil && flagErr == nil {
+					flagErr = errors.New("-" + f.Name + ": " + err.Error())
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+	return config, config.Validate()
+}
+
+// Validate reports every problem with the configuration at once
+func (config *Config) Validate() error {
+	problems := make([]string, 0)
+	for _, key := range config.unknownKeys {
+		problems = append(problems, key+" is not a known setting")
+	}
+	if config.Token == "" {
+		problems = append(problems, "a Discord token is required (token, DISCORD_TOKEN or -t)")
+	}
+	if config.Prefix == "" || strings.ContainsAny(config.Prefix, " \t\n") {
+		problems = append(problems, "prefix must be non-empty and contain no whitespace")
+	}
+	if config.AWS.Region == "" {
+		problems = append(problems, "an AWS region is required (aws.region, REGION or -r)")
+	}
+	if (config.AWS.AccessKey == "") != (config.AWS.SecretKey == "") {
+		problems = append(problems, "aws.access_key and aws.secret_key must be set together, or both left empty to use the default credential chain")
+	}
+	if config.AWS.AccessKey != "" && config.AWS.Profile != "" {
+		problems = append(problems, "aws.profile cannot be used with static credentials")
+	}
+	if config.AWS.RoleARN != "" && !strings.HasPrefix(config.AWS.RoleARN, "arn:") {
+		problems = append(problems, "aws.role_arn must be an ARN, got \""+config.AWS.RoleARN+"\"")
+	}
+	for name, endpoint := range map[string]string{
+		"aws.endpoint":             config.AWS.Endpoint,
+		"aws.endpoints.dynamodb":   config.AWS.Endpoints.DynamoDB,
+		"aws.endpoints.s3":         config.AWS.Endpoints.S3,
+		"aws.endpoints.cloudwatch": config.AWS.Endpoints.CloudWatch,
+	} {
+		if endpoint == "" {
+			continue
+		}
+		if parsed, err := url.Parse(endpoint); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			problems = append(problems, name+" must be an http(s) URL, got \""+endpoint+"\"")
+		}
+	}
+	if config.Bucket == "" {
+		problems = append(problems, "bucket cannot be empty")
+	}
+	for name, table := range map[string]string{
+		"strike":    config.Tables.Strike,
+		"pasta":     config.Tables.Pasta,
+		"auth":      config.Tables.Auth,
+		"reaction":  config.Tables.Reaction,
+		"promotion": config.Tables.Promotion,
+		"settings":  config.Tables.Settings,
+		"audit":     config.Tables.Audit,
+	} {
+		if table == "" {
+			problems = append(problems, "tables."+name+" cannot be empty")
+		}
+	}
+	known := serviceNames()
+	for _, service := range config.Services {
+		if _, ok := flamingoservice.Commands[service]; !ok {
+			problems = append(problems, "unknown service \""+service+"\", expected one of "+strings.Join(known, ", "))
+		}
+	}
+	if !config.ServiceEnabled("auth") {
+		problems = append(problems, "the auth service cannot be disabled, permissions would be unmanageable")
+	}
+	if config.Sinks.Metrics != MetricsSinkCloudWatch && config.Sinks.Metrics != MetricsSinkNone {
+		problems = append(problems, "sinks.metrics must be \""+MetricsSinkCloudWatch+"\" or \""+MetricsSinkNone+"\"")
+	}
+	if config.Workers.Concurrency < 1 || config.Workers.GuildConcurrency < 1 || config.Workers.GuildQueue < 1 {
+		problems = append(problems, "workers.concurrency, workers.guild_concurrency and workers.guild_queue must be at least 1")
+	}
+	problems = append(problems, validateRateLimit("rate_limits.default", config.RateLimits.Default)...)
+	for key, limit := range config.RateLimits.Commands {
+		name := "rate_limits.commands.\"" + key + "\""
+		commandAction := strings.SplitN(key, " ", 2)
+		actions, ok := flamingoservice.Commands[commandAction[0]]
+		if !ok {
+			problems = append(problems, name+" has unknown command \""+commandAction[0]+"\", expected one of "+strings.Join(known, ", "))
+		} else if len(commandAction) > 1 && !containsString(actions, commandAction[1]) {
+			problems = append(problems, name+" has unknown action \""+commandAction[1]+"\", expected one of "+strings.Join(actions, ", "))
+		}
+		problems = append(problems, validateRateLimit(name, limit)...)
+	}
+	if config.Cache.Capacity < 0 {
+		problems = append(problems, "cache.capacity cannot be negative")
+	}
+	if config.Cache.Capacity > 0 && config.Cache.TTL.Duration <= 0 {
+		problems = append(problems, "cache.ttl must be positive")
+	}
+	if config.API.Listen != "" && len(config.API.Token) < 16 {
+		problems = append(problems, "api.token must be at least 16 characters when api.listen is set")
+	}
+	if config.Dashboard.Listen != "" {
+		if config.Dashboard.ClientID == "" || config.Dashboard.ClientSecret == "" {
+			problems = append(problems, "dashboard.client_id and dashboard.client_secret are required when dashboard.listen is set")
+		}
+		for name, endpoint := range map[string]string{
+			"dashboard.url":         config.Dashboard.URL,
+			"dashboard.discord_api": config.Dashboard.DiscordAPI,
+		} {
+			if parsed, err := url.Parse(endpoint); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				problems = append(problems, name+" must be an http(s) URL when dashboard.listen is set, got \""+endpoint+"\"")
+			}
+		}
+		if config.Dashboard.SessionTTL.Duration <= 0 {
+			problems = append(problems, "dashboard.session_ttl must be positive")
+		}
+	}
+	if config.Shards.Count < 0 {
+		problems = append(problems, "shards.count cannot be negative")
+	}
+	if len(config.Shards.IDs) > 0 && config.Shards.Count < 1 {
+		problems = append(problems, "shards.count must be set when shards.ids is set, so every process agrees on it")
+	}
+	seenShards := make(map[int]bool, len(config.Shards.IDs))
+	for _, id := range config.Shards.IDs {
+		if config.Shards.Count > 0 && (id < 0 || id >= config.Shards.Count) {
+			problems = append(problems, "shards.ids has "+strconv.Itoa(id)+", expected 0 to "+strconv.Itoa(config.Shards.Count-1))
+		}
+		if seenShards[id] {
+			problems = append(problems, "shards.ids has "+strconv.Itoa(id)+" more than once")
+		}
+		seenShards[id] = true
+	}
+	listeners := make(map[string]string)
+	for _, listener := range [][2]string{
+		{"api.listen", config.API.Listen},
+		{"dashboard.listen", config.Dashboard.Listen},
+		{"health.listen", config.Health.Listen},
+	} {
+		if listener[1] == "" {
+			continue
+		}
+		if other, ok := listeners[listener[1]]; ok {
+			problems = append(problems, listener[0]+" cannot be the same address as "+other)
+		}
+		listeners[listener[1]] = listener[0]
+	}
+	if config.ShutdownTimeout.Duration <= 0 {
+		problems = append(problems, "shutdown_timeout must be positive")
+	}
+	if config.CommandTimeout.Duration <= 0 {
+		problems = append(problems, "command_timeout must be positive")
+	}
+	if config.PurgeGrace.Duration < 0 {
+		problems = append(problems, "purge_grace cannot be negative")
+	}
+	for service, timeout := range config.CommandTimeouts {
+		if _, ok := flamingoservice.Commands[service]; !ok {
+			problems = append(problems, "command_timeouts has unknown service \""+service+"\", expected one of "+strings.Join(known, ", "))
+		}
+		if timeout.Duration <= 0 {
+			problems = append(problems, "command_timeouts."+service+" must be positive")
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New("invalid configuration:\n\t" + strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// Apply publishes the configured prefix and resource names to the packages that use them
+func (config *Config) Apply() {
+	flamingoservice.CommandPrefix = config.Prefix
+	assets.BucketName = config.Bucket
+	assets.BucketURL = config.bucketURL()
+	assets.StrikeTableName = config.Tables.Strike
+	assets.PastaTableName = config.Tables.Pasta
+	assets.AuthTableName = config.Tables.Auth
+	assets.ReactionTableName = config.Tables.Reaction
+	assets.PromotionTableName = config.Tables.Promotion
+	assets.SettingsTableName = config.Tables.Settings
+	assets.AuditTableName = config.Tables.Audit
+}
+
+// bucketURL resolves where the bucket's objects are served from, following the S3 endpoint and addressing style
+func (config *Config) bucketURL() string {
+	endpoint := config.AWS.Endpoints.S3
+	if endpoint == "" {
+		endpoint = config.AWS.Endpoint
+	}
+	if endpoint == "" {
+		return "https://s3.amazonaws.com/" + config.Bucket
+	}
+	//The AWS SDK assumes https for endpoints without a scheme
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	endpointURL, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || config.AWS.S3PathStyle {
+		return strings.TrimSuffix(endpoint, "/") + "/" + config.Bucket
+	}
+	endpointURL.Host = config.Bucket + "." + endpointURL.Host
+	return endpointURL.String()
+}
+
+// TimeoutFor resolves how long a command for a service may run
+func (config *Config) TimeoutFor(service string) time.Duration {
+	if timeout, ok := config.CommandTimeouts[service]; ok {
+		return timeout.Duration
+	}
+	return config.CommandTimeout.Duration
+}
+
+// ServiceEnabled checks if a service is enabled
+func (config *Config) ServiceEnabled(service string) bool {
+	return containsString(config.Services, service)
+}
+
+// bindings lists the environment variables and flags for each scalar setting
+// the unprefixed environment variables and short flags predate the config file and are kept for compatibility
+func (config *Config) bindings() []binding {
+	return []binding{
+		{(*stringValue)(&config.Token), []string{"DISCORD_TOKEN", "FLAMINGO_TOKEN"}, "t", "Discord bot token."},
+		{(*stringValue)(&config.Prefix), []string{"FLAMINGO_PREFIX"}, "prefix", "Command prefix."},
+		{(*listValue)(&config.Services), []string{"FLAMINGO_SERVICES"}, "services", "Comma separated list of services to enable."},
+		{(*stringValue)(&config.AWS.Region), []string{"REGION", "FLAMINGO_AWS_REGION"}, "r", "AWS Region"},
+		{(*stringValue)(&config.AWS.AccessKey), []string{"AWS_ACCESS_KEY", "FLAMINGO_AWS_ACCESS_KEY"}, "ak", "AWS Access Key"},
+		{(*stringValue)(&config.AWS.SecretKey), []string{"AWS_SECRET_KEY", "FLAMINGO_AWS_SECRET_KEY"}, "sk", "AWS Secret Key"},
+		{(*stringValue)(&config.AWS.Profile), []string{"FLAMINGO_AWS_PROFILE"}, "profile", "AWS shared config profile."},
+		{(*stringValue)(&config.AWS.RoleARN), []string{"FLAMINGO_AWS_ROLE_ARN"}, "role", "ARN of an IAM role to assume."},
+		{(*stringValue)(&config.AWS.Endpoint), []string{"FLAMINGO_AWS_ENDPOINT"}, "endpoint", "Endpoint for every AWS client."},
+		{(*stringValue)(&config.AWS.Endpoints.DynamoDB), []string{"FLAMINGO_DYNAMODB_ENDPOINT"}, "dynamodb-endpoint", "DynamoDB endpoint."},
+		{(*stringValue)(&config.AWS.Endpoints.S3), []string{"FLAMINGO_S3_ENDPOINT"}, "s3-endpoint", "S3 endpoint."},
+		{(*stringValue)(&config.AWS.Endpoints.CloudWatch), []string{"FLAMINGO_CLOUDWATCH_ENDPOINT"}, "cloudwatch-endpoint", "CloudWatch endpoint."},
+		{(*boolValue)(&config.AWS.S3PathStyle), []string{"FLAMINGO_S3_PATH_STYLE"}, "s3-path-style", "Use path-style S3 addressing."},
+		{(*stringValue)(&config.Bucket), []string{"FLAMINGO_BUCKET"}, "bucket", "S3 bucket for assets."},
+		{(*stringValue)(&config.Tables.Strike), []string{"FLAMINGO_STRIKE_TABLE"}, "strike-table", "DDB table for strikes."},
+		{(*stringValue)(&config.Tables.Pasta), []string{"FLAMINGO_PASTA_TABLE"}, "pasta-table", "DDB table for pastas and templates."},
+		{(*stringValue)(&config.Tables.Auth), []string{"FLAMINGO_AUTH_TABLE"}, "auth-table", "DDB table for permissions."},
+		{(*stringValue)(&config.Tables.Reaction), []string{"FLAMINGO_REACTION_TABLE"}, "reaction-table", "DDB table for reaction metadata."},
+		{(*stringValue)(&config.Tables.Promotion), []string{"FLAMINGO_PROMOTION_TABLE"}, "promotion-table", "DDB table for reaction promotions."},
+		{(*stringValue)(&config.Tables.Settings), []string{"FLAMINGO_SETTINGS_TABLE"}, "settings-table", "DDB table for guild and user settings."},
+		{(*stringValue)(&config.Tables.Audit), []string{"FLAMINGO_AUDIT_TABLE"}, "audit-table", "DDB table for audit entries."},
+		{(*stringValue)(&config.Sinks.Metrics), []string{"FLAMINGO_METRICS_SINK"}, "metrics", "Metrics sink, cloudwatch or none."},
+		{(*intValue)(&config.Workers.Concurrency), []string{"FLAMINGO_WORKERS"}, "workers", "Number of commands handled at once."},
+		{(*intValue)(&config.Workers.GuildConcurrency), []string{"FLAMINGO_GUILD_WORKERS"}, "guild-workers", "Number of commands handled at once per guild."},
+		{(*intValue)(&config.Workers.GuildQueue), []string{"FLAMINGO_GUILD_QUEUE"}, "guild-queue", "Number of commands that may wait per guild."},
+		{(*intValue)(&config.RateLimits.Default.Burst), []string{"FLAMINGO_RATE_LIMIT_BURST"}, "rate-limit-burst", "Commands a user may issue in a burst, 0 disables rate limiting."},
+		{&config.RateLimits.Default.Every, []string{"FLAMINGO_RATE_LIMIT_EVERY"}, "rate-limit-every", "How often a user regains a command, e.g. 5s."},
+		{(*listValue)(&config.RateLimits.ExemptRoles), []string{"FLAMINGO_RATE_LIMIT_EXEMPT_ROLES"}, "rate-limit-exempt-roles", "Comma separated IDs of roles that are never rate limited."},
+		{(*intValue)(&config.Cache.Capacity), []string{"FLAMINGO_CACHE_CAPACITY"}, "cache-capacity", "Entries held by each cache, 0 disables caching."},
+		{&config.Cache.TTL, []string{"FLAMINGO_CACHE_TTL"}, "cache-ttl", "How long cached entries are kept, e.g. 5m."},
+		{(*stringValue)(&config.API.Listen), []string{"FLAMINGO_API_LISTEN"}, "api-listen", "Address the admin API listens on, e.g. :8080, empty disables it."},
+		{(*stringValue)(&config.API.Token), []string{"FLAMINGO_API_TOKEN"}, "api-token", "Bearer token required by the admin API."},
+		{(*stringValue)(&config.Dashboard.Listen), []string{"FLAMINGO_DASHBOARD_LISTEN"}, "dashboard-listen", "Address the web dashboard listens on, e.g. :8081, empty disables it."},
+		{(*stringValue)(&config.Dashboard.URL), []string{"FLAMINGO_DASHBOARD_URL"}, "dashboard-url", "URL users reach the web dashboard at, e.g. https://flamingo.example.com."},
+		{(*stringValue)(&config.Dashboard.ClientID), []string{"FLAMINGO_DASHBOARD_CLIENT_ID"}, "dashboard-client-id", "Discord OAuth2 client ID."},
+		{(*stringValue)(&config.Dashboard.ClientSecret), []string{"FLAMINGO_DASHBOARD_CLIENT_SECRET"}, "dashboard-client-secret", "Discord OAuth2 client secret."},
+		{(*stringValue)(&config.Dashboard.DiscordAPI), []string{"FLAMINGO_DASHBOARD_DISCORD_API"}, "dashboard-discord-api", "Base URL of the Discord API used to log in."},
+		{&config.Dashboard.SessionTTL, []string{"FLAMINGO_DASHBOARD_SESSION_TTL"}, "dashboard-session-ttl", "How long a dashboard login lasts, e.g. 12h."},
+		{(*stringValue)(&config.Health.Listen), []string{"FLAMINGO_HEALTH_LISTEN"}, "health-listen", "Address the health and readiness probes are served on, e.g. :8082, empty disables them."},
+		{(*intValue)(&config.Shards.Count), []string{"FLAMINGO_SHARD_COUNT"}, "shard-count", "Number of shards across every process, 0 uses the count Discord recommends."},
+		{(*intListValue)(&config.Shards.IDs), []string{"FLAMINGO_SHARD_IDS"}, "shard-ids", "Comma separated IDs of the shards this process runs, empty runs every shard."},
+		{(*boolValue)(&config.Auth.Enforce), []string{"FLAMINGO_AUTH_ENFORCE"}, "auth-enforce", "Apply permission rules to commands."},
+		{&config.CommandTimeout, []string{"FLAMINGO_COMMAND_TIMEOUT"}, "command-timeout", "How long a command may run before it is cancelled, e.g. 10s."},
+		{&config.ShutdownTimeout, []string{"FLAMINGO_SHUTDOWN_TIMEOUT"}, "shutdown-timeout", "How long in-flight commands are given to finish on shutdown, e.g. 30s."},
+		{&config.PurgeGrace, []string{"FLAMINGO_PURGE_GRACE"}, "purge-grace", "How long a guild's data is kept after Flamingo leaves it, e.g. 720h, 0 keeps it forever."},
+	}
+}
+
+func validateRateLimit(name string, limit RateLimitConfig) []string {
+	if limit.Burst < 0 {
+		return []string{name + ".burst cannot be negative"}
+	}
+	if limit.Burst > 0 && limit.Every.Duration <= 0 {
+		return []string{name + ".every must be positive"}
+	}
+	return nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func serviceNames() []string {
+	names := make([]string, 0, len(flamingoservice.Commands))
+	for name := range flamingoservice.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Duration is a time.Duration that is configured as a string such as "30s"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses a duration from the config file
+func (duration *Duration) UnmarshalText(text []byte) error {
+	return duration.Set(string(text))
+}
+
+// Set parses a duration from the environment or a flag
+func (duration *Duration) Set(raw string) error {
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return errors.New("must be a duration such as 30s, got \"" + raw + "\"")
+	}
+	duration.Duration = parsed
+	return nil
+}
+
+type stringValue string
+
+func (value *stringValue) String() string       { return string(*value) }
+func (value *stringValue) Set(raw string) error { *value = stringValue(raw); return nil }
+
+type boolValue bool
+
+func (value *boolValue) String() string { return strconv.FormatBool(bool(*value)) }
+func (value *boolValue) Set(raw string) error {
+	parsed, err := strconv.ParseBool(raw)
+	if err != nil {
+		return errors.New("must be true or false, got \"" + raw + "\"")
+	}
+	*value = boolValue(parsed)
+	return nil
+}
+
+type intValue int
+
+func (value *intValue) String() string { return strconv.Itoa(int(*value)) }
+func (value *intValue) Set(raw string) error {
+	parsed, err := strconv.Atoi(raw)
+	if err != nil {
+		return errors.New("must be a whole number, got \"" + raw + "\"")
+	}
+	*value = intValue(parsed)
+	return nil
+}
+
+type listValue []string
+
+func (value *listValue) String() string       { return strings.Join(*value, ",") }
+func (value *listValue) Set(raw string) error { *value = splitList(raw); return nil }
+
+type intListValue []int
+
+func (value *intListValue) String() string {
+	items := make([]string, 0, len(*value))
+	for _, item := range *value {
+		items = append(items, strconv.Itoa(item))
+	}
+	return strings.Join(items, ",")
+}
+func (value *intListValue) Set(raw string) error {
+	items := make([]int, 0)
+	for _, item := range splitList(raw) {
+		parsed, err := strconv.Atoi(item)
+		if err != nil {
+			return errors.New("must be a comma separated list of whole numbers, got \"" + raw + "\"")
+		}
+		items = append(items, parsed)
+	}
+	*value = items
+	return nil
+}
+
+func splitList(list string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
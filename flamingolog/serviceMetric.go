@@ -1,11 +1,157 @@
 package flamingolog
 
 import (
+	"FlamingoV2/assets"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 )
 
+// CloudWatch accepts at most 20 datums per PutMetricData request
+const metricBatchSize = 20
+
 // FlamingoMetricsClient is a singleton responsible for publishing service metrics
+// metrics are buffered per namespace and published in batches
 type FlamingoMetricsClient struct {
 	CloudWatchAgent *cloudwatch.CloudWatch
 	Local           bool
+	buffer          map[string][]*cloudwatch.MetricDatum
+	counts          map[string]*count
+	full            chan struct{}
+	bufferLock      sync.Mutex
+}
+
+// count accumulates a frequently incremented metric so it is published as a single datum per flush
+type count struct {
+	service    string
+	name       string
+	dimensions map[string]string
+	value      float64
+}
+
+// PutMetric buffers a metric under the Flamingo namespace for the given service
+// full batches are handed to the FlushEvery loop so callers never wait on CloudWatch
+func (metricsClient *FlamingoMetricsClient) PutMetric(service, name string, value float64, unit string, dimensions map[string]string) {
+	if metricsClient.Local {
+		return
+	}
+	metricsClient.bufferLock.Lock()
+	full := metricsClient.bufferDatum(service, name, value, unit, dimensions)
+	signal := metricsClient.fullSignal()
+	metricsClient.bufferLock.Unlock()
+	if full {
+		select {
+		case signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// fullSignal returns the channel used to wake the background flusher
+// callers must hold bufferLock
+func (metricsClient *FlamingoMetricsClient) fullSignal() chan struct{} {
+	if metricsClient.full == nil {
+		metricsClient.full = make(chan struct{}, 1)
+	}
+	return metricsClient.full
+}
+
+// bufferDatum adds a datum to the buffer, returning true once its namespace has a full batch
+// callers must hold bufferLock
+func (metricsClient *FlamingoMetricsClient) bufferDatum(service, name string, value float64, unit string, dimensions map[string]string) bool {
+	datum := &cloudwatch.MetricDatum{
+		MetricName: aws.String(name),
+		Timestamp:  aws.Time(time.Now()),
+		Unit:       aws.String(unit),
+		Value:      aws.Float64(value),
+	}
+	for dimension, dimensionValue := range dimensions {
+		datum.Dimensions = append(datum.Dimensions, &cloudwatch.Dimension{
+			Name:  aws.String(dimension),
+			Value: aws.String(dimensionValue),
+		})
+	}
+	namespace := assets.CloudWatchNamespace + service
+	if metricsClient.buffer == nil {
+		metricsClient.buffer = make(map[string][]*cloudwatch.MetricDatum)
+	}
+	metricsClient.buffer[namespace] = append(metricsClient.buffer[namespace], datum)
+	return len(metricsClient.buffer[namespace]) >= metricBatchSize
+}
+
+// Count increments a count metric under the Flamingo namespace for the given service
+// counts are accumulated in memory and only buffered on the next Flush, so hot paths can count freely
+func (metricsClient *FlamingoMetricsClient) Count(service, name string, dimensions map[string]string) {
+	if metricsClient.Local {
+		return
+	}
+	dimensionNames := make([]string, 0, len(dimensions))
+	for dimension := range dimensions {
+		dimensionNames = append(dimensionNames, dimension)
+	}
+	sort.Strings(dimensionNames)
+	key := service + "!" + name
+	for _, dimension := range dimensionNames {
+		key += "!" + dimension + "=" + dimensions[dimension]
+	}
+	metricsClient.bufferLock.Lock()
+	defer metricsClient.bufferLock.Unlock()
+	if metricsClient.counts == nil {
+		metricsClient.counts = make(map[string]*count)
+	}
+	if _, ok := metricsClient.counts[key]; !ok {
+		metricsClient.counts[key] = &count{service: service, name: name, dimensions: dimensions}
+	}
+	metricsClient.counts[key].value++
+}
+
+// Flush publishes every buffered metric
+func (metricsClient *FlamingoMetricsClient) Flush() error {
+	metricsClient.bufferLock.Lock()
+	for _, c := range metricsClient.counts {
+		metricsClient.bufferDatum(c.service, c.name, c.value, cloudwatch.StandardUnitCount, c.dimensions)
+	}
+	metricsClient.counts = nil
+	buffer := metricsClient.buffer
+	metricsClient.buffer = nil
+	metricsClient.bufferLock.Unlock()
+	var flushErr error
+	for namespace, data := range buffer {
+		for start := 0; start < len(data); start += metricBatchSize {
+			end := start + metricBatchSize
+			if end > len(data) {
+				end = len(data)
+			}
+			_, err := metricsClient.CloudWatchAgent.PutMetricData(&cloudwatch.PutMetricDataInput{
+				Namespace:  aws.String(namespace),
+				MetricData: data[start:end],
+			})
+			if err != nil {
+				flushErr = err
+			}
+		}
+	}
+	return flushErr
+}
+
+// FlushEvery flushes the buffer on an interval, and whenever a full batch is buffered, until done is closed
+func (metricsClient *FlamingoMetricsClient) FlushEvery(interval time.Duration, done <-chan struct{}) {
+	metricsClient.bufferLock.Lock()
+	full := metricsClient.fullSignal()
+	metricsClient.bufferLock.Unlock()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			metricsClient.Flush()
+		case <-full:
+			metricsClient.Flush()
+		case <-done:
+			return
+		}
+	}
 }
@@ -0,0 +1,666 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/assets"
+	"FlamingoV2/flamingolog"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	archiveServiceName = "Archive"
+	archiveCommand     = "flamingo"
+	// archiveFileName is the name of the JSON document inside an export ZIP
+	archiveFileName = "flamingo.json"
+	// archiveVersion is bumped whenever the archive format changes incompatibly
+	archiveVersion = 1
+	// archiveMaxBytes matches the attachment limit for bots in unboosted guilds
+	archiveMaxBytes = 8 << 20
+
+	// ConflictSkip keeps existing data when an imported item already exists
+	ConflictSkip = "skip"
+	// ConflictOverwrite replaces existing data with the imported item
+	ConflictOverwrite = "overwrite"
+	// ConflictRename imports pastas and templates under a new alias, other items are skipped
+	ConflictRename = "rename"
+)
+
+// ArchiveClient is responsible for exporting a guild's data to an archive and importing it back,
+// as well as deleting the data of guilds Flamingo has left and users who ask to be forgotten
+type ArchiveClient struct {
+	DynamoClient         *dynamodb.DynamoDB
+	MetricsClient        *flamingolog.FlamingoMetricsClient
+	AuthClient           *AuthClient
+	SettingsClient       *SettingsClient
+	PastaClient          *PastaClient
+	TemplateClient       *TemplateClient
+	StrikeClient         *StrikeClient
+	ReactClient          *ReactClient
+	AuditClient          *AuditClient
+	Shards               *Shards
+	DispatchClient       *DispatchClient
+	HTTPClient           *http.Client
+	ArchiveServiceLogger *log.Logger
+	ArchiveErrorLogger   *log.Logger
+	// forgetting holds the users being forgotten, so confirming twice doesn't forget them twice at once
+	forgetting sync.Map
+}
+
+// GuildArchive is the format of an export, built from the schemas each service persists
+type GuildArchive struct {
+	Version     int                `json:"version"`
+	Guild       string             `json:"guild"`
+	Exported    int64              `json:"exported"`
+	Pastas      []Pasta            `json:"pastas"`
+	Templates   []Template         `json:"templates"`
+	Strikes     []Strike           `json:"strikes"`
+	Permissions []PermissionObject `json:"permissions"`
+	Settings    []Setting          `json:"settings"`
+	Promotions  []Promotion        `json:"promotions"`
+}
+
+// importCount tallies the outcome of importing one kind of item
+type importCount struct {
+	imported int
+	renamed  int
+	skipped  int
+}
+
+// NewArchiveClient constructs an ArchiveClient
+func NewArchiveClient(dynamoClient *dynamodb.DynamoDB,
+	metricsClient *flamingolog.FlamingoMetricsClient,
+	authClient *AuthClient,
+	settingsClient *SettingsClient,
+	pastaClient *PastaClient,
+	templateClient *TemplateClient,
+	strikeClient *StrikeClient,
+	reactClient *ReactClient,
+	auditClient *AuditClient,
+	shards *Shards,
+	dispatchClient *DispatchClient) *ArchiveClient {
+	archiveClient := &ArchiveClient{
+		DynamoClient:         dynamoClient,
+		MetricsClient:        metricsClient,
+		AuthClient:           authClient,
+		SettingsClient:       settingsClient,
+		PastaClient:          pastaClient,
+		TemplateClient:       templateClient,
+		StrikeClient:         strikeClient,
+		ReactClient:          reactClient,
+		AuditClient:          auditClient,
+		Shards:               shards,
+		DispatchClient:       dispatchClient,
+		ArchiveServiceLogger: flamingolog.BuildServiceLogger(archiveServiceName),
+		ArchiveErrorLogger:   flamingolog.BuildServiceErrorLogger(archiveServiceName),
+	}
+	archiveClient.HTTPClient = &http.Client{
+		Timeout: 30 * time.Second,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return &UserError{Reason: "That link redirects too many times."}
+			}
+			return checkArchiveURL(request.URL)
+		},
+	}
+	return archiveClient
+}
+
+// IsCommand identifies a message as a potential command
+func (archiveClient *ArchiveClient) IsCommand(message string) bool {
+	return strings.HasPrefix(message, archiveCommand)
+}
+
+// Handle parses a command message and performs the commanded action
+func (archiveClient *ArchiveClient) Handle(ctx context.Context, session *discordgo.Session, message *discordgo.Message) {
+	//first word is always "flamingo", safe to remove
+	args := strings.Fields(message.Content)[1:]
+	if len(args) < 1 {
+		archiveClient.Help(session, message.ChannelID)
+		return
+	}
+	//sub-commands of flamingo
+	switch args[0] {
+	case "export":
+		if !archiveClient.canManageArchive(ctx, session, message, "export") {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+			return
+		}
+		result, err := archiveClient.Export(ctx, session, message.GuildID, message.Author.ID)
+		ParseServiceResponse(session, message.ChannelID, result, err)
+	case "import":
+		if !archiveClient.canManageArchive(ctx, session, message, "import") {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+			return
+		}
+		policy := ConflictSkip
+		if len(args) > 1 {
+			policy = args[1]
+		}
+		if policy != ConflictSkip && policy != ConflictOverwrite && policy != ConflictRename {
+			session.ChannelMessageSend(message.ChannelID, "Please specify skip, overwrite or rename for existing data.")
+			return
+		}
+		if len(message.Attachments) < 1 {
+			session.ChannelMessageSend(message.ChannelID, "Please attach an archive from "+CommandPrefix+"flamingo export.")
+			return
+		}
+		result, err := archiveClient.Import(ctx, message.GuildID, message.Author.ID, message.Attachments[0].URL, policy)
+		ParseServiceResponse(session, message.ChannelID, result, err)
+	case "forget-me":
+		archiveClient.handleForget(ctx, session, message, args)
+	case "enable", "disable":
+		if !archiveClient.canManageDispatch(ctx, session, message, args[0]) {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+			return
+		}
+		if len(args) < 2 {
+			result, err := archiveClient.DispatchClient.Describe(ctx, message.GuildID)
+			ParseServiceResponse(session, message.ChannelID, result+"\nUsage: ```"+CommandPrefix+"flamingo "+args[0]+" <service>```", err)
+			return
+		}
+		result, err := archiveClient.DispatchClient.SetServiceEnabled(ctx, message.GuildID, message.Author.ID, args[1], args[0] == "enable")
+		ParseServiceResponse(session, message.ChannelID, result, err)
+	case "channels":
+		if !archiveClient.canManageDispatch(ctx, session, message, args[0]) {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+			return
+		}
+		if len(args) < 2 {
+			result, err := archiveClient.DispatchClient.Describe(ctx, message.GuildID)
+			ParseServiceResponse(session, message.ChannelID, result, err)
+			return
+		}
+		rule, channel := args[1], ""
+		if len(args) > 2 {
+			channel = args[2]
+		}
+		if (rule != "allow" && rule != "deny" && rule != "clear") || (rule != "clear" && channel == "") {
+			session.ChannelMessageSend(message.ChannelID, "Please specify allow, deny or clear and mention a channel.")
+			return
+		}
+		result, err := archiveClient.DispatchClient.SetChannelRule(ctx, session, message.GuildID, message.Author.ID, rule, channel)
+		ParseServiceResponse(session, message.ChannelID, result, err)
+	case "help":
+		archiveClient.Help(session, message.ChannelID)
+	default:
+		archiveClient.Help(session, message.ChannelID)
+	}
+}
+
+// canManageArchive requires archives to be managed by authorized server administrators, since they cover every user's data
+func (archiveClient *ArchiveClient) canManageArchive(ctx context.Context, session *discordgo.Session, message *discordgo.Message, action string) bool {
+	return message.GuildID != "" &&
+		archiveClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, archiveCommand, action) &&
+		HasDiscordPermission(session, message.Author.ID, message.ChannelID, discordgo.PermissionAdministrator)
+}
+
+// canManageDispatch requires services and channel lists to be managed by authorized members who can manage the guild
+func (archiveClient *ArchiveClient) canManageDispatch(ctx context.Context, session *discordgo.Session, message *discordgo.Message, action string) bool {
+	return message.GuildID != "" &&
+		archiveClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, archiveCommand, action) &&
+		HasDiscordPermission(session, message.Author.ID, message.ChannelID, discordgo.PermissionManageServer)
+}
+
+// Export DMs the requester a ZIP archive of the guild's data
+func (archiveClient *ArchiveClient) Export(ctx context.Context, session *discordgo.Session, guildID, userID string) (string, error) {
+	archive, err := archiveClient.BuildArchive(ctx, guildID)
+	if err != nil {
+		return "", err
+	}
+	document, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	buffer := &bytes.Buffer{}
+	zipWriter := zip.NewWriter(buffer)
+	file, err := zipWriter.Create(archiveFileName)
+	if err != nil {
+		return "", err
+	}
+	if _, err = file.Write(document); err != nil {
+		return "", err
+	}
+	if err = zipWriter.Close(); err != nil {
+		return "", err
+	}
+	if buffer.Len() > archiveMaxBytes {
+		return "", &UserError{Reason: "This server has too much data to export as an attachment."}
+	}
+
+	dmChannel, err := session.UserChannelCreate(userID)
+	if err != nil {
+		archiveClient.ArchiveErrorLogger.Println(err)
+		return "", &UserError{Reason: "Could not DM <@" + userID + ">"}
+	}
+	_, err = session.ChannelMessageSendComplex(dmChannel.ID, &discordgo.MessageSend{
+		Content: fmt.Sprintf("Exported %d pastas, %d templates, %d strikes, %d permission rules, %d settings and %d promotions.",
+			len(archive.Pastas), len(archive.Templates), len(archive.Strikes), len(archive.Permissions), len(archive.Settings), len(archive.Promotions)),
+		Files: []*discordgo.File{
+			&discordgo.File{
+				Name:        "flamingo-" + guildID + "-" + time.Now().UTC().Format("20060102") + ".zip",
+				ContentType: "application/zip",
+				Reader:      buffer,
+			},
+		},
+	})
+	if err != nil {
+		archiveClient.ArchiveErrorLogger.Println(err)
+		return "", &UserError{Reason: "Could not DM <@" + userID + ">"}
+	}
+	archiveClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  userID,
+		Action: "flamingo export",
+		Target: guildID,
+		After:  fmt.Sprintf("%d items", len(archive.Pastas)+len(archive.Templates)+len(archive.Strikes)+len(archive.Permissions)+len(archive.Settings)+len(archive.Promotions)),
+	})
+	archiveClient.MetricsClient.Count(archiveServiceName, "Export", nil)
+	return "<@" + userID + "> the export has been sent to your DMs.", nil
+}
+
+// BuildArchive collects every item a guild has saved
+func (archiveClient *ArchiveClient) BuildArchive(ctx context.Context, guildID string) (*GuildArchive, error) {
+	archive := &GuildArchive{
+		Version:  archiveVersion,
+		Guild:    guildID,
+		Exported: time.Now().Unix(),
+	}
+	var err error
+	if archive.Pastas, err = archiveClient.PastaClient.ListPastas(ctx, guildID); err != nil {
+		return nil, err
+	}
+	if archive.Templates, err = archiveClient.TemplateClient.ListTemplates(ctx, guildID); err != nil {
+		return nil, err
+	}
+	if archive.Strikes, err = archiveClient.StrikeClient.ListStrikes(ctx, guildID); err != nil {
+		return nil, err
+	}
+	if archive.Permissions, err = archiveClient.AuthClient.ListPermissionRules(ctx, guildID); err != nil {
+		return nil, err
+	}
+	settings, err := archiveClient.SettingsClient.ListSettings(ctx, GuildScope(guildID), "")
+	if err != nil {
+		return nil, err
+	}
+	for setting, value := range settings {
+		//Bootstrap state describes this deployment's relationship with the guild, not the guild's data
+		if setting == bootstrapSetting {
+			continue
+		}
+		archive.Settings = append(archive.Settings, Setting{Scope: GuildScope(guildID), Setting: setting, Value: value})
+	}
+	err = archiveClient.DynamoClient.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(assets.PromotionTableName),
+		KeyConditionExpression: aws.String("guild=:g"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":g": &dynamodb.AttributeValue{S: aws.String(guildID)},
+		},
+	},
+		func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				promotion := Promotion{}
+				dynamodbattribute.UnmarshalMap(item, &promotion)
+				archive.Promotions = append(archive.Promotions, promotion)
+			}
+			return !lastPage
+		})
+	if err != nil {
+		archiveClient.ArchiveErrorLogger.Println(err)
+		return nil, err
+	}
+	return archive, nil
+}
+
+// Import restores an archive attached to a message into a guild
+// items are moved from the archive's guild to guildID, so archives can migrate data between guilds
+func (archiveClient *ArchiveClient) Import(ctx context.Context, guildID, requester, archiveURL, policy string) (string, error) {
+	archive, err := archiveClient.fetchArchive(ctx, archiveURL)
+	if err != nil {
+		return "", err
+	}
+	archiveClient.ArchiveServiceLogger.Printf("Importing archive of %s into %s with policy %s\n", archive.Guild, guildID, policy)
+	//Whatever was imported before an error is kept, so caches are cleared regardless
+	defer func() {
+		archiveClient.PastaClient.Cache.InvalidatePrefix(guildID + "!")
+		archiveClient.TemplateClient.Cache.InvalidatePrefix(guildID + "!")
+		archiveClient.AuthClient.ForgetGuild(guildID)
+		archiveClient.DispatchClient.Forget(guildID)
+	}()
+	from := archive.Guild
+
+	pastas := importCount{}
+	for _, pasta := range archive.Pastas {
+		if pasta.Guild != from {
+			pastas.skipped++
+			continue
+		}
+		pasta.Guild = guildID
+		err = archiveClient.importAliased(ctx, &pastas, &pasta.Alias, policy, func() map[string]*dynamodb.AttributeValue {
+			return buildPasta(pasta.Guild, pasta.Owner, pasta.Alias, pasta.Pasta)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	templates := importCount{}
+	for _, template := range archive.Templates {
+		if template.Guild != from+"T" {
+			templates.skipped++
+			continue
+		}
+		err = archiveClient.importAliased(ctx, &templates, &template.Alias, policy, func() map[string]*dynamodb.AttributeValue {
+			return buildTemplate(guildID, template.Owner, template.Alias, template.Template)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	strikes := importCount{}
+	for _, strike := range archive.Strikes {
+		if !rekeyGuild(&strike.ID, from, guildID) {
+			strikes.skipped++
+			continue
+		}
+		item, _ := dynamodbattribute.MarshalMap(strike)
+		if err = archiveClient.importItem(ctx, &strikes, assets.StrikeTableName, "guild!user", item, policy); err != nil {
+			return "", err
+		}
+	}
+	permissions := importCount{}
+	for _, permission := range archive.Permissions {
+		//Rules limited to a channel or category name the exported server's channels, which don't exist in another server
+		scope, kind, _ := SplitPermission(permission.Permission)
+		if !rekeyGuild(&permission.Guild, from, guildID) || kind == "" || (scope.Kind != "" && from != guildID) {
+			permissions.skipped++
+			continue
+		}
+		item, _ := dynamodbattribute.MarshalMap(permission)
+		if err = archiveClient.importItem(ctx, &permissions, assets.AuthTableName, "guild", item, policy); err != nil {
+			return "", err
+		}
+	}
+	settings := importCount{}
+	for _, setting := range archive.Settings {
+		if setting.Scope != GuildScope(from) || setting.Setting == bootstrapSetting {
+			settings.skipped++
+			continue
+		}
+		//Channel lists name the exported server's channels, which don't exist in another server
+		if from != guildID && (strings.HasPrefix(setting.Setting, allowedChannelSettingPrefix) || strings.HasPrefix(setting.Setting, deniedChannelSettingPrefix)) {
+			settings.skipped++
+			continue
+		}
+		setting.Scope = GuildScope(guildID)
+		item, _ := dynamodbattribute.MarshalMap(setting)
+		if err = archiveClient.importItem(ctx, &settings, assets.SettingsTableName, "scope", item, policy); err != nil {
+			return "", err
+		}
+	}
+	promotions := importCount{}
+	for _, promotion := range archive.Promotions {
+		if promotion.Guild != from {
+			promotions.skipped++
+			continue
+		}
+		promotion.Guild = guildID
+		//No upload is in flight for an imported promotion, so one exported mid-approval is pending again
+		if promotion.Status == promotionApproving {
+			promotion.Status = promotionPending
+		}
+		//Emojis and stickers created for another guild don't exist in this one
+		if from != guildID {
+			promotion.DiscordID = ""
+			if promotion.Status == promotionApproved {
+				promotion.Status = promotionPending
+			}
+		}
+		item, _ := dynamodbattribute.MarshalMap(promotion)
+		if err = archiveClient.importItem(ctx, &promotions, assets.PromotionTableName, "guild", item, policy); err != nil {
+			return "", err
+		}
+	}
+	summary := pastas.String("pastas") + "\n" +
+		templates.String("templates") + "\n" +
+		strikes.String("strikes") + "\n" +
+		permissions.String("permission rules") + "\n" +
+		settings.String("settings") + "\n" +
+		promotions.String("promotions")
+	archiveClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  requester,
+		Action: "flamingo import",
+		Target: "archive of " + from + " with " + policy,
+		After:  summary,
+	})
+	archiveClient.MetricsClient.Count(archiveServiceName, "Import", nil)
+	return "Import complete.\n" + summary, nil
+}
+
+// importItem writes an item, leaving an existing item with the same key alone unless policy is to overwrite it
+func (archiveClient *ArchiveClient) importItem(ctx context.Context, count *importCount, table, hashKey string, item map[string]*dynamodb.AttributeValue, policy string) error {
+	written, err := archiveClient.putArchiveItem(ctx, table, hashKey, item, policy == ConflictOverwrite)
+	if err != nil {
+		return err
+	}
+	if written {
+		count.imported++
+	} else {
+		count.skipped++
+	}
+	return nil
+}
+
+// importAliased writes a pasta or template, trying numbered aliases until one is free if policy is to rename
+func (archiveClient *ArchiveClient) importAliased(ctx context.Context, count *importCount, alias *string, policy string, build func() map[string]*dynamodb.AttributeValue) error {
+	original := *alias
+	written, err := archiveClient.putArchiveItem(ctx, assets.PastaTableName, "guild", build(), policy == ConflictOverwrite)
+	for suffix := 2; err == nil && !written && policy == ConflictRename && suffix <= 100; suffix++ {
+		*alias = original + "_" + strconv.Itoa(suffix)
+		written, err = archiveClient.putArchiveItem(ctx, assets.PastaTableName, "guild", build(), false)
+		if written {
+			count.renamed++
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if written {
+		count.imported++
+	} else {
+		count.skipped++
+	}
+	return nil
+}
+
+// putArchiveItem writes an item, returning false if it wasn't overwritten because an item with the same key exists
+func (archiveClient *ArchiveClient) putArchiveItem(ctx context.Context, table, hashKey string, item map[string]*dynamodb.AttributeValue, overwrite bool) (bool, error) {
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item:      item,
+	}
+	if !overwrite {
+		input.ConditionExpression = aws.String("attribute_not_exists(#k)")
+		input.ExpressionAttributeNames = map[string]*string{
+			"#k": aws.String(hashKey),
+		}
+	}
+	_, err := archiveClient.DynamoClient.PutItemWithContext(ctx, input)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+		archiveClient.ArchiveErrorLogger.Println(err)
+		return false, err
+	}
+	return true, nil
+}
+
+// fetchArchive downloads and decodes an archive, either the ZIP sent by export or the JSON document inside it
+func (archiveClient *ArchiveClient) fetchArchive(ctx context.Context, rawURL string) (*GuildArchive, error) {
+	archiveURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, &UserError{Reason: "That doesn't look like a valid link."}
+	}
+	if err = checkArchiveURL(archiveURL); err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, archiveURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := archiveClient.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, &UserError{Reason: fmt.Sprintf("Could not download the archive (HTTP %d).", response.StatusCode)}
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, archiveMaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > archiveMaxBytes {
+		return nil, &UserError{Reason: fmt.Sprintf("Archives must be at most %d KB.", archiveMaxBytes>>10)}
+	}
+	if bytes.HasPrefix(body, []byte("PK")) {
+		body, err = unzipArchive(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	archive := &GuildArchive{}
+	if err = json.Unmarshal(body, archive); err != nil {
+		return nil, &UserError{Reason: "That file could not be read as a Flamingo archive."}
+	}
+	if archive.Version != archiveVersion || archive.Guild == "" {
+		return nil, &UserError{Reason: "That archive was not exported by this version of Flamingo."}
+	}
+	return archive, nil
+}
+
+// unzipArchive extracts the JSON document from an export ZIP
+func unzipArchive(body []byte) ([]byte, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, &UserError{Reason: "That file could not be read as a ZIP archive."}
+	}
+	for _, file := range zipReader.File {
+		if file.Name != archiveFileName {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return nil, &UserError{Reason: "That file could not be read as a ZIP archive."}
+		}
+		defer reader.Close()
+		//The document is limited as well as the ZIP, so a small ZIP can't expand without bound
+		document, err := io.ReadAll(io.LimitReader(reader, archiveMaxBytes*4+1))
+		if err != nil {
+			return nil, &UserError{Reason: "That file could not be read as a ZIP archive."}
+		}
+		if len(document) > archiveMaxBytes*4 {
+			return nil, &UserError{Reason: "That archive is too large to import."}
+		}
+		return document, nil
+	}
+	return nil, &UserError{Reason: "That ZIP doesn't contain " + archiveFileName + "."}
+}
+
+func checkArchiveURL(archiveURL *url.URL) error {
+	if archiveURL.Scheme != "https" || !containsString(DiscordCDNHosts, strings.ToLower(archiveURL.Hostname())) {
+		return &UserError{Reason: "Archives must be uploaded to Discord."}
+	}
+	return nil
+}
+
+// Help provides assistance with the flamingo command by sending a help dialogue
+func (archiveClient *ArchiveClient) Help(session *discordgo.Session, channelID string) {
+	session.ChannelMessageSendEmbed(channelID,
+		&discordgo.MessageEmbed{
+			Author: &discordgo.MessageEmbedAuthor{},
+			Thumbnail: &discordgo.MessageEmbedThumbnail{
+				URL: assets.AvatarURL,
+			},
+			Color:       0xff0000,
+			Title:       "You need help!",
+			Description: "The commands for flamingo are:",
+			Fields: []*discordgo.MessageEmbedField{
+				&discordgo.MessageEmbedField{
+					Name: "export",
+					Value: "DMs the caller a ZIP archive of the server's pastas, templates, strikes, permission rules, settings and reaction promotions. " +
+						"Only server administrators can export.\n" +
+						"Usage: ```" + CommandPrefix + "flamingo export```",
+				},
+				&discordgo.MessageEmbedField{
+					Name: "import",
+					Value: "Restores an attached archive into the server. Existing data is skipped by default, overwritten, " +
+						"or kept alongside imported pastas and templates under a numbered alias with rename. " +
+						"Only server administrators can import.\n" +
+						"Usage: ```" + CommandPrefix + "flamingo import [skip|overwrite|rename]```",
+				},
+				&discordgo.MessageEmbedField{
+					Name: "forget-me",
+					Value: "Deletes the caller's reactions, strikes and settings in every server, after asking them to confirm. " +
+						"Their pastas and templates are given to the owners of their servers, or deleted with delete.\n" +
+						"Usage: ```" + CommandPrefix + "flamingo forget-me [confirm [delete]]```",
+				},
+				&discordgo.MessageEmbedField{
+					Name: "enable/disable",
+					Value: "Enables or disables a service in the server, e.g. strike or spoiler. Disabled services ignore their commands. " +
+						"flamingo and auth can't be disabled. Without a service, shows which services are disabled.\n" +
+						"Usage: ```" + CommandPrefix + "flamingo disable <service>```",
+				},
+				&discordgo.MessageEmbedField{
+					Name: "channels",
+					Value: "Limits the channels commands can be used in. Commands are never used in denied channels, " +
+						"and while any channels are allowed, only in those. Members who can manage the server can use commands anywhere. " +
+						"clear removes a channel from both lists, or clears both lists without a channel.\n" +
+						"Usage: ```" + CommandPrefix + "flamingo channels [allow|deny|clear] [#channel]```",
+				},
+				&discordgo.MessageEmbedField{
+					Name:  "help",
+					Value: "Shows this help message.",
+				},
+			},
+		})
+}
+
+// String summarizes an import count for a kind of item
+func (count importCount) String(kind string) string {
+	summary := fmt.Sprintf("%s: %d imported", kind, count.imported)
+	if count.renamed > 0 {
+		summary += fmt.Sprintf(", %d renamed", count.renamed)
+	}
+	if count.skipped > 0 {
+		summary += fmt.Sprintf(", %d skipped", count.skipped)
+	}
+	return summary
+}
+
+// rekeyGuild moves a key prefixed by a guild ID to another guild, returning false if it doesn't belong to the from guild
+func rekeyGuild(key *string, from, to string) bool {
+	if !strings.HasPrefix(*key, from+"!") {
+		return false
+	}
+	*key = to + strings.TrimPrefix(*key, from)
+	return true
+}
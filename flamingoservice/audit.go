@@ -0,0 +1,398 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/assets"
+	"FlamingoV2/flamingolog"
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	auditServiceName = "Audit"
+	auditCommand     = "audit"
+	// auditChannelSetting is the guild setting holding the channel audit entries are mirrored to
+	auditChannelSetting = "audit!channel"
+	// AuditGlobal is the guild of audit entries that don't belong to any one guild
+	AuditGlobal = "global"
+	// AuditSystem is the actor of audit entries for actions Flamingo takes on its own
+	AuditSystem = "flamingo"
+	// AuditAPI is the actor of audit entries for actions taken through the admin API
+	AuditAPI = "api"
+	// auditRecentCount is the number of entries shown by audit recent
+	auditRecentCount = 10
+	// auditRecentScanLimit bounds how many entries audit recent reads looking for matches
+	auditRecentScanLimit = 500
+	// auditFieldLimit is the most characters Discord shows in an embed field value
+	auditFieldLimit = 1024
+	// auditRecentChangeLimit bounds the before and after values audit recent shows, pasta edits carry their full text
+	auditRecentChangeLimit = 200
+	// auditRecentBudget keeps audit recent under Discord's limit of 6000 characters across an embed
+	auditRecentBudget = 5500
+)
+
+var (
+	mentionID = strings.NewReplacer("<@!", "", "<@&", "", "<@", "", "<#", "", ">", "")
+)
+
+// AuditClient is responsible for recording administrative and destructive actions
+// entries are persisted per guild and optionally mirrored to a mod-log channel
+type AuditClient struct {
+	Shards             *Shards
+	DynamoClient       *dynamodb.DynamoDB
+	MetricsClient      *flamingolog.FlamingoMetricsClient
+	SettingsClient     *SettingsClient
+	AuditServiceLogger *log.Logger
+	AuditErrorLogger   *log.Logger
+}
+
+// AuditEntry represents the schema of an audit entry
+// entries sort by ID, which begins with the time they were recorded
+type AuditEntry struct {
+	Guild     string `dynamodbav:"guild"`
+	ID        string `dynamodbav:"entry"`
+	Actor     string `dynamodbav:"actor"`
+	Action    string `dynamodbav:"action"`
+	Target    string `dynamodbav:"target"`
+	Before    string `dynamodbav:"before,omitempty"`
+	After     string `dynamodbav:"after,omitempty"`
+	Timestamp int64  `dynamodbav:"timestamp"`
+}
+
+// NewAuditClient constructs an AuditClient
+func NewAuditClient(shards *Shards,
+	dynamoClient *dynamodb.DynamoDB,
+	metricsClient *flamingolog.FlamingoMetricsClient,
+	settingsClient *SettingsClient) *AuditClient {
+	return &AuditClient{
+		Shards:             shards,
+		DynamoClient:       dynamoClient,
+		MetricsClient:      metricsClient,
+		SettingsClient:     settingsClient,
+		AuditServiceLogger: flamingolog.BuildServiceLogger(auditServiceName),
+		AuditErrorLogger:   flamingolog.BuildServiceErrorLogger(auditServiceName),
+	}
+}
+
+// IsCommand identifies a message as a potential command
+func (auditClient *AuditClient) IsCommand(message string) bool {
+	return strings.HasPrefix(message, auditCommand)
+}
+
+// Handle parses a command message and performs the commanded action
+func (auditClient *AuditClient) Handle(ctx context.Context, session *discordgo.Session, message *discordgo.Message) {
+	//first word is always "audit", safe to remove
+	args := strings.Fields(message.Content)[1:]
+	if len(args) < 1 {
+		auditClient.Help(session, message.ChannelID)
+		return
+	}
+	//sub-commands of audit
+	switch args[0] {
+	case "recent":
+		if !auditClient.canReadAudit(session, message) {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+			return
+		}
+		filter := ""
+		if len(args) > 1 {
+			filter = args[1]
+		}
+		result, err := auditClient.Recent(ctx, message.GuildID, filter)
+		ParseServiceResponse(session, message.ChannelID, result, err)
+	case "channel":
+		if !auditClient.canReadAudit(session, message) {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+			return
+		}
+		if len(args) < 2 {
+			session.ChannelMessageSend(message.ChannelID, "Please mention a channel or specify off.")
+			return
+		}
+		result, err := auditClient.SetChannel(ctx, message.GuildID, message.Author.ID, args[1])
+		ParseServiceResponse(session, message.ChannelID, result, err)
+	case "help":
+		auditClient.Help(session, message.ChannelID)
+	default:
+		auditClient.Help(session, message.ChannelID)
+	}
+}
+
+// canReadAudit requires the audit log to be read and configured by members who can manage the guild
+// permission rules don't apply, since changes to them are themselves audited
+func (auditClient *AuditClient) canReadAudit(session *discordgo.Session, message *discordgo.Message) bool {
+	return message.GuildID != "" &&
+		HasDiscordPermission(session, message.Author.ID, message.ChannelID, discordgo.PermissionManageServer)
+}
+
+// Record persists an audit entry and mirrors it to the guild's mod-log channel, if it has one
+// recording is best effort, failures are logged rather than failing the action being audited
+func (auditClient *AuditClient) Record(ctx context.Context, entry AuditEntry) {
+	if auditClient == nil {
+		return
+	}
+	if entry.Guild == "" {
+		entry.Guild = AuditGlobal
+	}
+	now := time.Now()
+	entry.Timestamp = now.Unix()
+	//The random suffix keeps entries recorded in the same instant apart
+	entry.ID = fmt.Sprintf("%020d!%08x", now.UnixNano(), rand.Uint32())
+	auditClient.AuditServiceLogger.Printf("%s %s by %s on %s\n", entry.Guild, entry.Action, entry.Actor, entry.Target)
+	item, _ := dynamodbattribute.MarshalMap(entry)
+	_, err := auditClient.DynamoClient.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(assets.AuditTableName),
+		Item:      item,
+	})
+	if err != nil {
+		auditClient.AuditErrorLogger.Printf("Could not record %s by %s on %s\n", entry.Action, entry.Actor, entry.Target)
+		auditClient.AuditErrorLogger.Println(err)
+		auditClient.MetricsClient.Count(auditServiceName, "RecordFailed", nil)
+	}
+	if entry.Guild == AuditGlobal {
+		return
+	}
+	channelID, ok, err := auditClient.SettingsClient.GetSetting(ctx, GuildScope(entry.Guild), auditChannelSetting)
+	if err != nil || !ok {
+		return
+	}
+	_, err = auditClient.Shards.Primary().ChannelMessageSendEmbed(channelID, buildAuditEmbed(entry))
+	if err != nil {
+		auditClient.AuditErrorLogger.Printf("Could not mirror audit entry to %s in %s\n", channelID, entry.Guild)
+		auditClient.AuditErrorLogger.Println(err)
+	}
+}
+
+// SetChannel sets or clears the channel a guild's audit entries are mirrored to
+func (auditClient *AuditClient) SetChannel(ctx context.Context, guildID, requester, channel string) (string, error) {
+	before, _, err := auditClient.SettingsClient.GetSetting(ctx, GuildScope(guildID), auditChannelSetting)
+	if err != nil {
+		return "", err
+	}
+	if channel == "off" {
+		err = auditClient.SettingsClient.DeleteSetting(ctx, GuildScope(guildID), auditChannelSetting)
+		if err != nil {
+			return "", err
+		}
+		auditClient.Record(ctx, AuditEntry{Guild: guildID, Actor: requester, Action: "audit channel", Target: guildID, Before: before})
+		return "Audit entries will no longer be posted to a channel.", nil
+	}
+	channelID := mentionID.Replace(channel)
+	target, err := auditClient.Shards.Channel(guildID, channelID)
+	if err != nil || target.GuildID != guildID {
+		return "Please mention a channel in this server.", nil
+	}
+	err = auditClient.SettingsClient.SetSetting(ctx, GuildScope(guildID), auditChannelSetting, channelID)
+	if err != nil {
+		return "", err
+	}
+	auditClient.Record(ctx, AuditEntry{Guild: guildID, Actor: requester, Action: "audit channel", Target: guildID, Before: before, After: channelID})
+	return "Audit entries will be posted to <#" + channelID + ">.", nil
+}
+
+// Recent retrieves the most recent audit entries for a guild
+// filter matches entries whose action starts with it, or whose actor or target is the mentioned user, role or channel
+func (auditClient *AuditClient) Recent(ctx context.Context, guildID, filter string) (*discordgo.MessageEmbed, error) {
+	entries, err := auditClient.List(ctx, guildID, filter, auditRecentCount)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]*discordgo.MessageEmbedField, 0, len(entries))
+	used := 0
+	for _, entry := range entries {
+		entry.Before = truncateRunes(entry.Before, auditRecentChangeLimit)
+		entry.After = truncateRunes(entry.After, auditRecentChangeLimit)
+		field := &discordgo.MessageEmbedField{
+			Name:  entry.Action + " " + fmt.Sprintf("<t:%d:R>", entry.Timestamp),
+			Value: truncateAudit("By " + formatAuditActor(entry.Actor) + " on " + entry.Target + formatAuditChange(entry)),
+		}
+		used += utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+		if used > auditRecentBudget {
+			break
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) < 1 {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "That's all folks!",
+			Value: "There are no matching audit entries.",
+		})
+	}
+	return &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{},
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: assets.AvatarURL,
+		},
+		Color:  0x0000ff,
+		Title:  "Recent audit entries",
+		Fields: fields,
+	}, nil
+}
+
+// List retrieves up to limit of a guild's audit entries matching filter, most recent first
+// filter is matched as it is by Recent, an empty filter matches every entry
+func (auditClient *AuditClient) List(ctx context.Context, guildID, filter string, limit int) ([]AuditEntry, error) {
+	filterID := mentionID.Replace(filter)
+	entries := make([]AuditEntry, 0, limit)
+	scanned := 0
+	err := auditClient.DynamoClient.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(assets.AuditTableName),
+		KeyConditionExpression: aws.String("guild=:g"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":g": &dynamodb.AttributeValue{S: aws.String(guildID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(50),
+	},
+		func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				scanned++
+				entry := AuditEntry{}
+				dynamodbattribute.UnmarshalMap(item, &entry)
+				if filter == "" || strings.HasPrefix(entry.Action, filter) ||
+					entry.Actor == filterID || strings.Contains(entry.Target, filterID) {
+					entries = append(entries, entry)
+				}
+				if len(entries) >= limit {
+					return false
+				}
+			}
+			return !lastPage && scanned < auditRecentScanLimit
+		})
+	if err != nil {
+		auditClient.AuditErrorLogger.Println(err)
+		return nil, err
+	}
+	return entries, nil
+}
+
+// DeleteGuild deletes every audit entry recorded for a guild, returning the number deleted
+func (auditClient *AuditClient) DeleteGuild(ctx context.Context, guildID string) (int, error) {
+	if auditClient == nil {
+		return 0, nil
+	}
+	deleted := 0
+	var deleteErr error
+	err := auditClient.DynamoClient.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(assets.AuditTableName),
+		KeyConditionExpression: aws.String("guild=:g"),
+		ProjectionExpression:   aws.String("guild, entry"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":g": &dynamodb.AttributeValue{S: aws.String(guildID)},
+		},
+	},
+		func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				_, deleteErr = auditClient.DynamoClient.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+					TableName: aws.String(assets.AuditTableName),
+					Key:       item,
+				})
+				if deleteErr != nil {
+					return false
+				}
+				deleted++
+			}
+			return !lastPage
+		})
+	if err == nil {
+		err = deleteErr
+	}
+	if err != nil {
+		auditClient.AuditErrorLogger.Println(err)
+	}
+	return deleted, err
+}
+
+// Help provides assistance with the audit command by sending a help dialogue
+func (auditClient *AuditClient) Help(session *discordgo.Session, channelID string) {
+	session.ChannelMessageSendEmbed(channelID,
+		&discordgo.MessageEmbed{
+			Author: &discordgo.MessageEmbedAuthor{},
+			Thumbnail: &discordgo.MessageEmbedThumbnail{
+				URL: assets.AvatarURL,
+			},
+			Color:       0xff0000,
+			Title:       "You need help!",
+			Description: "The commands for audit are:",
+			Fields: []*discordgo.MessageEmbedField{
+				&discordgo.MessageEmbedField{
+					Name: "recent",
+					Value: "Shows the " + strconv.Itoa(auditRecentCount) + " most recent audit entries, optionally only those for an action or mentioned member. " +
+						"Requires the Discord permission to manage the server.\n" +
+						"Usage: ```" + CommandPrefix + "audit recent [$action|@member]```",
+				},
+				&discordgo.MessageEmbedField{
+					Name: "channel",
+					Value: "Posts audit entries to a channel as they are recorded, or stops posting them with off. " +
+						"Requires the Discord permission to manage the server.\n" +
+						"Usage: ```" + CommandPrefix + "audit channel #channel|off```",
+				},
+				&discordgo.MessageEmbedField{
+					Name:  "help",
+					Value: "Shows this help message.",
+				},
+			},
+		})
+}
+
+func buildAuditEmbed(entry AuditEntry) *discordgo.MessageEmbed {
+	fields := []*discordgo.MessageEmbedField{
+		&discordgo.MessageEmbedField{Name: "Actor", Value: formatAuditActor(entry.Actor), Inline: true},
+		&discordgo.MessageEmbedField{Name: "Target", Value: truncateAudit(entry.Target), Inline: true},
+	}
+	if entry.Before != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Before", Value: truncateAudit(entry.Before)})
+	}
+	if entry.After != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "After", Value: truncateAudit(entry.After)})
+	}
+	return &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{},
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: assets.AvatarURL,
+		},
+		Color:     0xffa500,
+		Title:     entry.Action,
+		Fields:    fields,
+		Timestamp: time.Unix(entry.Timestamp, 0).UTC().Format(time.RFC3339),
+	}
+}
+
+func formatAuditActor(actor string) string {
+	switch actor {
+	case AuditSystem:
+		return "Flamingo"
+	case AuditAPI:
+		return "Admin API"
+	}
+	return "<@" + actor + ">"
+}
+
+func formatAuditChange(entry AuditEntry) string {
+	if entry.Before == "" && entry.After == "" {
+		return ""
+	}
+	return "\n" + entry.Before + " → " + entry.After
+}
+
+func truncateAudit(value string) string {
+	return truncateRunes(value, auditFieldLimit)
+}
+
+func truncateRunes(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) > limit {
+		return string(runes[:limit-3]) + "..."
+	}
+	return value
+}
@@ -3,9 +3,13 @@ package flamingoservice
 import (
 	"FlamingoV2/assets"
 	"FlamingoV2/flamingolog"
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -18,23 +22,35 @@ import (
 const (
 	authServiceName = "Auth"
 	authCommand     = "auth"
+	// ChannelScope limits a permission rule to a channel, and the threads in it
+	ChannelScope = "channel"
+	// CategoryScope limits a permission rule to every channel in a category
+	CategoryScope = "category"
 )
 
+// ErrPermissiveFlagNotFound is returned for guilds that have never had their permissive flag set
+var ErrPermissiveFlagNotFound = errors.New("Permissive flag not found")
+
 var (
-	command, _         = regexp.Compile(`command=\w*`)
-	action, _          = regexp.Compile(`action=\w*`)
-	user, _            = regexp.Compile(`user=\s?\<\@\!?[\d]*\>`)
-	role, _            = regexp.Compile(`role=\s?\<\@\&[\d]*\>`)
-	roleName, _        = regexp.Compile(`roleName=".*"`)
-	permissionValue, _ = regexp.Compile(`permission=(true|false)`)
+	command, _           = regexp.Compile(`command=[\w-]*`)
+	action, _            = regexp.Compile(`action=[\w-]*`)
+	user, _              = regexp.Compile(`user=\s?\<\@\!?[\d]*\>`)
+	role, _              = regexp.Compile(`role=\s?\<\@\&[\d]*\>`)
+	roleName, _          = regexp.Compile(`roleName="[^"]*"`)
+	permissionValue, _   = regexp.Compile(`permission=(true|false)`)
+	permissionChannel, _ = regexp.Compile(`channel=\s?(\<#\d+\>|\d+)`)
 )
 
 // AuthClient is responsible for enforcing permissions and handling
 // permssions update commands
 type AuthClient struct {
-	DiscordClient     *discordgo.Session
+	// Enforce applies permission rules to commands, while it is unset every command is allowed
+	Enforce           bool
+	Shards            *Shards
 	DynamoClient      *dynamodb.DynamoDB
 	MetricsClient     *flamingolog.FlamingoMetricsClient
+	AuditClient       *AuditClient
+	Cache             *Cache
 	AuthServiceLogger *log.Logger
 	AuthErrorLogger   *log.Logger
 }
@@ -46,6 +62,23 @@ type PermissionObject struct {
 	Allow      bool   `dynamodbav:"allow"`
 }
 
+// PermissionScope limits a permission rule to a channel or category, the zero PermissionScope applies to the whole guild
+// scoped rules are stored alongside guild-wide rules, with the scope prefixing their range key e.g. channel!ID!role!ID
+type PermissionScope struct {
+	Kind string
+	ID   string
+}
+
+// permissionArgs are the arguments of an auth command
+type permissionArgs struct {
+	command   string
+	action    string
+	ID        string
+	isRole    bool
+	isAllowed *bool
+	scope     PermissionScope
+}
+
 // PermissionKey is a convenience struct for marshalling Go types into DDB types
 type PermissionKey struct {
 	Guild      string `dynamodbav:"guild"`
@@ -53,13 +86,19 @@ type PermissionKey struct {
 }
 
 // NewAuthClient constructs an AuthClient
-func NewAuthClient(discordClient *discordgo.Session,
+func NewAuthClient(shards *Shards,
 	dynamoClient *dynamodb.DynamoDB,
-	metricsClient *flamingolog.FlamingoMetricsClient) *AuthClient {
+	metricsClient *flamingolog.FlamingoMetricsClient,
+	auditClient *AuditClient,
+	cache *Cache,
+	enforce bool) *AuthClient {
 	return &AuthClient{
-		DiscordClient:     discordClient,
+		Enforce:           enforce,
+		Shards:            shards,
 		DynamoClient:      dynamoClient,
 		MetricsClient:     metricsClient,
+		AuditClient:       auditClient,
+		Cache:             cache,
 		AuthServiceLogger: flamingolog.BuildServiceLogger(authServiceName),
 		AuthErrorLogger:   flamingolog.BuildServiceErrorLogger(authServiceName),
 	}
@@ -71,145 +110,338 @@ func (authClient *AuthClient) IsCommand(message string) bool {
 }
 
 // Handle parses a command message and performs the commanded action
-func (authClient *AuthClient) Handle(session *discordgo.Session, message *discordgo.Message) {
-	// hack to deprecate this functionality
-	ParseServiceResponse(session, message.ChannelID, "This command is deprecated.", nil)
-}
-
-// ListPermissions lists all permissions for a guild
-// func (authClient *AuthClient) ListPermissions(guildID, channelID, userID string) error {
-// 	var guildName string
-// 	guild, err := authClient.DiscordClient.Guild(guildID)
-// 	if err != nil {
-// 		guildName = "An error occurred while retrieving server name."
-// 		authClient.AuthErrorLogger.Println(err)
-// 	} else {
-// 		guildName = guild.Name
-// 	}
-
-// 	guildRoles, err := authClient.DiscordClient.GuildRoles(guildID)
-// 	if err != nil {
-// 		authClient.AuthErrorLogger.Println(err)
-// 		return err
-// 	}
-// 	roleIDNameMap := make(map[string]string)
-// 	for _, v := range guildRoles {
-// 		roleIDNameMap[v.ID] = v.Name
-// 	}
-
-// 	dmChannel, err := authClient.DiscordClient.UserChannelCreate(userID)
-// 	if err != nil {
-// 		authClient.DiscordClient.ChannelMessageSend(channelID, "An error occured. Could not DM <@"+userID+">")
-// 		authClient.AuthErrorLogger.Println(err)
-// 		return err
-// 	}
-
-// 	err = authClient.DynamoClient.BatchGetItemPages(&dynamodb.BatchGetItemInput{
-// 		RequestItems: listAuthorizationKeys(guildID),
-// 	},
-// 		func(page *dynamodb.BatchGetItemOutput, lastPage bool) bool {
-// 			permissionsList := make([]*discordgo.MessageEmbedField, 0, 10)
-// 			for _, permission := range page.Responses[assets.AuthTableName] {
-// 				rule := &PermissionObject{}
-// 				dynamodbattribute.UnmarshalMap(permission, rule)
-// 				//guild, command ! action
-// 				ID := strings.Split(rule.Permission, "!")[1]
-// 				permissionString := "Denied"
-// 				if rule.Allow {
-// 					permissionString = "Allowed"
-// 				}
-// 				//Role-based rules
-// 				if strings.HasPrefix(rule.Permission, "role!") {
-// 					permissionsList = append(permissionsList, &discordgo.MessageEmbedField{
-// 						Name:  roleIDNameMap[ID],
-// 						Value: permissionString,
-// 					})
-// 				} else {
-// 					var userName string
-// 					member, err := authClient.DiscordClient.GuildMember(guildID, ID)
-// 					if err != nil {
-// 						userName = ID
-// 					} else {
-// 						userName = member.Nick
-// 					}
-// 					permissionsList = append(permissionsList, &discordgo.MessageEmbedField{
-// 						Name:  userName,
-// 						Value: permissionString,
-// 					})
-// 				}
-// 				authClient.DiscordClient.ChannelMessageSendEmbed(dmChannel.ID,
-// 					&discordgo.MessageEmbed{
-// 						Author: &discordgo.MessageEmbedAuthor{},
-// 						Thumbnail: &discordgo.MessageEmbedThumbnail{
-// 							URL: assets.AvatarURL,
-// 						},
-// 						Color:       0x0000ff,
-// 						Description: "It's not like I like you or a-anything, b-b-baka.",
-// 						Fields:      permissionsList,
-// 						Title:       "Permissions for " + guildName,
-// 					})
-// 			}
-// 			return !lastPage
-// 		})
-// 	if err != nil {
-// 		authClient.DiscordClient.ChannelMessageSend(dmChannel.ID, "An error occured. Please try again later.")
-// 		authClient.AuthErrorLogger.Println(err)
-// 		return nil
-// 	}
-// 	return nil
-// }
-
-// SetPermission sets the value of a permission
-func (authClient *AuthClient) SetPermission(guildID, ID, command, action string, isRole, isAllowed bool) error {
-	permission := make(map[string]*dynamodb.AttributeValue)
-	if isRole {
-		permission = buildPermission(guildID, "", ID, command, action, isRole, isAllowed)
-	} else {
-		permission = buildPermission(guildID, ID, "", command, action, isRole, isAllowed)
+func (authClient *AuthClient) Handle(ctx context.Context, session *discordgo.Session, message *discordgo.Message) {
+	//first word is always "auth", safe to remove
+	args := strings.Fields(message.Content)[1:]
+	//Without enforcement every member would pass the auth check below, so rules are only managed through the API and dashboard
+	if !authClient.Enforce {
+		ParseServiceResponse(session, message.ChannelID, "This command is deprecated.", nil)
+		return
 	}
-	_, err := authClient.DynamoClient.PutItem(&dynamodb.PutItemInput{
-		TableName: aws.String(assets.AuthTableName),
-		Item:      permission,
+	if len(args) < 1 || args[0] == "help" || !containsString(Commands[authCommand], args[0]) {
+		authClient.Help(session, message.ChannelID)
+		return
+	}
+	if message.GuildID == "" {
+		session.ChannelMessageSend(message.ChannelID, "Permissions can only be managed in a server.")
+		return
+	}
+	if !authClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, authCommand, args[0]) {
+		ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+		return
+	}
+	//sub-commands of auth
+	switch args[0] {
+	case "list":
+		result, err := authClient.ListPermissions(ctx, message.GuildID)
+		ParseServiceResponse(session, message.ChannelID, result, err)
+		return
+	case "permissive":
+		value := permissionValue.FindString(message.Content)
+		if value == "" {
+			session.ChannelMessageSend(message.ChannelID, "Please specify permission=true or permission=false.")
+			return
+		}
+		err := authClient.SetPermissiveFlagValue(ctx, message.GuildID, message.Author.ID, value == "permission=true")
+		ParseServiceResponse(session, message.ChannelID, permissionEmbed("Permissive flag set", "Commands without a matching rule are now "+formatAllowed(value == "permission=true")+"."), err)
+		return
+	}
+	rule, err := parseAuthCommandArgs(session, message)
+	if err != nil {
+		ParseServiceResponse(session, message.ChannelID, nil, err)
+		return
+	}
+	target := formatPermissionTarget(rule.ID, rule.command, rule.action, rule.scope, rule.isRole)
+	switch args[0] {
+	case "set":
+		if rule.isAllowed == nil {
+			session.ChannelMessageSend(message.ChannelID, "Please specify permission=true or permission=false.")
+			return
+		}
+		err = authClient.SetPermission(ctx, message.GuildID, message.Author.ID, rule.ID, rule.command, rule.action, rule.scope, rule.isRole, *rule.isAllowed)
+		ParseServiceResponse(session, message.ChannelID, permissionEmbed("Permission set", target+": "+formatAllowed(*rule.isAllowed)), err)
+	case "delete":
+		err = authClient.DeletePermission(ctx, message.GuildID, message.Author.ID, rule.ID, rule.command, rule.action, rule.scope, rule.isRole)
+		ParseServiceResponse(session, message.ChannelID, permissionEmbed("Permission deleted", target), err)
+	case "test":
+		if rule.isRole {
+			session.ChannelMessageSend(message.ChannelID, "Please mention a user to test.")
+			return
+		}
+		if rule.scope.Kind == CategoryScope {
+			session.ChannelMessageSend(message.ChannelID, "Please name a channel to test in, rather than a category.")
+			return
+		}
+		channelID := message.ChannelID
+		if rule.scope.Kind == ChannelScope {
+			channelID = rule.scope.ID
+		}
+		allowed, err := authClient.evaluate(ctx, message.GuildID, channelID, rule.ID, nil, rule.command, rule.action)
+		target = formatPermissionTarget(rule.ID, rule.command, rule.action, PermissionScope{Kind: ChannelScope, ID: channelID}, false)
+		ParseServiceResponse(session, message.ChannelID, permissionEmbed("Permission test", target+": "+formatAllowed(allowed)), err)
+	}
+}
+
+// ListPermissions describes every permission rule in a guild, and its permissive flag
+func (authClient *AuthClient) ListPermissions(ctx context.Context, guildID string) (*discordgo.MessageEmbed, error) {
+	permissions, err := authClient.ListPermissionRules(ctx, guildID)
+	if err != nil {
+		return nil, err
+	}
+	permissive := "not set, commands without a matching rule are denied"
+	//Rules are listed by command and action, each line naming who they apply to
+	rules := make([][2]string, 0, len(permissions))
+	for _, permission := range permissions {
+		//guild!command!action, the permissive flag has no command
+		hashKey := strings.SplitN(permission.Guild, "!", 3)
+		if len(hashKey) < 3 {
+			continue
+		}
+		if hashKey[1] == "" {
+			permissive = strconv.FormatBool(permission.Allow)
+			continue
+		}
+		scope, kind, ID := SplitPermission(permission.Permission)
+		if kind == "" {
+			continue
+		}
+		rules = append(rules, [2]string{
+			hashKey[1] + " " + hashKey[2],
+			formatPermissionTarget(ID, hashKey[1], hashKey[2], scope, kind == "role") + ": " + formatAllowed(permission.Allow),
+		})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i][0] != rules[j][0] {
+			return rules[i][0] < rules[j][0]
+		}
+		return rules[i][1] < rules[j][1]
+	})
+	description := "Permissive: " + permissive + "\n"
+	for i, rule := range rules {
+		//Embed descriptions are limited to 4096 characters
+		if len(description)+len(rule[1]) > 4000 {
+			description += "\n…and " + strconv.Itoa(len(rules)-i) + " more."
+			break
+		}
+		description += "\n" + rule[1]
+	}
+	if len(rules) == 0 {
+		description += "\nNo permission rules are set."
+	}
+	return permissionEmbed("Permissions", description), nil
+}
+
+// SetPermission sets the value of a permission, in the whole guild or only within a channel or category
+func (authClient *AuthClient) SetPermission(ctx context.Context, guildID, requester, ID, command, action string, scope PermissionScope, isRole, isAllowed bool) error {
+	permission := buildPermission(guildID, ID, command, action, scope, isRole, isAllowed)
+	result, err := authClient.DynamoClient.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:    aws.String(assets.AuthTableName),
+		Item:         permission,
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
 	})
+	authClient.Cache.Invalidate(buildRulesCacheKey(guildID, command, action))
 	if err != nil {
 		authClient.AuthErrorLogger.Println(err)
+		return err
 	}
-	return err
+	authClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  requester,
+		Action: "auth set",
+		Target: formatPermissionTarget(ID, command, action, scope, isRole),
+		Before: formatPermissionValue(result.Attributes),
+		After:  strconv.FormatBool(isAllowed),
+	})
+	return nil
 }
 
 // DeletePermission deletes the records associated with a permission
-func (authClient *AuthClient) DeletePermission(guildID, ID, command, action string, isRole bool) error {
-	var key map[string]*dynamodb.AttributeValue
-	if isRole {
-		key = buildAuthorizationKey(guildID, "", ID, command, action, isRole)
-	} else {
-		key = buildAuthorizationKey(guildID, ID, "", command, action, isRole)
-	}
-	_, err := authClient.DynamoClient.DeleteItem(&dynamodb.DeleteItemInput{
-		TableName: aws.String(assets.AuthTableName),
-		Key:       key,
+func (authClient *AuthClient) DeletePermission(ctx context.Context, guildID, requester, ID, command, action string, scope PermissionScope, isRole bool) error {
+	key := buildAuthorizationKey(guildID, ID, command, action, scope, isRole)
+	result, err := authClient.DynamoClient.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:    aws.String(assets.AuthTableName),
+		Key:          key,
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
 	})
+	authClient.Cache.Invalidate(buildRulesCacheKey(guildID, command, action))
 	if err != nil {
 		authClient.AuthErrorLogger.Println(err)
+		return err
+	}
+	if len(result.Attributes) > 0 {
+		authClient.AuditClient.Record(ctx, AuditEntry{
+			Guild:  guildID,
+			Actor:  requester,
+			Action: "auth delete",
+			Target: formatPermissionTarget(ID, command, action, scope, isRole),
+			Before: formatPermissionValue(result.Attributes),
+		})
 	}
 	return nil
 }
 
-//Authorize determines a user's eligibility to invoke a command
-// returns true if authorized, false otherwise
-func (authClient *AuthClient) Authorize(guildID, userID, command, action string) bool {
-	return true
+// Authorize determines a user's eligibility to invoke a command in a channel
+// member is the guild member sent with the command's message, if any, and is looked up otherwise.
+// returns true if authorized or rules aren't enforced, false otherwise, including when the rules or the member can't be read
+func (authClient *AuthClient) Authorize(ctx context.Context, guildID, channelID, userID string, member *discordgo.Member, command, action string) bool {
+	//Permission rules belong to guilds, so there are none to apply in DMs
+	if !authClient.Enforce || guildID == "" {
+		return true
+	}
+	allowed, err := authClient.evaluate(ctx, guildID, channelID, userID, member, command, action)
+	if err != nil {
+		authClient.AuthErrorLogger.Printf("Could not evaluate %s %s for %s in %s\n", command, action, userID, guildID)
+		authClient.AuthErrorLogger.Println(err)
+		authClient.MetricsClient.Count(authServiceName, "Error", map[string]string{"Command": command})
+		return false
+	}
+	if !allowed {
+		authClient.MetricsClient.Count(authServiceName, "Denied", map[string]string{"Command": command})
+	}
+	return allowed
+}
+
+// evaluate finds the rule that decides a user's permission to invoke a command in a channel
+// scopes are searched from the most specific to the least: the channel, its category, then the whole guild.
+// Within a scope the first rule found decides, in the order the README documents.
+func (authClient *AuthClient) evaluate(ctx context.Context, guildID, channelID, userID string, member *discordgo.Member, command, action string) (bool, error) {
+	var actionRules map[string]bool
+	var err error
+	if action != "" {
+		if actionRules, err = authClient.GetPermissionRules(ctx, guildID, command, action); err != nil {
+			return false, err
+		}
+	}
+	commandRules, err := authClient.GetPermissionRules(ctx, guildID, command, "")
+	if err != nil {
+		return false, err
+	}
+	roleIDs, err := authClient.memberRoles(ctx, guildID, userID, member)
+	if err != nil {
+		return false, err
+	}
+	for _, scope := range authClient.permissionScopes(guildID, channelID) {
+		if allowed, found := evaluatePermissions(actionRules, commandRules, scope, userID, roleIDs); found {
+			return allowed, nil
+		}
+	}
+	//Auth always requires an explicit rule
+	if command == authCommand {
+		return false, nil
+	}
+	permissive, err := authClient.GetPermissiveFlagValue(ctx, guildID)
+	if errors.Is(err, ErrPermissiveFlagNotFound) {
+		return false, nil
+	}
+	return permissive, err
+}
+
+// memberRoles lists a member's roles by descending guild position, ending with @everyone whose ID is the guild's
+// a nil member is resolved from state, asking Discord if it isn't cached
+func (authClient *AuthClient) memberRoles(ctx context.Context, guildID, userID string, member *discordgo.Member) ([]string, error) {
+	if member == nil {
+		var err error
+		if member, err = authClient.Shards.Member(guildID, userID); err != nil {
+			if member, err = authClient.Shards.Primary().GuildMember(guildID, userID, discordgo.WithContext(ctx)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	positions := make(map[string]int)
+	if guild, err := authClient.Shards.Guild(guildID); err == nil {
+		for _, role := range guild.Roles {
+			positions[role.ID] = role.Position
+		}
+	}
+	roleIDs := append(make([]string, 0, len(member.Roles)+1), member.Roles...)
+	sort.SliceStable(roleIDs, func(i, j int) bool {
+		return positions[roleIDs[i]] > positions[roleIDs[j]]
+	})
+	return append(roleIDs, guildID), nil
+}
+
+// permissionScopes lists the scopes that apply in a channel from the most specific to the least
+// threads take the rules of the channel they were started in
+func (authClient *AuthClient) permissionScopes(guildID, channelID string) []PermissionScope {
+	scopes := make([]PermissionScope, 0, 3)
+	if channelID != "" {
+		channel, err := authClient.Shards.Channel(guildID, channelID)
+		if err == nil && channel.IsThread() {
+			channelID = channel.ParentID
+			channel, err = authClient.Shards.Channel(guildID, channelID)
+		}
+		scopes = append(scopes, PermissionScope{Kind: ChannelScope, ID: channelID})
+		if err == nil && channel.ParentID != "" {
+			scopes = append(scopes, PermissionScope{Kind: CategoryScope, ID: channel.ParentID})
+		}
+	}
+	return append(scopes, PermissionScope{})
+}
+
+// GetPermissionRules retrieves the rules for a command and action in a guild, keyed by user!ID or role!ID
+// rules limited to a channel or category have their scope prepended, e.g. channel!ID!user!ID
+// the rules are shared with the cache and must not be modified
+func (authClient *AuthClient) GetPermissionRules(ctx context.Context, guildID, command, action string) (map[string]bool, error) {
+	cacheKey := buildRulesCacheKey(guildID, command, action)
+	if rules, ok := authClient.Cache.Get(cacheKey); ok {
+		return rules.(map[string]bool), nil
+	}
+	rules := make(map[string]bool)
+	err := authClient.DynamoClient.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(assets.AuthTableName),
+		KeyConditionExpression: aws.String("guild=:g"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":g": &dynamodb.AttributeValue{S: aws.String(guildID + "!" + command + "!" + action)},
+		},
+	},
+		func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				rule := &PermissionObject{}
+				dynamodbattribute.UnmarshalMap(item, rule)
+				rules[rule.Permission] = rule.Allow
+			}
+			return !lastPage
+		})
+	if err != nil {
+		authClient.AuthErrorLogger.Println(err)
+		return nil, err
+	}
+	authClient.Cache.Put(cacheKey, rules)
+	return rules, nil
+}
+
+// ListPermissionRules retrieves every permission rule in a guild, including the permissive flag
+func (authClient *AuthClient) ListPermissionRules(ctx context.Context, guildID string) ([]PermissionObject, error) {
+	permissions := make([]PermissionObject, 0)
+	//The permissive flag is stored as a rule without a command
+	for _, key := range permissionHashKeys() {
+		rules, err := authClient.GetPermissionRules(ctx, guildID, key[0], key[1])
+		if err != nil {
+			return nil, err
+		}
+		for permission, allow := range rules {
+			permissions = append(permissions, PermissionObject{
+				Guild:      guildID + "!" + key[0] + "!" + key[1],
+				Permission: permission,
+				Allow:      allow,
+			})
+		}
+	}
+	return permissions, nil
 }
 
 // GetPermissiveFlagValue checks for the value of the permissive flag for a guild.
-func (authClient *AuthClient) GetPermissiveFlagValue(guildID string) (bool, error) {
+func (authClient *AuthClient) GetPermissiveFlagValue(ctx context.Context, guildID string) (bool, error) {
+	if permissive, ok := authClient.Cache.Get(buildPermissiveCacheKey(guildID)); ok {
+		return permissive.(bool), nil
+	}
 	//Permissiveness flag defines behavior when no permissions records are found
 	//permissive=true allows treats total absence permissions records for as a record granting permission
 	//conversely, permissive=false treats a total absence as a record denying permission
 	//if this record is missing, deny all requests
-	result, err := authClient.DynamoClient.GetItem(&dynamodb.GetItemInput{
+	result, err := authClient.DynamoClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(assets.AuthTableName),
-		Key:       buildAuthorizationKey(guildID, "", "", "", "", false),
+		Key:       buildAuthorizationKey(guildID, "", "", "", PermissionScope{}, false),
 	})
 	if err != nil {
 		authClient.AuthErrorLogger.Println(err)
@@ -217,29 +449,46 @@ func (authClient *AuthClient) GetPermissiveFlagValue(guildID string) (bool, erro
 	}
 	_, ok := result.Item["perm"]
 	if !ok {
-		return false, errors.New("Permissive flag not found for guild:" + guildID)
+		return false, fmt.Errorf("%w for guild:%s", ErrPermissiveFlagNotFound, guildID)
 	}
 	permissiveFlag := &PermissionObject{}
 	dynamodbattribute.UnmarshalMap(result.Item, permissiveFlag)
+	authClient.Cache.Put(buildPermissiveCacheKey(guildID), permissiveFlag.Allow)
 	return permissiveFlag.Allow, nil
 }
 
 // SetPermissiveFlagValue sets the value of the permissiveness flag to true for the first time
-func (authClient *AuthClient) SetPermissiveFlagValue(guildID string, value bool) error {
+func (authClient *AuthClient) SetPermissiveFlagValue(ctx context.Context, guildID, requester string, value bool) error {
 	//Permissiveness flag defines behavior when no permissions records are found
 	//permissive=true allows treats total absence permissions records for as a record granting permission
 	//conversely, permissive=false treats a total absence as a record denying permission
-	_, err := authClient.DynamoClient.PutItem(&dynamodb.PutItemInput{
-		TableName: aws.String(assets.AuthTableName),
-		Item:      buildPermission(guildID, "", "", "", "", false, value),
+	result, err := authClient.DynamoClient.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:    aws.String(assets.AuthTableName),
+		Item:         buildPermission(guildID, "", "", "", PermissionScope{}, false, value),
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
 	})
+	authClient.Cache.Invalidate(buildPermissiveCacheKey(guildID))
 	if err != nil {
 		authClient.AuthErrorLogger.Println(err)
 		return err
 	}
+	authClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  requester,
+		Action: "auth permissive",
+		Target: guildID,
+		Before: formatPermissionValue(result.Attributes),
+		After:  strconv.FormatBool(value),
+	})
 	return nil
 }
 
+// ForgetGuild drops every cached permission for a guild
+func (authClient *AuthClient) ForgetGuild(guildID string) {
+	authClient.Cache.Invalidate(buildPermissiveCacheKey(guildID))
+	authClient.Cache.InvalidatePrefix("rules!" + guildID + "!")
+}
+
 // Help provides assistance with the auth command by sending a help dialogue
 func (authClient *AuthClient) Help(session *discordgo.Session, channelID string) {
 	session.ChannelMessageSendEmbed(channelID,
@@ -254,163 +503,235 @@ func (authClient *AuthClient) Help(session *discordgo.Session, channelID string)
 			Fields: []*discordgo.MessageEmbedField{
 				&discordgo.MessageEmbedField{
 					Name: "set",
-					Value: "Creates a permission rule for a given command and user or role\n" +
-						"Usage: ~auth set command=$command *action=$action ^user=@user ^role=@role ^roleName=\"roleName\" permission=$bool\n" +
+					Value: "Creates a permission rule for a given command and user or role, in the whole server or only in a channel or category\n" +
+						"Usage: " + CommandPrefix + "auth set command=$command *action=$action ^user=@user ^role=@role ^roleName=\"roleName\" *channel=#channel permission=$bool\n" +
 						"* - optional argument\n" +
 						"^ - XOR",
 				},
 				&discordgo.MessageEmbedField{
 					Name: "delete",
 					Value: "Deletes a permission rule for a given command and user or role\n" +
-						"Usage: ~auth delete command=$command *action=$action ^user=@user ^role=@role ^roleName=\"roleName\" permission=$bool\n" +
+						"Usage: " + CommandPrefix + "auth delete command=$command *action=$action ^user=@user ^role=@role ^roleName=\"roleName\" *channel=#channel\n" +
 						"* - optional argument\n" +
 						"^ - XOR",
 				},
 				&discordgo.MessageEmbedField{
 					Name: "permissive",
 					Value: "Sets the value of the permissive flag\n" +
-						"Usage: ~auth permissive permission=$bool\n",
+						"Usage: " + CommandPrefix + "auth permissive permission=$bool\n",
 				},
 				&discordgo.MessageEmbedField{
 					Name: "test",
-					Value: "Tests a permission rule for a given command and user or role\n" +
-						"Usage: ~auth delete command=$command *action=$action user=@user\n" +
+					Value: "Tests whether a user may use a command, in this channel or the given one\n" +
+						"Usage: " + CommandPrefix + "auth test command=$command *action=$action user=@user *channel=#channel\n" +
 						"* - optional argument\n",
 				},
 				&discordgo.MessageEmbedField{
 					Name: "list",
 					Value: "Lists all the permissions rules for the guild\n" +
-						"Usage: ~auth list",
+						"Usage: " + CommandPrefix + "auth list",
 				},
 			},
 		})
 }
 
-func parseAuthCommandArgs(discordClient *discordgo.Session, message *discordgo.Message) (commandPermission, actionPermission, userPermission, roleIDPermission string, isRole, isAllowed bool) {
-	commandPermission = command.FindString(message.Content)
-	actionPermission = action.FindString(message.Content)
-	userPermission = user.FindString(message.Content)
-	roleIDPermission = role.FindString(message.Content)
-	roleNamePermission := roleName.FindString(message.Content)
-	isRole = false
-	switch permissionValue.FindString(message.Content) {
-	case "permission=true":
-		isAllowed = true
-	case "permission=false":
-		isAllowed = false
-	default:
-		isAllowed = false
+// parseAuthCommandArgs reads the rule an auth command names, replying with a UserError if it names no valid rule
+// a channel argument naming a category scopes the rule to the category
+func parseAuthCommandArgs(session *discordgo.Session, message *discordgo.Message) (*permissionArgs, error) {
+	rule := &permissionArgs{}
+	if arg := command.FindString(message.Content); arg != "" {
+		rule.command = strings.Split(arg, "=")[1]
 	}
-	if commandPermission != "" {
-		commandPermission = strings.Split(commandPermission, "=")[1]
+	if arg := action.FindString(message.Content); arg != "" {
+		rule.action = strings.Split(arg, "=")[1]
 	}
-	if actionPermission != "" {
-		actionPermission = strings.Split(actionPermission, "=")[1]
+	actions, ok := Commands[rule.command]
+	if !ok {
+		return nil, &UserError{Reason: "Please specify a command with command=$command."}
 	}
-	if userPermission == "" {
-		isRole = true
+	if rule.action != "" && !containsString(actions, rule.action) {
+		return nil, &UserError{Reason: "Please specify an action of " + rule.command + ", one of " + strings.Join(actions, ", ") + "."}
 	}
-	if roleNamePermission != "" && roleIDPermission == "" {
+	switch permissionValue.FindString(message.Content) {
+	case "permission=true":
+		rule.isAllowed = aws.Bool(true)
+	case "permission=false":
+		rule.isAllowed = aws.Bool(false)
+	}
+
+	userPermission := user.FindString(message.Content)
+	roleIDPermission := role.FindString(message.Content)
+	roleNamePermission := roleName.FindString(message.Content)
+	switch {
+	case userPermission != "" && roleIDPermission == "" && roleNamePermission == "":
+		rule.ID = mentionID.Replace(strings.TrimSpace(strings.SplitN(userPermission, "=", 2)[1]))
+	case userPermission == "" && roleIDPermission != "" && roleNamePermission == "":
+		rule.ID = mentionID.Replace(strings.TrimSpace(strings.SplitN(roleIDPermission, "=", 2)[1]))
+		rule.isRole = true
+	case userPermission == "" && roleIDPermission == "" && roleNamePermission != "":
 		name := strings.SplitN(roleNamePermission, "=", 2)[1]
 		name = name[1 : len(name)-1]
-		roles, err := discordClient.GuildRoles(message.GuildID)
+		roles, err := session.GuildRoles(message.GuildID)
 		if err != nil {
-			return
+			return nil, err
 		}
 		for _, role := range roles {
 			if role.Name == name {
-				roleIDPermission = role.ID
-				message.MentionRoles = append(message.MentionRoles, roleIDPermission)
-				return
+				rule.ID = role.ID
+				rule.isRole = true
+				break
 			}
 		}
+		if rule.ID == "" {
+			return nil, &UserError{Reason: "There is no role named " + name + "."}
+		}
 	}
-	return
-}
-
-func evaluatePermissions(permissions map[string]bool, command, action string) *bool {
-	var hasPermission *bool
-	commandPermission, cp := permissions[command+"!"]
-	actionPermission, ap := permissions[command+"!"+action]
-	if ap {
-		return &actionPermission
+	if !validatePermissionID(rule.ID, "") {
+		return nil, &UserError{Reason: "Please specify exactly one of user=@user, role=@role or roleName=\"roleName\"."}
 	}
-	if cp {
-		return &commandPermission
+
+	if arg := permissionChannel.FindString(message.Content); arg != "" {
+		channelID := mentionID.Replace(strings.TrimSpace(strings.SplitN(arg, "=", 2)[1]))
+		channel, err := session.State.Channel(channelID)
+		if err != nil {
+			channel, err = session.Channel(channelID)
+		}
+		if err != nil || channel.GuildID != message.GuildID || channel.IsThread() {
+			return nil, &UserError{Reason: "Please specify a channel or category in this server with channel=#channel."}
+		}
+		rule.scope = PermissionScope{Kind: ChannelScope, ID: channel.ID}
+		if channel.Type == discordgo.ChannelTypeGuildCategory {
+			rule.scope.Kind = CategoryScope
+		}
 	}
-	return hasPermission
+	return rule, nil
 }
 
-func listAuthorizationKeys(guildID string) map[string]*dynamodb.KeysAndAttributes {
-	keysAndAttributes := map[string]*dynamodb.KeysAndAttributes{
-		assets.AuthTableName: &dynamodb.KeysAndAttributes{},
+// evaluatePermissions finds the rule deciding a user's permission within a scope, if the scope has one.
+// The user's rule for the action comes first, then the user's rule for the command,
+// then each role's rule for the action and finally each role's rule for the command, with roles in descending position.
+func evaluatePermissions(actionRules, commandRules map[string]bool, scope PermissionScope, userID string, roleIDs []string) (allowed, found bool) {
+	ruleSets := []map[string]bool{actionRules, commandRules}
+	for _, rules := range ruleSets {
+		if allowed, found = rules[permissionRangeKey(scope, userID, false)]; found {
+			return allowed, found
+		}
 	}
-	keys := make([]map[string]*dynamodb.AttributeValue, 0, 10)
-	for k, v := range Commands {
-		key := make(map[string]*dynamodb.AttributeValue)
-		key["guild"] = &dynamodb.AttributeValue{S: aws.String(guildID + "!" + k + "!")}
-		keys = append(keys, key)
-		for _, action := range v {
-			key := make(map[string]*dynamodb.AttributeValue)
-			key["guild"] = &dynamodb.AttributeValue{S: aws.String(guildID + "!" + k + "!" + action)}
-			keys = append(keys, key)
+	for _, rules := range ruleSets {
+		for _, roleID := range roleIDs {
+			if allowed, found = rules[permissionRangeKey(scope, roleID, true)]; found {
+				return allowed, found
+			}
 		}
 	}
-	keysAndAttributes[assets.AuthTableName].SetKeys(keys[:len(keys)])
-	return keysAndAttributes
+	return false, false
 }
 
-func buildAuthorizationKeys(guildID, userID, command, action string, roleIDList []string) map[string]*dynamodb.KeysAndAttributes {
-	keysAndAttributes := map[string]*dynamodb.KeysAndAttributes{
-		assets.AuthTableName: &dynamodb.KeysAndAttributes{},
+// SplitPermission splits a rule's range key into the scope it applies in and the user or role it names
+// kind is "user" or "role", or empty if the range key is malformed
+func SplitPermission(permission string) (scope PermissionScope, kind, ID string) {
+	parts := strings.Split(permission, "!")
+	if len(parts) == 4 && (parts[0] == ChannelScope || parts[0] == CategoryScope) {
+		scope = PermissionScope{Kind: parts[0], ID: parts[1]}
+		parts = parts[2:]
 	}
-
-	keys := make([]map[string]*dynamodb.AttributeValue, 0, 10)
-	//Construct keys for roles
-	for _, role := range roleIDList {
-		keys = append(keys, buildAuthorizationKey(guildID, userID, role, command, action, true))
-		if action != "" {
-			keys = append(keys, buildAuthorizationKey(guildID, userID, role, command, "", true))
-		}
-	}
-	//Add key for userID
-	keys = append(keys, buildAuthorizationKey(guildID, userID, "", command, action, false))
-	if action != "" {
-		keys = append(keys, buildAuthorizationKey(guildID, userID, "", command, "", false))
+	if len(parts) != 2 || (parts[0] != "user" && parts[0] != "role") {
+		return PermissionScope{}, "", ""
 	}
-	keysAndAttributes[assets.AuthTableName].SetKeys(keys[:len(keys)])
-	return keysAndAttributes
+	return scope, parts[0], parts[1]
 }
 
-func buildAuthorizationKey(guildID, userID, roleID, command, action string, isRole bool) map[string]*dynamodb.AttributeValue {
-	var rangeKey string
+// permissionRangeKey builds the range key naming a user or role within a scope
+func permissionRangeKey(scope PermissionScope, ID string, isRole bool) string {
+	rangeKey := "user!" + ID
 	if isRole {
-		rangeKey = "role!" + roleID
-	} else {
-		rangeKey = "user!" + userID
+		rangeKey = "role!" + ID
 	}
+	if scope.Kind != "" {
+		rangeKey = scope.Kind + "!" + scope.ID + "!" + rangeKey
+	}
+	return rangeKey
+}
+
+func buildAuthorizationKey(guildID, ID, command, action string, scope PermissionScope, isRole bool) map[string]*dynamodb.AttributeValue {
 	key, _ := dynamodbattribute.MarshalMap(PermissionKey{
 		Guild:      guildID + "!" + command + "!" + action,
-		Permission: rangeKey,
+		Permission: permissionRangeKey(scope, ID, isRole),
 	})
 	return key
 }
 
-func buildPermission(guildID, userID, roleID, command, action string, isRole, isAllowed bool) map[string]*dynamodb.AttributeValue {
-	var rangeKey string
-	if isRole {
-		rangeKey = "role!" + roleID
-	} else {
-		rangeKey = "user!" + userID
-	}
+func buildPermission(guildID, ID, command, action string, scope PermissionScope, isRole, isAllowed bool) map[string]*dynamodb.AttributeValue {
 	permission, _ := dynamodbattribute.MarshalMap(PermissionObject{
 		Guild:      guildID + "!" + command + "!" + action,
-		Permission: rangeKey,
+		Permission: permissionRangeKey(scope, ID, isRole),
 		Allow:      isAllowed,
 	})
 	return permission
 }
 
+func buildRulesCacheKey(guildID, command, action string) string {
+	return "rules!" + guildID + "!" + command + "!" + action
+}
+
+// formatPermissionTarget describes who a permission applies to, what for and where, e.g. "<@&role> on react save in <#channel>"
+// categories are mentioned like channels, which Discord renders as the category's name
+func formatPermissionTarget(ID, command, action string, scope PermissionScope, isRole bool) string {
+	target := "<@" + ID + ">"
+	if isRole {
+		target = "<@&" + ID + ">"
+	}
+	target = strings.TrimSpace(target + " on " + command + " " + action)
+	if scope.Kind != "" {
+		target += " in <#" + scope.ID + ">"
+	}
+	return target
+}
+
+func formatAllowed(allowed bool) string {
+	if allowed {
+		return "allowed"
+	}
+	return "denied"
+}
+
+// permissionEmbed frames an auth reply, embeds don't ping the users and roles they mention
+func permissionEmbed(title, description string) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Color:       0xff0000,
+		Title:       title,
+		Description: description,
+	}
+}
+
+// formatPermissionValue describes a permission record's value, which is empty if there was no record
+func formatPermissionValue(permission map[string]*dynamodb.AttributeValue) string {
+	if len(permission) < 1 {
+		return ""
+	}
+	rule := &PermissionObject{}
+	dynamodbattribute.UnmarshalMap(permission, rule)
+	return strconv.FormatBool(rule.Allow)
+}
+
+// permissionHashKeys lists every command and action pair that may have permission rules, including the permissive flag
+func permissionHashKeys() [][2]string {
+	keys := [][2]string{{"", ""}}
+	for command, actions := range Commands {
+		keys = append(keys, [2]string{command, ""})
+		for _, action := range actions {
+			if action != "" {
+				keys = append(keys, [2]string{command, action})
+			}
+		}
+	}
+	return keys
+}
+
+func buildPermissiveCacheKey(guildID string) string {
+	return "permissive!" + guildID
+}
+
 func validatePermissionID(userID, roleID string) bool {
 	return !(userID == "" && roleID == "")
 }
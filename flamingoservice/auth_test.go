@@ -0,0 +1,112 @@
+package flamingoservice
+
+import (
+	"testing"
+)
+
+func TestEvaluatePermissions(t *testing.T) {
+	channel := PermissionScope{Kind: ChannelScope, ID: "500"}
+	tests := []struct {
+		name         string
+		actionRules  map[string]bool
+		commandRules map[string]bool
+		scope        PermissionScope
+		roleIDs      []string
+		allowed      bool
+		found        bool
+	}{
+		{
+			name: "no rules",
+		},
+		{
+			name:         "user rule for the command",
+			commandRules: map[string]bool{"user!100": true},
+			allowed:      true,
+			found:        true,
+		},
+		{
+			name:         "user rule for the action before the command",
+			actionRules:  map[string]bool{"user!100": false},
+			commandRules: map[string]bool{"user!100": true},
+			allowed:      false,
+			found:        true,
+		},
+		{
+			name:         "user rule before role rules",
+			actionRules:  map[string]bool{"role!300": false},
+			commandRules: map[string]bool{"user!100": true},
+			roleIDs:      []string{"300"},
+			allowed:      true,
+			found:        true,
+		},
+		{
+			name:         "role rule for the action before the command",
+			actionRules:  map[string]bool{"role!301": true},
+			commandRules: map[string]bool{"role!300": false},
+			roleIDs:      []string{"300", "301"},
+			allowed:      true,
+			found:        true,
+		},
+		{
+			name:        "higher role first",
+			actionRules: map[string]bool{"role!300": false, "role!301": true},
+			roleIDs:     []string{"301", "300"},
+			allowed:     true,
+			found:       true,
+		},
+		{
+			name:        "roles the user doesn't have",
+			actionRules: map[string]bool{"role!302": true},
+			roleIDs:     []string{"300"},
+		},
+		{
+			name:        "guild-wide rules outside the guild scope",
+			actionRules: map[string]bool{"user!100": true},
+			scope:       channel,
+		},
+		{
+			name:        "scoped rules",
+			actionRules: map[string]bool{"user!100": true, "channel!500!user!100": false},
+			scope:       channel,
+			allowed:     false,
+			found:       true,
+		},
+		{
+			name:        "other scopes",
+			actionRules: map[string]bool{"channel!501!user!100": true},
+			scope:       channel,
+		},
+	}
+	for _, test := range tests {
+		allowed, found := evaluatePermissions(test.actionRules, test.commandRules, test.scope, "100", test.roleIDs)
+		if allowed != test.allowed || found != test.found {
+			t.Errorf("%s: expected allowed %t found %t, got allowed %t found %t", test.name, test.allowed, test.found, allowed, found)
+		}
+	}
+}
+
+func TestSplitPermission(t *testing.T) {
+	tests := []struct {
+		permission string
+		scope      PermissionScope
+		kind       string
+		ID         string
+	}{
+		{permission: "user!100", kind: "user", ID: "100"},
+		{permission: "role!300", kind: "role", ID: "300"},
+		{permission: "channel!500!user!100", scope: PermissionScope{Kind: ChannelScope, ID: "500"}, kind: "user", ID: "100"},
+		{permission: "category!600!role!300", scope: PermissionScope{Kind: CategoryScope, ID: "600"}, kind: "role", ID: "300"},
+		{permission: ""},
+		{permission: "permissive"},
+		{permission: "member!100"},
+		{permission: "user!100!extra"},
+		{permission: "thread!500!user!100"},
+		{permission: "channel!500!member!100"},
+	}
+	for _, test := range tests {
+		scope, kind, ID := SplitPermission(test.permission)
+		if scope != test.scope || kind != test.kind || ID != test.ID {
+			t.Errorf("%q: expected %v %q %q, got %v %q %q", test.permission, test.scope, test.kind, test.ID, scope, kind, ID)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/flamingolog"
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cacheServiceName = "Cache"
+)
+
+// Cache is an in-process LRU cache whose entries expire after a TTL
+// it is safe for concurrent use, a nil Cache never hits so caching can be disabled
+type Cache struct {
+	Name          string
+	MetricsClient *flamingolog.FlamingoMetricsClient
+	Capacity      int
+	TTL           time.Duration
+	entries       map[string]*list.Element
+	recency       *list.List
+	lock          sync.Mutex
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewCache constructs a Cache holding up to capacity entries for ttl each
+func NewCache(name string, metricsClient *flamingolog.FlamingoMetricsClient, capacity int, ttl time.Duration) *Cache {
+	return &Cache{
+		Name:          name,
+		MetricsClient: metricsClient,
+		Capacity:      capacity,
+		TTL:           ttl,
+		entries:       make(map[string]*list.Element),
+		recency:       list.New(),
+	}
+}
+
+// Get retrieves an entry, reporting a hit or miss
+func (cache *Cache) Get(key string) (interface{}, bool) {
+	if cache == nil {
+		return nil, false
+	}
+	cache.lock.Lock()
+	element, ok := cache.entries[key]
+	if ok && time.Now().After(element.Value.(*cacheEntry).expires) {
+		cache.remove(element)
+		ok = false
+	}
+	var value interface{}
+	if ok {
+		cache.recency.MoveToFront(element)
+		value = element.Value.(*cacheEntry).value
+	}
+	cache.lock.Unlock()
+	if ok {
+		cache.MetricsClient.Count(cacheServiceName, "Hit", map[string]string{"Cache": cache.Name})
+	} else {
+		cache.MetricsClient.Count(cacheServiceName, "Miss", map[string]string{"Cache": cache.Name})
+	}
+	return value, ok
+}
+
+// Put adds or replaces an entry, evicting the least recently used entry if the cache is full
+func (cache *Cache) Put(key string, value interface{}) {
+	if cache == nil {
+		return
+	}
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if element, ok := cache.entries[key]; ok {
+		cache.remove(element)
+	}
+	cache.entries[key] = cache.recency.PushFront(&cacheEntry{
+		key:     key,
+		value:   value,
+		expires: time.Now().Add(cache.TTL),
+	})
+	for cache.recency.Len() > cache.Capacity {
+		cache.remove(cache.recency.Back())
+	}
+}
+
+// Invalidate removes an entry
+func (cache *Cache) Invalidate(key string) {
+	if cache == nil {
+		return
+	}
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if element, ok := cache.entries[key]; ok {
+		cache.remove(element)
+	}
+}
+
+// InvalidatePrefix removes every entry whose key begins with prefix
+func (cache *Cache) InvalidatePrefix(prefix string) {
+	if cache == nil {
+		return
+	}
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	for key, element := range cache.entries {
+		if strings.HasPrefix(key, prefix) {
+			cache.remove(element)
+		}
+	}
+}
+
+// remove drops an entry, callers must hold the lock
+func (cache *Cache) remove(element *list.Element) {
+	cache.recency.Remove(element)
+	delete(cache.entries, element.Value.(*cacheEntry).key)
+}
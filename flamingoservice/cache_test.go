@@ -0,0 +1,60 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/flamingolog"
+	"testing"
+	"time"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewCache("test", &flamingolog.FlamingoMetricsClient{Local: true}, 2, time.Hour)
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+	//Reading a makes b the least recently used
+	if value, ok := cache.Get("a"); !ok || value != 1 {
+		t.Fatalf("expected a to be 1, got %v %t", value, ok)
+	}
+	cache.Put("c", 3)
+	if _, ok := cache.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for key, expected := range map[string]int{"a": 1, "c": 3} {
+		if value, ok := cache.Get(key); !ok || value != expected {
+			t.Errorf("expected %s to be %d, got %v %t", key, expected, value, ok)
+		}
+	}
+	//Replacing an entry doesn't evict anything
+	cache.Put("a", 4)
+	if len(cache.entries) != 2 || cache.recency.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d in the map and %d in the list", len(cache.entries), cache.recency.Len())
+	}
+	if value, _ := cache.Get("a"); value != 4 {
+		t.Errorf("expected a to be replaced with 4, got %v", value)
+	}
+}
+
+func TestCacheExpiresEntries(t *testing.T) {
+	cache := NewCache("test", &flamingolog.FlamingoMetricsClient{Local: true}, 2, time.Hour)
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+	cache.entries["a"].Value.(*cacheEntry).expires = time.Now().Add(-time.Second)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("expected a to have expired")
+	}
+	if _, ok := cache.entries["a"]; ok || cache.recency.Len() != 1 {
+		t.Error("expected the expired entry to be removed")
+	}
+	if value, ok := cache.Get("b"); !ok || value != 2 {
+		t.Errorf("expected b to be 2, got %v %t", value, ok)
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	var cache *Cache
+	cache.Put("a", 1)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("expected a nil cache never to hit")
+	}
+	cache.Invalidate("a")
+	cache.InvalidatePrefix("")
+}
@@ -0,0 +1,257 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/flamingolog"
+	"context"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	dispatchServiceName = "Dispatch"
+	// dispatchSettingPrefix begins every guild setting that decides which commands are dispatched
+	dispatchSettingPrefix = "dispatch!"
+	// disabledSettingPrefix begins the guild settings of services the guild has disabled, followed by the service
+	disabledSettingPrefix = "dispatch!disabled!"
+	// allowedChannelSettingPrefix begins the guild settings of channels commands are limited to, followed by the channel
+	allowedChannelSettingPrefix = "dispatch!allow!"
+	// deniedChannelSettingPrefix begins the guild settings of channels commands can't be used in, followed by the channel
+	deniedChannelSettingPrefix = "dispatch!deny!"
+	// RestrictedEmoji is reacted to commands sent in channels the guild doesn't allow commands in
+	RestrictedEmoji = "🚫"
+)
+
+var (
+	// alwaysEnabled are the services guilds can't disable, since they are needed to undo it
+	alwaysEnabled = []string{"flamingo", "auth"}
+)
+
+// DispatchClient is responsible for the services each guild has enabled, and the channels commands may be used in
+type DispatchClient struct {
+	SettingsClient        *SettingsClient
+	AuditClient           *AuditClient
+	MetricsClient         *flamingolog.FlamingoMetricsClient
+	Cache                 *Cache
+	DispatchServiceLogger *log.Logger
+	DispatchErrorLogger   *log.Logger
+}
+
+// dispatchRules are a guild's disabled services and channel lists
+type dispatchRules struct {
+	disabled map[string]bool
+	allowed  map[string]bool
+	denied   map[string]bool
+}
+
+// NewDispatchClient constructs a DispatchClient
+func NewDispatchClient(settingsClient *SettingsClient,
+	auditClient *AuditClient,
+	metricsClient *flamingolog.FlamingoMetricsClient,
+	cache *Cache) *DispatchClient {
+	return &DispatchClient{
+		SettingsClient:        settingsClient,
+		AuditClient:           auditClient,
+		MetricsClient:         metricsClient,
+		Cache:                 cache,
+		DispatchServiceLogger: flamingolog.BuildServiceLogger(dispatchServiceName),
+		DispatchErrorLogger:   flamingolog.BuildServiceErrorLogger(dispatchServiceName),
+	}
+}
+
+// ServiceEnabled checks a guild hasn't disabled a service, every service is enabled outside of guilds
+// services stay enabled if the guild's settings can't be read, rather than going quiet while storage is unavailable
+func (dispatchClient *DispatchClient) ServiceEnabled(ctx context.Context, guildID, service string) bool {
+	if guildID == "" {
+		return true
+	}
+	rules, err := dispatchClient.rules(ctx, guildID)
+	if err != nil {
+		return true
+	}
+	if rules.disabled[service] {
+		dispatchClient.MetricsClient.Count(dispatchServiceName, "Disabled", map[string]string{"Service": service})
+		return false
+	}
+	return true
+}
+
+// ChannelAllowed checks a command message was sent in a channel its guild allows commands in
+// a denied channel never allows commands, and if any channels are allowed commands are limited to them.
+// Members who can manage the guild may use commands anywhere, so they can always change the channel lists.
+func (dispatchClient *DispatchClient) ChannelAllowed(ctx context.Context, session *discordgo.Session, message *discordgo.Message) bool {
+	if message.GuildID == "" {
+		return true
+	}
+	rules, err := dispatchClient.rules(ctx, message.GuildID)
+	if err != nil {
+		return true
+	}
+	channelID := listedChannel(ctx, session, message.ChannelID)
+	if !rules.denied[channelID] && (len(rules.allowed) == 0 || rules.allowed[channelID]) {
+		return true
+	}
+	if HasDiscordPermission(session, message.Author.ID, message.ChannelID, discordgo.PermissionManageServer) {
+		return true
+	}
+	dispatchClient.MetricsClient.Count(dispatchServiceName, "Restricted", nil)
+	return false
+}
+
+// listedChannel resolves the channel whose rule applies to a message, threads take the rule of the channel they were started in
+// channels missing from state are fetched from Discord, a channel that can't be resolved is its own rule
+func listedChannel(ctx context.Context, session *discordgo.Session, channelID string) string {
+	channel, err := session.State.Channel(channelID)
+	if err != nil {
+		if channel, err = session.Channel(channelID, discordgo.WithContext(ctx)); err != nil {
+			return channelID
+		}
+	}
+	if channel.IsThread() {
+		return channel.ParentID
+	}
+	return channelID
+}
+
+// SetServiceEnabled enables or disables a service in a guild
+func (dispatchClient *DispatchClient) SetServiceEnabled(ctx context.Context, guildID, requester, service string, enabled bool) (string, error) {
+	if _, ok := Commands[service]; !ok {
+		return "Please specify one of " + strings.Join(toggleableServices(), ", ") + ".", nil
+	}
+	if containsString(alwaysEnabled, service) {
+		return service + " can't be disabled.", nil
+	}
+	defer dispatchClient.Forget(guildID)
+	if enabled {
+		if err := dispatchClient.SettingsClient.DeleteSetting(ctx, GuildScope(guildID), disabledSettingPrefix+service); err != nil {
+			return "", err
+		}
+		dispatchClient.AuditClient.Record(ctx, AuditEntry{Guild: guildID, Actor: requester, Action: "flamingo enable", Target: service})
+		return service + " is enabled in this server.", nil
+	}
+	if err := dispatchClient.SettingsClient.SetSetting(ctx, GuildScope(guildID), disabledSettingPrefix+service, "true"); err != nil {
+		return "", err
+	}
+	dispatchClient.AuditClient.Record(ctx, AuditEntry{Guild: guildID, Actor: requester, Action: "flamingo disable", Target: service})
+	return service + " is disabled in this server.", nil
+}
+
+// SetChannelRule allows commands in a channel, denies them, or clears the channel from both lists
+// clearing without a channel clears every channel
+func (dispatchClient *DispatchClient) SetChannelRule(ctx context.Context, session *discordgo.Session, guildID, requester, rule, channel string) (string, error) {
+	defer dispatchClient.Forget(guildID)
+	if rule == "clear" && channel == "" {
+		rules, err := dispatchClient.SettingsClient.ListSettings(ctx, GuildScope(guildID), dispatchSettingPrefix)
+		if err != nil {
+			return "", err
+		}
+		for setting := range rules {
+			if strings.HasPrefix(setting, disabledSettingPrefix) {
+				continue
+			}
+			if err := dispatchClient.SettingsClient.DeleteSetting(ctx, GuildScope(guildID), setting); err != nil {
+				return "", err
+			}
+		}
+		dispatchClient.AuditClient.Record(ctx, AuditEntry{Guild: guildID, Actor: requester, Action: "flamingo channels", Target: "every channel", After: "clear"})
+		return "Commands can be used in every channel.", nil
+	}
+	channelID := mentionID.Replace(channel)
+	target, err := session.State.Channel(channelID)
+	if err != nil || target.GuildID != guildID {
+		return "Please mention a channel in this server.", nil
+	}
+	//A channel is on at most one list, so changing its rule removes it from the other
+	for _, prefix := range []string{allowedChannelSettingPrefix, deniedChannelSettingPrefix} {
+		if err := dispatchClient.SettingsClient.DeleteSetting(ctx, GuildScope(guildID), prefix+channelID); err != nil {
+			return "", err
+		}
+	}
+	result := "Commands are no longer limited in <#" + channelID + ">."
+	switch rule {
+	case "allow":
+		err = dispatchClient.SettingsClient.SetSetting(ctx, GuildScope(guildID), allowedChannelSettingPrefix+channelID, "true")
+		result = "Commands can be used in <#" + channelID + ">. While any channels are allowed, commands can only be used in them."
+	case "deny":
+		err = dispatchClient.SettingsClient.SetSetting(ctx, GuildScope(guildID), deniedChannelSettingPrefix+channelID, "true")
+		result = "Commands can no longer be used in <#" + channelID + ">."
+	}
+	if err != nil {
+		return "", err
+	}
+	dispatchClient.AuditClient.Record(ctx, AuditEntry{Guild: guildID, Actor: requester, Action: "flamingo channels", Target: "<#" + channelID + ">", After: rule})
+	return result, nil
+}
+
+// Describe summarizes the services a guild has disabled and the channels it allows or denies commands in
+func (dispatchClient *DispatchClient) Describe(ctx context.Context, guildID string) (string, error) {
+	rules, err := dispatchClient.rules(ctx, guildID)
+	if err != nil {
+		return "", err
+	}
+	description := "Disabled services: " + describeKeys(rules.disabled, "", "none")
+	description += "\nCommands allowed in: " + describeKeys(rules.allowed, "<#", "every channel")
+	description += "\nCommands denied in: " + describeKeys(rules.denied, "<#", "no channels")
+	return description, nil
+}
+
+// Forget drops a guild's cached rules, so changes made outside of DispatchClient take effect
+func (dispatchClient *DispatchClient) Forget(guildID string) {
+	dispatchClient.Cache.Invalidate(guildID)
+}
+
+func (dispatchClient *DispatchClient) rules(ctx context.Context, guildID string) (*dispatchRules, error) {
+	if cached, ok := dispatchClient.Cache.Get(guildID); ok {
+		return cached.(*dispatchRules), nil
+	}
+	settings, err := dispatchClient.SettingsClient.ListSettings(ctx, GuildScope(guildID), dispatchSettingPrefix)
+	if err != nil {
+		return nil, err
+	}
+	rules := &dispatchRules{
+		disabled: make(map[string]bool),
+		allowed:  make(map[string]bool),
+		denied:   make(map[string]bool),
+	}
+	for setting := range settings {
+		switch {
+		case strings.HasPrefix(setting, disabledSettingPrefix):
+			rules.disabled[strings.TrimPrefix(setting, disabledSettingPrefix)] = true
+		case strings.HasPrefix(setting, allowedChannelSettingPrefix):
+			rules.allowed[strings.TrimPrefix(setting, allowedChannelSettingPrefix)] = true
+		case strings.HasPrefix(setting, deniedChannelSettingPrefix):
+			rules.denied[strings.TrimPrefix(setting, deniedChannelSettingPrefix)] = true
+		}
+	}
+	dispatchClient.Cache.Put(guildID, rules)
+	return rules, nil
+}
+
+// toggleableServices lists the services guilds can enable and disable
+func toggleableServices() []string {
+	services := make([]string, 0, len(Commands))
+	for service := range Commands {
+		if !containsString(alwaysEnabled, service) {
+			services = append(services, service)
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+func describeKeys(keys map[string]bool, mention, none string) string {
+	if len(keys) == 0 {
+		return none
+	}
+	described := make([]string, 0, len(keys))
+	for key := range keys {
+		if mention != "" {
+			key = mention + key + ">"
+		}
+		described = append(described, key)
+	}
+	sort.Strings(described)
+	return strings.Join(described, ", ")
+}
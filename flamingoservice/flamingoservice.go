@@ -1,30 +1,37 @@
 package flamingoservice
 
 import (
-	"github.com/bwmarrin/discordgo"
-)
+	"context"
+	"errors"
+	"strings"
 
-const (
-	// CommandPrefix is the prefix the bot listens for to identify commands.
-	CommandPrefix string = "~"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/bwmarrin/discordgo"
 )
 
 var (
+	// CommandPrefix is the prefix the bot listens for to identify commands.
+	CommandPrefix = "~"
 	// Commands is the source of truth for all available commands and command actions
 	Commands = map[string][]string{
-		"strike": {"", "super", "get", "clear", "help"},
-		"pasta":  {"get", "save", "edit", "list", "help"},
-		"template":  {"get", "save", "edit", "list", "help"},
-		"react":  {"get", "save", "delete", "list", "help"},
-		"auth":   {"set", "delete", "test", "permissive", "list", "help"},
+		"strike":   {"", "super", "get", "clear", "help"},
+		"pasta":    {"get", "save", "edit", "list", "help"},
+		"template": {"get", "save", "edit", "list", "help"},
+		"react":    {"get", "save", "delete", "tag", "untag", "random", "promote", "promotions", "approve", "reject", "size", "list", "help"},
+		"spoiler":  {"guild", "me", "emoji", "help"},
+		"auth":     {"set", "delete", "test", "permissive", "list", "help"},
+		"flamingo": {"export", "import", "forget-me", "enable", "disable", "channels", "help"},
+		"audit":    {"recent", "channel", "help"},
 	}
 )
 
 // FlamingoService is an interface for services. Services are responsible for identifying a potential invocation.
 // If a message is identified as a command, the service is responsible for replying.
+// ctx carries the deadline for the command, services should stop work once it is done.
 type FlamingoService interface {
 	IsCommand(message string) bool
-	Handle(session *discordgo.Session, message *discordgo.Message)
+	Handle(ctx context.Context, session *discordgo.Session, message *discordgo.Message)
 }
 
 // BooleanCommandSuccess is a wrapper for the return value of commands that return a boolean
@@ -33,9 +40,51 @@ type BooleanCommandSuccess struct {
 	Result  bool
 }
 
+// UserError is an error with a reason that is safe to show to users
+type UserError struct {
+	Reason string
+}
+
+func (err *UserError) Error() string {
+	return err.Reason
+}
+
+// HasDiscordPermission checks a user's Discord permissions in a channel, which include their guild-wide permissions
+func HasDiscordPermission(session *discordgo.Session, userID, channelID string, permission int64) bool {
+	permissions, err := session.UserChannelPermissions(userID, channelID)
+	if err != nil {
+		return false
+	}
+	return permissions&permission != 0
+}
+
+// CommandAction identifies the action of a command message, without its prefix
+// returns "" if the second word isn't one of the command's actions, e.g. for "strike @user"
+func CommandAction(command, message string) string {
+	args := strings.Fields(message)
+	if len(args) > 1 && containsString(Commands[command], args[1]) {
+		return args[1]
+	}
+	return ""
+}
+
+// IsTimeout checks if an error was caused by a command's context expiring
+// AWS requests report cancellation with their own error code rather than wrapping the context's error
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == request.CanceledErrorCode
+}
+
 // ParseServiceResponse is a helper to remove some repetitive error handling boilerplate from code.
 func ParseServiceResponse(session *discordgo.Session, channelID string, response interface{}, err error) {
-	if err != nil {
+	if userErr, ok := err.(*UserError); ok {
+		session.ChannelMessageSend(channelID, userErr.Reason)
+	} else if IsTimeout(err) {
+		session.ChannelMessageSend(channelID, "That took too long and timed out. Please try again later.")
+	} else if err != nil {
 		session.ChannelMessageSend(channelID, "An error occured. Please try again later.")
 	} else {
 		stringResponse, isString := response.(string)
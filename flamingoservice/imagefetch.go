@@ -0,0 +1,139 @@
+package flamingoservice
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	// Registers gif and jpeg decoders alongside png for image.Decode
+	_ "image/gif"
+	_ "image/jpeg"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// DiscordCDNHosts are the hosts Discord serves attachments from
+	DiscordCDNHosts = []string{"cdn.discordapp.com", "media.discordapp.net"}
+	// ImageTypes are the image MIME types that can be decoded
+	ImageTypes = []string{"image/png", "image/jpeg", "image/gif"}
+)
+
+// ImageFetcher downloads user supplied images with limits on origin, type and size
+type ImageFetcher struct {
+	HTTPClient   *http.Client
+	AllowedHosts []string
+	AllowedTypes []string
+	MaxBytes     int64
+	MaxPixels    int
+}
+
+// NewImageFetcher constructs an ImageFetcher restricted to the Discord CDN
+func NewImageFetcher() *ImageFetcher {
+	imageFetcher := &ImageFetcher{
+		AllowedHosts: DiscordCDNHosts,
+		AllowedTypes: ImageTypes,
+		MaxBytes:     8 << 20,
+		MaxPixels:    4096 * 4096,
+	}
+	imageFetcher.HTTPClient = &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return &UserError{Reason: "That link redirects too many times."}
+			}
+			return imageFetcher.checkURL(request.URL)
+		},
+	}
+	return imageFetcher
+}
+
+// Fetch downloads and decodes an image
+// the image dimensions are checked before decoding so oversized images are never allocated
+func (imageFetcher *ImageFetcher) Fetch(ctx context.Context, rawURL string) (image.Image, error) {
+	imageURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, &UserError{Reason: "That doesn't look like a valid link."}
+	}
+	if err = imageFetcher.checkURL(imageURL); err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := imageFetcher.HTTPClient.Do(request)
+	if err != nil {
+		var userErr *UserError
+		if errors.As(err, &userErr) {
+			return nil, userErr
+		}
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, &UserError{Reason: fmt.Sprintf("Could not download the image (HTTP %d).", response.StatusCode)}
+	}
+	contentType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if !containsString(imageFetcher.AllowedTypes, contentType) {
+		return nil, &UserError{Reason: "Only " + strings.Join(imageFetcher.AllowedTypes, ", ") + " images are supported."}
+	}
+	if response.ContentLength > imageFetcher.MaxBytes {
+		return nil, imageFetcher.tooLarge()
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, imageFetcher.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > imageFetcher.MaxBytes {
+		return nil, imageFetcher.tooLarge()
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		return nil, &UserError{Reason: "That file could not be read as an image."}
+	}
+	if "image/"+format != contentType {
+		return nil, &UserError{Reason: "That file is not the type of image it claims to be."}
+	}
+	if config.Width < 1 || config.Height < 1 || config.Width*config.Height > imageFetcher.MaxPixels {
+		return nil, &UserError{Reason: fmt.Sprintf("Images must be at most %d pixels in total. That one is %dx%d.",
+			imageFetcher.MaxPixels, config.Width, config.Height)}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, &UserError{Reason: "That file could not be read as an image."}
+	}
+	return img, nil
+}
+
+func (imageFetcher *ImageFetcher) checkURL(imageURL *url.URL) error {
+	if imageURL.Scheme != "https" {
+		return &UserError{Reason: "Images must be linked over https."}
+	}
+	if !containsString(imageFetcher.AllowedHosts, strings.ToLower(imageURL.Hostname())) {
+		return &UserError{Reason: "Images must be uploaded to Discord."}
+	}
+	return nil
+}
+
+func (imageFetcher *ImageFetcher) tooLarge() error {
+	return &UserError{Reason: fmt.Sprintf("Images must be at most %d KB.", imageFetcher.MaxBytes>>10)}
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/assets"
+	"FlamingoV2/flamingolog"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	onboardingServiceName = "Onboarding"
+	bootstrapSetting      = "bootstrap!state"
+	bootstrapComplete     = "complete"
+	bootstrapLeft         = "left"
+)
+
+// OnboardingClient is responsible for initializing guilds Flamingo joins and cleaning up after guilds it leaves
+// bootstrap state is persisted per guild so initialization is idempotent across reconnects and restarts
+type OnboardingClient struct {
+	AuthClient              *AuthClient
+	SettingsClient          *SettingsClient
+	ArchiveClient           *ArchiveClient
+	MetricsClient           *flamingolog.FlamingoMetricsClient
+	OnboardingServiceLogger *log.Logger
+	OnboardingErrorLogger   *log.Logger
+	// PurgeGrace is how long a guild's data is kept after Flamingo leaves it, zero keeps it forever
+	PurgeGrace time.Duration
+	// bootstrapped remembers guilds already checked by this process, so reconnects don't re-read their state
+	bootstrapped sync.Map
+}
+
+// NewOnboardingClient constructs an OnboardingClient
+func NewOnboardingClient(authClient *AuthClient,
+	settingsClient *SettingsClient,
+	archiveClient *ArchiveClient,
+	metricsClient *flamingolog.FlamingoMetricsClient,
+	purgeGrace time.Duration) *OnboardingClient {
+	return &OnboardingClient{
+		AuthClient:              authClient,
+		SettingsClient:          settingsClient,
+		ArchiveClient:           archiveClient,
+		MetricsClient:           metricsClient,
+		PurgeGrace:              purgeGrace,
+		OnboardingServiceLogger: flamingolog.BuildServiceLogger(onboardingServiceName),
+		OnboardingErrorLogger:   flamingolog.BuildServiceErrorLogger(onboardingServiceName),
+	}
+}
+
+// GuildCreate bootstraps a guild the first time Flamingo sees it
+// GuildCreate is also sent for every guild on connecting, so guilds that are already bootstrapped are skipped
+func (onboardingClient *OnboardingClient) GuildCreate(ctx context.Context, session *discordgo.Session, guildCreate *discordgo.GuildCreate) {
+	if guildCreate.Unavailable {
+		return
+	}
+	if _, ok := onboardingClient.bootstrapped.Load(guildCreate.ID); ok {
+		return
+	}
+	if err := onboardingClient.Bootstrap(ctx, session, guildCreate.Guild); err != nil {
+		onboardingClient.OnboardingErrorLogger.Printf("An error occured while bootstrapping %s, it will be retried on the next GuildCreate\n", guildCreate.ID)
+		onboardingClient.OnboardingErrorLogger.Println(err)
+		return
+	}
+	onboardingClient.bootstrapped.Store(guildCreate.ID, true)
+}
+
+// Bootstrap initializes a guild's permissions and welcomes its owner
+// every step is idempotent, so a bootstrap interrupted part way is safe to retry
+func (onboardingClient *OnboardingClient) Bootstrap(ctx context.Context, session *discordgo.Session, guild *discordgo.Guild) error {
+	scope := GuildScope(guild.ID)
+	state, _, err := onboardingClient.SettingsClient.GetSetting(ctx, scope, bootstrapSetting)
+	if err != nil {
+		return err
+	}
+	if state == bootstrapComplete {
+		return nil
+	}
+	if state == bootstrapLeft {
+		if err = onboardingClient.ArchiveClient.CancelPurge(ctx, guild.ID); err != nil {
+			return err
+		}
+	}
+	//Guilds joined before bootstrap state was tracked already have a permissive flag, which must not be reset
+	_, err = onboardingClient.AuthClient.GetPermissiveFlagValue(ctx, guild.ID)
+	if err != nil && !errors.Is(err, ErrPermissiveFlagNotFound) {
+		return err
+	}
+	fresh := err != nil
+	if fresh {
+		onboardingClient.OnboardingServiceLogger.Printf("Joined %s. Setting permissive flag.\n", guild.ID)
+		if err = onboardingClient.AuthClient.SetPermissiveFlagValue(ctx, guild.ID, AuditSystem, true); err != nil {
+			return err
+		}
+		if err = onboardingClient.AuthClient.SetPermission(ctx, guild.ID, AuditSystem, guild.OwnerID, authCommand, "", PermissionScope{}, false, true); err != nil {
+			return err
+		}
+	}
+	if fresh || state == bootstrapLeft {
+		onboardingClient.welcome(session, guild)
+		onboardingClient.MetricsClient.Count(onboardingServiceName, "Joined", nil)
+	}
+	return onboardingClient.SettingsClient.SetSetting(ctx, scope, bootstrapSetting, bootstrapComplete)
+}
+
+// GuildDelete cleans up after a guild Flamingo was removed from
+// guilds that are only unavailable due to an outage are left alone
+func (onboardingClient *OnboardingClient) GuildDelete(ctx context.Context, session *discordgo.Session, guildDelete *discordgo.GuildDelete) {
+	if guildDelete.Unavailable {
+		return
+	}
+	onboardingClient.OnboardingServiceLogger.Printf("Left %s.\n", guildDelete.ID)
+	onboardingClient.bootstrapped.Delete(guildDelete.ID)
+	onboardingClient.AuthClient.ForgetGuild(guildDelete.ID)
+	//Saved data is kept for the grace period in case Flamingo is added back, the owner is welcomed again if it is
+	err := onboardingClient.SettingsClient.SetSetting(ctx, GuildScope(guildDelete.ID), bootstrapSetting, bootstrapLeft)
+	if err == nil && onboardingClient.PurgeGrace > 0 {
+		err = onboardingClient.ArchiveClient.SchedulePurge(ctx, guildDelete.ID, onboardingClient.PurgeGrace)
+	}
+	if err != nil {
+		onboardingClient.OnboardingErrorLogger.Printf("An error occured while recording leaving %s\n", guildDelete.ID)
+		onboardingClient.OnboardingErrorLogger.Println(err)
+	}
+	onboardingClient.MetricsClient.Count(onboardingServiceName, "Left", nil)
+}
+
+// welcome DMs the owner of a guild an introduction to Flamingo
+// if the owner doesn't accept DMs, the introduction is posted in the guild's system channel instead
+func (onboardingClient *OnboardingClient) welcome(session *discordgo.Session, guild *discordgo.Guild) {
+	embed := &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{},
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: assets.AvatarURL,
+		},
+		Color:       0xff69b4,
+		Title:       "Thanks for adding Flamingo to " + guild.Name + "!",
+		Description: "Flamingo is a bot with no intrinsic value. It's purely for the memes.",
+		Fields: []*discordgo.MessageEmbedField{
+			&discordgo.MessageEmbedField{
+				Name: "Commands",
+				Value: "Commands start with `" + CommandPrefix + "`. " +
+					"Try `" + CommandPrefix + "strike help`, `" + CommandPrefix + "pasta help`, `" + CommandPrefix + "template help`, " +
+					"`" + CommandPrefix + "react help` or `" + CommandPrefix + "spoiler help` to see what each command can do.",
+			},
+			&discordgo.MessageEmbedField{
+				Name: "Permissions",
+				Value: "Your server starts out permissive, which means everyone can use every command unless a rule says otherwise. " +
+					"As the owner, you have been granted permission to manage those rules.",
+			},
+		},
+	}
+	dmChannel, err := session.UserChannelCreate(guild.OwnerID)
+	if err == nil {
+		_, err = session.ChannelMessageSendEmbed(dmChannel.ID, embed)
+	}
+	if err != nil && guild.SystemChannelID != "" {
+		_, err = session.ChannelMessageSendEmbed(guild.SystemChannelID, embed)
+	}
+	if err != nil {
+		onboardingClient.OnboardingErrorLogger.Printf("Could not welcome the owner of %s\n", guild.ID)
+		onboardingClient.OnboardingErrorLogger.Println(err)
+	}
+}
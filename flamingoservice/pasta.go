@@ -3,6 +3,7 @@ package flamingoservice
 import (
 	"FlamingoV2/assets"
 	"FlamingoV2/flamingolog"
+	"context"
 	"log"
 	"strings"
 
@@ -25,6 +26,8 @@ type PastaClient struct {
 	DynamoClient       *dynamodb.DynamoDB
 	MetricsClient      *flamingolog.FlamingoMetricsClient
 	AuthClient         *AuthClient
+	AuditClient        *AuditClient
+	Cache              *Cache
 	PastaServiceLogger *log.Logger
 	PastaErrorLogger   *log.Logger
 }
@@ -44,11 +47,13 @@ type PastaKey struct {
 }
 
 // NewPastaClient constructs a PastaClient
-func NewPastaClient(dynamoClient *dynamodb.DynamoDB, metricsClient *flamingolog.FlamingoMetricsClient, authClient *AuthClient) *PastaClient {
+func NewPastaClient(dynamoClient *dynamodb.DynamoDB, metricsClient *flamingolog.FlamingoMetricsClient, authClient *AuthClient, auditClient *AuditClient, cache *Cache) *PastaClient {
 	return &PastaClient{
 		DynamoClient:       dynamoClient,
 		MetricsClient:      metricsClient,
 		AuthClient:         authClient,
+		AuditClient:        auditClient,
+		Cache:              cache,
 		PastaServiceLogger: flamingolog.BuildServiceLogger(pastaServiceName),
 		PastaErrorLogger:   flamingolog.BuildServiceErrorLogger(pastaServiceName),
 	}
@@ -60,7 +65,7 @@ func (pastaClient *PastaClient) IsCommand(message string) bool {
 }
 
 // Handle parses a command message and performs the commanded action
-func (pastaClient *PastaClient) Handle(session *discordgo.Session, message *discordgo.Message) {
+func (pastaClient *PastaClient) Handle(ctx context.Context, session *discordgo.Session, message *discordgo.Message) {
 	//first word is always "pasta", safe to remove
 	args := strings.SplitN(message.Content, " ", 4)[1:]
 	if len(args) < 1 {
@@ -78,8 +83,8 @@ func (pastaClient *PastaClient) Handle(session *discordgo.Session, message *disc
 			session.ChannelMessageSend(message.ChannelID, "Please specify a copypasta to get!")
 			return
 		}
-		if pastaClient.AuthClient.Authorize(message.GuildID, message.Author.ID, pastaCommand, "get") {
-			pasta, err := pastaClient.GetPasta(message.GuildID, args[1])
+		if pastaClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, pastaCommand, "get") {
+			pasta, err := pastaClient.GetPasta(ctx, message.GuildID, args[1])
 			ParseServiceResponse(session, message.ChannelID, pasta, err)
 		} else {
 			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
@@ -89,8 +94,8 @@ func (pastaClient *PastaClient) Handle(session *discordgo.Session, message *disc
 			session.ChannelMessageSend(message.ChannelID, "Please specify a copypasta or an alias!")
 			return
 		}
-		if pastaClient.AuthClient.Authorize(message.GuildID, message.Author.ID, pastaCommand, "save") {
-			result, err := pastaClient.SavePasta(message.GuildID, message.Author.ID, args[1], args[2])
+		if pastaClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, pastaCommand, "save") {
+			result, err := pastaClient.SavePasta(ctx, message.GuildID, message.Author.ID, args[1], args[2])
 			if result {
 				ParseServiceResponse(session, message.ChannelID, "Copypasta with alias "+args[1]+" saved.", err)
 			} else {
@@ -104,10 +109,10 @@ func (pastaClient *PastaClient) Handle(session *discordgo.Session, message *disc
 			session.ChannelMessageSend(message.ChannelID, "Please specify a copypasta or an alias!")
 			return
 		}
-		result, err := pastaClient.EditPasta(message.GuildID, message.ChannelID, message.Author.ID, args[1], args[2])
+		result, err := pastaClient.EditPasta(ctx, message.GuildID, message.ChannelID, message.Author.ID, args[1], args[2])
 		ParseServiceResponse(session, message.ChannelID, result, err)
 	case "list":
-		pastaClient.ListPasta(session, message.GuildID, message.ChannelID, message.Author.ID)
+		pastaClient.ListPasta(ctx, session, message.GuildID, message.ChannelID, message.Author.ID)
 	case "help":
 		pastaClient.Help(session, message.ChannelID)
 	default:
@@ -116,8 +121,11 @@ func (pastaClient *PastaClient) Handle(session *discordgo.Session, message *disc
 }
 
 // GetPasta returns a guild pasta by alias
-func (pastaClient *PastaClient) GetPasta(guildID, alias string) (string, error) {
-	result, err := pastaClient.DynamoClient.GetItem(&dynamodb.GetItemInput{
+func (pastaClient *PastaClient) GetPasta(ctx context.Context, guildID, alias string) (string, error) {
+	if pasta, ok := pastaClient.Cache.Get(guildID + "!" + alias); ok {
+		return pasta.(string), nil
+	}
+	result, err := pastaClient.DynamoClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(assets.PastaTableName),
 		Key:       buildPastaKey(guildID, alias),
 	})
@@ -127,14 +135,15 @@ func (pastaClient *PastaClient) GetPasta(guildID, alias string) (string, error)
 	}
 	pasta, ok := result.Item["pasta"]
 	if ok {
+		pastaClient.Cache.Put(guildID+"!"+alias, *pasta.S)
 		return *pasta.S, nil
 	}
 	return "No copypasta with alias " + alias + " found.", nil
 }
 
 // SavePasta saves a pasta, with a unique alias for a guild
-func (pastaClient *PastaClient) SavePasta(guildID, owner, alias, pasta string) (bool, error) {
-	_, err := pastaClient.DynamoClient.PutItem(&dynamodb.PutItemInput{
+func (pastaClient *PastaClient) SavePasta(ctx context.Context, guildID, owner, alias, pasta string) (bool, error) {
+	_, err := pastaClient.DynamoClient.PutItemWithContext(ctx, &dynamodb.PutItemInput{
 		TableName:           aws.String(assets.PastaTableName),
 		Item:                buildPasta(guildID, owner, alias, pasta),
 		ConditionExpression: aws.String("attribute_not_exists(guild) and attribute_not_exists(alias)"),
@@ -149,12 +158,13 @@ func (pastaClient *PastaClient) SavePasta(guildID, owner, alias, pasta string) (
 		}
 		return false, err
 	}
+	pastaClient.Cache.Invalidate(guildID + "!" + alias)
 	return true, nil
 }
 
 // EditPasta updates an existing pasta, provided the requester is the author of said pasta
-func (pastaClient *PastaClient) EditPasta(guildID, channelID, requester, alias, pasta string) (string, error) {
-	_, err := pastaClient.DynamoClient.UpdateItem(&dynamodb.UpdateItemInput{
+func (pastaClient *PastaClient) EditPasta(ctx context.Context, guildID, channelID, requester, alias, pasta string) (string, error) {
+	result, err := pastaClient.DynamoClient.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
 		TableName:           aws.String(assets.PastaTableName),
 		Key:                 buildPastaKey(guildID, alias),
 		ConditionExpression: aws.String("#o=:r"),
@@ -166,12 +176,13 @@ func (pastaClient *PastaClient) EditPasta(guildID, channelID, requester, alias,
 			":r": &dynamodb.AttributeValue{S: aws.String(requester)},
 		},
 		UpdateExpression: aws.String("SET pasta=:p"),
+		ReturnValues:     aws.String(dynamodb.ReturnValueUpdatedOld),
 	})
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok {
 			switch awsErr.Code() {
 			case dynamodb.ErrCodeConditionalCheckFailedException:
-				author, err := pastaClient.DynamoClient.GetItem(&dynamodb.GetItemInput{
+				author, err := pastaClient.DynamoClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 					TableName:            aws.String(assets.PastaTableName),
 					Key:                  buildPastaKey(guildID, alias),
 					ProjectionExpression: aws.String("#o"),
@@ -193,11 +204,125 @@ func (pastaClient *PastaClient) EditPasta(guildID, channelID, requester, alias,
 		pastaClient.PastaErrorLogger.Println(err)
 		return "", err
 	}
+	pastaClient.Cache.Invalidate(guildID + "!" + alias)
+	previous := Pasta{}
+	dynamodbattribute.UnmarshalMap(result.Attributes, &previous)
+	pastaClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  requester,
+		Action: "pasta edit",
+		Target: alias,
+		Before: previous.Pasta,
+		After:  pasta,
+	})
 	return "Copypasta with alias " + alias + " updated.", nil
 }
 
+// LookupPasta retrieves a guild pasta with its owner, nil if there is no pasta with the alias
+func (pastaClient *PastaClient) LookupPasta(ctx context.Context, guildID, alias string) (*Pasta, error) {
+	result, err := pastaClient.DynamoClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(assets.PastaTableName),
+		Key:       buildPastaKey(guildID, alias),
+	})
+	if err != nil {
+		pastaClient.PastaErrorLogger.Println(err)
+		return nil, err
+	}
+	if _, ok := result.Item["pasta"]; !ok {
+		return nil, nil
+	}
+	pasta := &Pasta{}
+	dynamodbattribute.UnmarshalMap(result.Item, pasta)
+	return pasta, nil
+}
+
+// ListPastas retrieves every pasta saved in a guild
+func (pastaClient *PastaClient) ListPastas(ctx context.Context, guildID string) ([]Pasta, error) {
+	pastas := make([]Pasta, 0)
+	err := pastaClient.DynamoClient.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(assets.PastaTableName),
+		KeyConditionExpression: aws.String("guild=:g"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":g": &dynamodb.AttributeValue{S: aws.String(guildID)},
+		},
+	},
+		func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				pasta := Pasta{}
+				dynamodbattribute.UnmarshalMap(item, &pasta)
+				pastas = append(pastas, pasta)
+			}
+			return !lastPage
+		})
+	if err != nil {
+		pastaClient.PastaErrorLogger.Println(err)
+		return nil, err
+	}
+	return pastas, nil
+}
+
+// ReplacePasta updates an existing pasta regardless of who authored it, returning false if it does not exist
+func (pastaClient *PastaClient) ReplacePasta(ctx context.Context, guildID, requester, alias, pasta string) (bool, error) {
+	result, err := pastaClient.DynamoClient.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(assets.PastaTableName),
+		Key:                 buildPastaKey(guildID, alias),
+		ConditionExpression: aws.String("attribute_exists(alias)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":p": &dynamodb.AttributeValue{S: aws.String(pasta)},
+		},
+		UpdateExpression: aws.String("SET pasta=:p"),
+		ReturnValues:     aws.String(dynamodb.ReturnValueUpdatedOld),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+		pastaClient.PastaErrorLogger.Println(err)
+		return false, err
+	}
+	pastaClient.Cache.Invalidate(guildID + "!" + alias)
+	previous := Pasta{}
+	dynamodbattribute.UnmarshalMap(result.Attributes, &previous)
+	pastaClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  requester,
+		Action: "pasta edit",
+		Target: alias,
+		Before: previous.Pasta,
+		After:  pasta,
+	})
+	return true, nil
+}
+
+// DeletePasta deletes a pasta, returning false if it does not exist
+func (pastaClient *PastaClient) DeletePasta(ctx context.Context, guildID, requester, alias string) (bool, error) {
+	result, err := pastaClient.DynamoClient.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:    aws.String(assets.PastaTableName),
+		Key:          buildPastaKey(guildID, alias),
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if err != nil {
+		pastaClient.PastaErrorLogger.Println(err)
+		return false, err
+	}
+	pastaClient.Cache.Invalidate(guildID + "!" + alias)
+	if len(result.Attributes) < 1 {
+		return false, nil
+	}
+	previous := Pasta{}
+	dynamodbattribute.UnmarshalMap(result.Attributes, &previous)
+	pastaClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  requester,
+		Action: "pasta delete",
+		Target: alias,
+		Before: previous.Pasta,
+	})
+	return true, nil
+}
+
 // ListPasta dms the user a list of all pasta saved on the server it was called from
-func (pastaClient *PastaClient) ListPasta(session *discordgo.Session, guildID, channelID, userID string) {
+func (pastaClient *PastaClient) ListPasta(ctx context.Context, session *discordgo.Session, guildID, channelID, userID string) {
 	var guildName string
 	guild, err := session.Guild(guildID)
 	if err != nil {
@@ -225,7 +350,7 @@ func (pastaClient *PastaClient) ListPasta(session *discordgo.Session, guildID, c
 		Limit: aws.Int64(15),
 	}
 
-	err = pastaClient.DynamoClient.QueryPages(pastaList,
+	err = pastaClient.DynamoClient.QueryPagesWithContext(ctx, pastaList,
 		func(page *dynamodb.QueryOutput, lastPage bool) bool {
 			//List pastas in chat
 			guildPastaList := buildPastaPage(page)
@@ -264,22 +389,22 @@ func (pastaClient *PastaClient) Help(session *discordgo.Session, channelID strin
 				&discordgo.MessageEmbedField{
 					Name: "get",
 					Value: "Retrieves a copypasta by alias and posts it. Alias can by any alphanumeric string with no whitespace.\n" +
-						"Usage: ```~pasta get $alias```",
+						"Usage: ```" + CommandPrefix + "pasta get $alias```",
 				},
 				&discordgo.MessageEmbedField{
 					Name: "save",
 					Value: "Saves a new a copypasta by alias. Alias can by any alphanumeric string with no whitespace.\n" +
-						"Usage: ```~pasta save $alias $copypasta_text```",
+						"Usage: ```" + CommandPrefix + "pasta save $alias $copypasta_text```",
 				},
 				&discordgo.MessageEmbedField{
 					Name: "edit",
 					Value: "Updates an existing copypasta by alias. The copypasta must exist and by authored by the caller for this to succeed.\n" +
-						"Usage: ```~pasta save $alias $updated_copypasta_text```",
+						"Usage: ```" + CommandPrefix + "pasta save $alias $updated_copypasta_text```",
 				},
 				&discordgo.MessageEmbedField{
 					Name: "list",
 					Value: "Retrieves a paginated list of all the copypastas saved in the server and DMs them to the caller.\n" +
-						"Usage: ```~pasta list```",
+						"Usage: ```" + CommandPrefix + "pasta list```",
 				},
 				&discordgo.MessageEmbedField{
 					Name:  "help",
@@ -0,0 +1,400 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/assets"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// purgeScope is the settings scope of scheduled purges, keyed by guild with the unix time they are due
+	purgeScope = "purge"
+	// forgetSetting records when a user asked to be forgotten, so the request can be confirmed
+	forgetSetting = "forget!requested"
+	// forgetConfirmWindow is how long a user has to confirm a request to be forgotten
+	forgetConfirmWindow = 5 * time.Minute
+	// forgetStarted prefixes the forget setting once a request is confirmed, followed by whether authored items are deleted
+	forgetStarted = "started!"
+	// forgetTimeout bounds how long forgetting a user may take, it runs apart from the command that confirmed it
+	forgetTimeout = 30 * time.Minute
+	// purgeTimeout bounds how long purging a single guild may take before it is retried on the next run
+	purgeTimeout = 10 * time.Minute
+)
+
+// SchedulePurge schedules a guild's data to be deleted once grace has passed
+func (archiveClient *ArchiveClient) SchedulePurge(ctx context.Context, guildID string, grace time.Duration) error {
+	due := time.Now().Add(grace).Unix()
+	return archiveClient.SettingsClient.SetSetting(ctx, purgeScope, guildID, strconv.FormatInt(due, 10))
+}
+
+// CancelPurge cancels a guild's scheduled purge, if any
+func (archiveClient *ArchiveClient) CancelPurge(ctx context.Context, guildID string) error {
+	return archiveClient.SettingsClient.DeleteSetting(ctx, purgeScope, guildID)
+}
+
+// PurgeEvery purges the guilds whose purges are due on an interval until done is closed
+func (archiveClient *ArchiveClient) PurgeEvery(session *discordgo.Session, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			archiveClient.PurgeDue(session)
+		case <-done:
+			return
+		}
+	}
+}
+
+// PurgeDue purges every guild whose purge is due
+// a guild Flamingo is still a member of is never purged, its purge is cancelled instead
+func (archiveClient *ArchiveClient) PurgeDue(session *discordgo.Session) {
+	ctx, cancel := context.WithTimeout(context.Background(), purgeTimeout)
+	scheduled, err := archiveClient.SettingsClient.ListSettings(ctx, purgeScope, "")
+	cancel()
+	if err != nil {
+		archiveClient.ArchiveErrorLogger.Println("An error occured while listing scheduled purges, they will be retried on the next run")
+		archiveClient.ArchiveErrorLogger.Println(err)
+		return
+	}
+	now := time.Now().Unix()
+	for guildID, value := range scheduled {
+		due, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || due > now {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), purgeTimeout)
+		err = archiveClient.purgeIfLeft(ctx, session, guildID)
+		cancel()
+		if err != nil {
+			archiveClient.ArchiveErrorLogger.Printf("An error occured while purging %s, it will be retried on the next run\n", guildID)
+			archiveClient.ArchiveErrorLogger.Println(err)
+		}
+	}
+}
+
+// purgeIfLeft purges a guild, provided Discord confirms Flamingo is no longer a member of it
+// the state cache can be missing guilds that are unavailable, so membership is checked with Discord directly
+func (archiveClient *ArchiveClient) purgeIfLeft(ctx context.Context, session *discordgo.Session, guildID string) error {
+	_, err := session.Guild(guildID)
+	if err == nil {
+		archiveClient.ArchiveServiceLogger.Printf("Still a member of %s, cancelling its purge\n", guildID)
+		return archiveClient.CancelPurge(ctx, guildID)
+	}
+	restErr, ok := err.(*discordgo.RESTError)
+	if !ok || restErr.Response == nil ||
+		(restErr.Response.StatusCode != http.StatusForbidden && restErr.Response.StatusCode != http.StatusNotFound) {
+		return err
+	}
+	summary, err := archiveClient.PurgeGuild(ctx, guildID)
+	if err != nil {
+		return err
+	}
+	//The guild's own audit entries were purged with the rest, so the purge is recorded globally
+	archiveClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  AuditGlobal,
+		Actor:  AuditSystem,
+		Action: "flamingo purge",
+		Target: guildID,
+		After:  summary,
+	})
+	return archiveClient.CancelPurge(ctx, guildID)
+}
+
+// PurgeGuild deletes every item a guild has saved, returning a summary of what was deleted
+func (archiveClient *ArchiveClient) PurgeGuild(ctx context.Context, guildID string) (string, error) {
+	archiveClient.ArchiveServiceLogger.Printf("Purging %s\n", guildID)
+	//Everything a guild has saved is exactly what it would export
+	archive, err := archiveClient.BuildArchive(ctx, guildID)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		archiveClient.PastaClient.Cache.InvalidatePrefix(guildID + "!")
+		archiveClient.TemplateClient.Cache.InvalidatePrefix(guildID + "!")
+		archiveClient.AuthClient.ForgetGuild(guildID)
+		archiveClient.DispatchClient.Forget(guildID)
+	}()
+	for _, pasta := range archive.Pastas {
+		if err = archiveClient.deleteItem(ctx, assets.PastaTableName, buildPastaKey(guildID, pasta.Alias)); err != nil {
+			return "", err
+		}
+	}
+	for _, template := range archive.Templates {
+		if err = archiveClient.deleteItem(ctx, assets.PastaTableName, buildTemplateKey(guildID, template.Alias)); err != nil {
+			return "", err
+		}
+	}
+	for _, strike := range archive.Strikes {
+		key, _ := dynamodbattribute.MarshalMap(StrikeKey{ID: strike.ID})
+		if err = archiveClient.deleteItem(ctx, assets.StrikeTableName, key); err != nil {
+			return "", err
+		}
+	}
+	for _, permission := range archive.Permissions {
+		key, _ := dynamodbattribute.MarshalMap(PermissionKey{Guild: permission.Guild, Permission: permission.Permission})
+		if err = archiveClient.deleteItem(ctx, assets.AuthTableName, key); err != nil {
+			return "", err
+		}
+	}
+	for _, promotion := range archive.Promotions {
+		if err = archiveClient.deleteItem(ctx, assets.PromotionTableName, buildPromotionKey(guildID, promotion.ID)); err != nil {
+			return "", err
+		}
+	}
+	audited, err := archiveClient.AuditClient.DeleteGuild(ctx, guildID)
+	if err != nil {
+		return "", err
+	}
+	//Settings go last, the bootstrap state with them, so the guild is bootstrapped from scratch if Flamingo is added back
+	for _, setting := range archive.Settings {
+		if err = archiveClient.SettingsClient.DeleteSetting(ctx, setting.Scope, setting.Setting); err != nil {
+			return "", err
+		}
+	}
+	if err = archiveClient.SettingsClient.DeleteSetting(ctx, GuildScope(guildID), bootstrapSetting); err != nil {
+		return "", err
+	}
+	archiveClient.MetricsClient.Count(archiveServiceName, "Purge", nil)
+	return fmt.Sprintf("Deleted %d pastas, %d templates, %d strikes, %d permission rules, %d settings, %d promotions and %d audit entries.",
+		len(archive.Pastas), len(archive.Templates), len(archive.Strikes), len(archive.Permissions), len(archive.Settings), len(archive.Promotions), audited), nil
+}
+
+// handleForget asks a user to confirm they want to be forgotten, or forgets them once they have
+func (archiveClient *ArchiveClient) handleForget(ctx context.Context, session *discordgo.Session, message *discordgo.Message, args []string) {
+	if len(args) < 2 || args[1] != "confirm" {
+		result, err := archiveClient.RequestForget(ctx, message.Author.ID)
+		ParseServiceResponse(session, message.ChannelID, result, err)
+		return
+	}
+	userID := message.Author.ID
+	requested, ok, err := archiveClient.SettingsClient.GetSetting(ctx, UserScope(userID), forgetSetting)
+	if err != nil {
+		ParseServiceResponse(session, message.ChannelID, "", err)
+		return
+	}
+	deleteAuthored := len(args) > 2 && args[2] == "delete"
+	//A confirmed request that was interrupted resumes as it was confirmed, however long ago that was
+	if strings.HasPrefix(requested, forgetStarted) {
+		deleteAuthored, _ = strconv.ParseBool(strings.TrimPrefix(requested, forgetStarted))
+	} else {
+		requestedAt, _ := strconv.ParseInt(requested, 10, 64)
+		if !ok || time.Since(time.Unix(requestedAt, 0)) > forgetConfirmWindow {
+			ParseServiceResponse(session, message.ChannelID, "Please send ```"+CommandPrefix+"flamingo forget-me``` first.", nil)
+			return
+		}
+		err = archiveClient.SettingsClient.SetSetting(ctx, UserScope(userID), forgetSetting, forgetStarted+strconv.FormatBool(deleteAuthored))
+		if err != nil {
+			ParseServiceResponse(session, message.ChannelID, "", err)
+			return
+		}
+	}
+	if _, running := archiveClient.forgetting.LoadOrStore(userID, true); running {
+		ParseServiceResponse(session, message.ChannelID, "<@"+userID+"> you are already being forgotten.", nil)
+		return
+	}
+	ParseServiceResponse(session, message.ChannelID, "<@"+userID+"> forgetting you, this may take a while. I'll let you know once it's done.", nil)
+	//Forgetting scans whole tables, so it gets its own deadline rather than the command's
+	go func() {
+		forgetCtx, cancel := context.WithTimeout(context.Background(), forgetTimeout)
+		defer cancel()
+		defer archiveClient.forgetting.Delete(userID)
+		result, err := archiveClient.ForgetUser(forgetCtx, userID, deleteAuthored)
+		if err != nil {
+			archiveClient.ArchiveErrorLogger.Printf("Forgetting %s failed: %s\n", userID, err)
+			result, err = "<@"+userID+"> forgetting you was interrupted, send ```"+CommandPrefix+"flamingo forget-me confirm``` to resume.", nil
+		}
+		ParseServiceResponse(session, message.ChannelID, result, err)
+	}()
+}
+
+// RequestForget records that a user asked to be forgotten and explains how to confirm
+func (archiveClient *ArchiveClient) RequestForget(ctx context.Context, userID string) (string, error) {
+	requested, _, err := archiveClient.SettingsClient.GetSetting(ctx, UserScope(userID), forgetSetting)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(requested, forgetStarted) {
+		return "<@" + userID + "> you already confirmed, send ```" + CommandPrefix + "flamingo forget-me confirm``` to resume.", nil
+	}
+	err = archiveClient.SettingsClient.SetSetting(ctx, UserScope(userID), forgetSetting, strconv.FormatInt(time.Now().Unix(), 10))
+	if err != nil {
+		return "", err
+	}
+	return "<@" + userID + "> this will permanently delete your reactions, your strikes and your settings in every server. " +
+		"Your pastas and templates will be given to the owners of the servers they were saved in.\n" +
+		"To confirm, send ```" + CommandPrefix + "flamingo forget-me confirm``` within 5 minutes. " +
+		"To delete your pastas and templates as well, send ```" + CommandPrefix + "flamingo forget-me confirm delete```", nil
+}
+
+// ForgetUser deletes a user's reactions, strikes and settings in every guild
+// their pastas and templates are deleted if deleteAuthored is set, otherwise they are given to the owner of their guild
+// the request must already be confirmed, every step can be repeated so an interrupted run is resumed by running it again
+func (archiveClient *ArchiveClient) ForgetUser(ctx context.Context, userID string, deleteAuthored bool) (string, error) {
+	//Settings are listed before anything is deleted so a failure here leaves the user's data untouched
+	settings, err := archiveClient.SettingsClient.ListSettings(ctx, UserScope(userID), "")
+	if err != nil {
+		return "", err
+	}
+	archiveClient.ArchiveServiceLogger.Printf("Forgetting %s\n", userID)
+
+	reactions, err := archiveClient.ReactClient.DeleteAllReactions(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	//Pending requests to promote the user's reactions can no longer be approved, reviewed promotions belong to the guild
+	promotions := 0
+	err = archiveClient.scanItems(ctx, assets.PromotionTableName, "#o=:u and #s=:p",
+		map[string]*string{"#o": aws.String("owner"), "#s": aws.String("status")},
+		map[string]*dynamodb.AttributeValue{
+			":u": &dynamodb.AttributeValue{S: aws.String(userID)},
+			":p": &dynamodb.AttributeValue{S: aws.String(promotionPending)},
+		},
+		func(item map[string]*dynamodb.AttributeValue) error {
+			promotion := Promotion{}
+			dynamodbattribute.UnmarshalMap(item, &promotion)
+			promotions++
+			return archiveClient.deleteItem(ctx, assets.PromotionTableName, buildPromotionKey(promotion.Guild, promotion.ID))
+		})
+	if err != nil {
+		return "", err
+	}
+	//Strikes are keyed by guild and user together, so the user's are found by their suffix
+	strikes := 0
+	err = archiveClient.scanItems(ctx, assets.StrikeTableName, "contains(#i, :u)",
+		map[string]*string{"#i": aws.String("guild!user")},
+		map[string]*dynamodb.AttributeValue{":u": &dynamodb.AttributeValue{S: aws.String("!" + userID)}},
+		func(item map[string]*dynamodb.AttributeValue) error {
+			strike := Strike{}
+			dynamodbattribute.UnmarshalMap(item, &strike)
+			if !strings.HasSuffix(strike.ID, "!"+userID) {
+				return nil
+			}
+			strikes++
+			key, _ := dynamodbattribute.MarshalMap(StrikeKey{ID: strike.ID})
+			return archiveClient.deleteItem(ctx, assets.StrikeTableName, key)
+		})
+	if err != nil {
+		return "", err
+	}
+	authored, reassigned := 0, 0
+	err = archiveClient.scanItems(ctx, assets.PastaTableName, "#o=:u",
+		map[string]*string{"#o": aws.String("owner")},
+		map[string]*dynamodb.AttributeValue{":u": &dynamodb.AttributeValue{S: aws.String(userID)}},
+		func(item map[string]*dynamodb.AttributeValue) error {
+			pasta := Pasta{}
+			dynamodbattribute.UnmarshalMap(item, &pasta)
+			authored++
+			//Templates are saved under their guild's ID suffixed with T
+			guildID := pasta.Guild
+			if _, ok := item["template"]; ok {
+				guildID = strings.TrimSuffix(guildID, "T")
+			}
+			//Pastas in guilds Flamingo has left are deleted, those guilds are due to be purged anyway
+			if !deleteAuthored {
+				if guild, err := archiveClient.Shards.Guild(guildID); err == nil && guild.OwnerID != userID {
+					reassigned++
+					return archiveClient.reassignItem(ctx, pasta.Guild, pasta.Alias, userID, guild.OwnerID)
+				}
+			}
+			archiveClient.PastaClient.Cache.Invalidate(guildID + "!" + pasta.Alias)
+			archiveClient.TemplateClient.Cache.Invalidate(guildID + "!" + pasta.Alias)
+			return archiveClient.deleteItem(ctx, assets.PastaTableName, buildPastaKey(pasta.Guild, pasta.Alias))
+		})
+	if err != nil {
+		return "", err
+	}
+	//The confirmation is a user setting too, it goes last so an interrupted run can still be resumed
+	for setting := range settings {
+		if setting == forgetSetting {
+			continue
+		}
+		if err = archiveClient.SettingsClient.DeleteSetting(ctx, UserScope(userID), setting); err != nil {
+			return "", err
+		}
+	}
+	if err = archiveClient.SettingsClient.DeleteSetting(ctx, UserScope(userID), forgetSetting); err != nil {
+		return "", err
+	}
+
+	summary := fmt.Sprintf("Deleted %d reactions, %d pending promotions, %d strikes, %d settings and %d pastas and templates, gave %d pastas and templates to server owners.",
+		reactions, promotions, strikes, len(settings), authored-reassigned, reassigned)
+	//The entry outlives the user's data by design, it is the record that they asked to be forgotten
+	archiveClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  AuditGlobal,
+		Actor:  userID,
+		Action: "flamingo forget-me",
+		Target: "<@" + userID + ">",
+		After:  summary,
+	})
+	archiveClient.MetricsClient.Count(archiveServiceName, "ForgetMe", nil)
+	return "<@" + userID + "> you have been forgotten. " + summary, nil
+}
+
+// reassignItem gives a pasta or template to a new owner, provided it still belongs to its previous owner
+// hashKey is the guild the item is saved under, including the suffix of templates
+func (archiveClient *ArchiveClient) reassignItem(ctx context.Context, hashKey, alias, from, to string) error {
+	_, err := archiveClient.DynamoClient.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(assets.PastaTableName),
+		Key:                 buildPastaKey(hashKey, alias),
+		ConditionExpression: aws.String("#o=:f"),
+		ExpressionAttributeNames: map[string]*string{
+			"#o": aws.String("owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":f": &dynamodb.AttributeValue{S: aws.String(from)},
+			":t": &dynamodb.AttributeValue{S: aws.String(to)},
+		},
+		UpdateExpression: aws.String("SET #o=:t"),
+	})
+	if err != nil {
+		archiveClient.ArchiveErrorLogger.Println(err)
+	}
+	return err
+}
+
+// scanItems calls handle with every item in a table matching filter, stopping at the first error
+func (archiveClient *ArchiveClient) scanItems(ctx context.Context, table, filter string, names map[string]*string, values map[string]*dynamodb.AttributeValue, handle func(map[string]*dynamodb.AttributeValue) error) error {
+	var handleErr error
+	err := archiveClient.DynamoClient.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:                 aws.String(table),
+		FilterExpression:          aws.String(filter),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	},
+		func(page *dynamodb.ScanOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				if handleErr = handle(item); handleErr != nil {
+					return false
+				}
+			}
+			return !lastPage
+		})
+	if err == nil {
+		err = handleErr
+	}
+	if err != nil {
+		archiveClient.ArchiveErrorLogger.Println(err)
+	}
+	return err
+}
+
+func (archiveClient *ArchiveClient) deleteItem(ctx context.Context, table string, key map[string]*dynamodb.AttributeValue) error {
+	_, err := archiveClient.DynamoClient.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(table),
+		Key:       key,
+	})
+	if err != nil {
+		archiveClient.ArchiveErrorLogger.Println(err)
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/flamingolog"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	rateLimitServiceName = "RateLimit"
+	// ThrottledEmoji is reacted to commands that are rate limited instead of executing them
+	ThrottledEmoji = "⏰"
+	// idle buckets are swept at most this often
+	bucketSweepInterval = time.Minute
+)
+
+// RateLimit allows bursts of up to Burst commands, refilling one every Every
+// a zero Burst disables rate limiting
+type RateLimit struct {
+	Burst int
+	Every time.Duration
+}
+
+// RateLimiter enforces cooldowns on commands with a token bucket per guild, user and command
+// commands and actions with their own limit get their own bucket, the rest share their command's bucket
+type RateLimiter struct {
+	MetricsClient          *flamingolog.FlamingoMetricsClient
+	RateLimitServiceLogger *log.Logger
+	RateLimitErrorLogger   *log.Logger
+	// Default applies to commands without a limit of their own
+	Default RateLimit
+	// Limits is keyed by command, or command and action separated by a space
+	Limits map[string]RateLimit
+	// ExemptRoles are the IDs of roles that are never rate limited
+	ExemptRoles []string
+	buckets     map[string]*tokenBucket
+	lastSweep   time.Time
+	lock        sync.Mutex
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limit   RateLimit
+}
+
+// NewRateLimiter constructs a RateLimiter
+func NewRateLimiter(metricsClient *flamingolog.FlamingoMetricsClient, defaultLimit RateLimit, limits map[string]RateLimit, exemptRoles []string) *RateLimiter {
+	return &RateLimiter{
+		MetricsClient:          metricsClient,
+		RateLimitServiceLogger: flamingolog.BuildServiceLogger(rateLimitServiceName),
+		RateLimitErrorLogger:   flamingolog.BuildServiceErrorLogger(rateLimitServiceName),
+		Default:                defaultLimit,
+		Limits:                 limits,
+		ExemptRoles:            exemptRoles,
+		buckets:                make(map[string]*tokenBucket),
+		lastSweep:              time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket for a user's command
+// returns false if the bucket is empty and the command should be throttled
+func (rateLimiter *RateLimiter) Allow(guildID, userID, command, action string) bool {
+	limitKey, limit := rateLimiter.limitFor(command, action)
+	if limit.Burst < 1 {
+		return true
+	}
+	now := time.Now()
+	key := guildID + "!" + userID + "!" + limitKey
+	if !rateLimiter.take(key, limit, now) {
+		rateLimiter.MetricsClient.PutMetric(rateLimitServiceName, "Throttled", 1, "Count", map[string]string{"Command": command})
+		return false
+	}
+	return true
+}
+
+// take removes a token from the bucket at key, returning false if it is empty
+func (rateLimiter *RateLimiter) take(key string, limit RateLimit, now time.Time) bool {
+	rateLimiter.lock.Lock()
+	defer rateLimiter.lock.Unlock()
+	rateLimiter.sweep(now)
+	bucket, ok := rateLimiter.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		rateLimiter.buckets[key] = bucket
+	}
+	bucket.refill(now)
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// Exempt checks if the author of a message is never rate limited
+// server administrators and members with an exempt role are exempt
+func (rateLimiter *RateLimiter) Exempt(session *discordgo.Session, message *discordgo.Message) bool {
+	if message.Member != nil {
+		for _, role := range message.Member.Roles {
+			if containsString(rateLimiter.ExemptRoles, role) {
+				return true
+			}
+		}
+	}
+	return message.GuildID != "" && HasDiscordPermission(session, message.Author.ID, message.ChannelID, discordgo.PermissionAdministrator)
+}
+
+// limitFor resolves the most specific limit for a command and action
+func (rateLimiter *RateLimiter) limitFor(command, action string) (string, RateLimit) {
+	if action != "" {
+		if limit, ok := rateLimiter.Limits[command+" "+action]; ok {
+			return command + " " + action, limit
+		}
+	}
+	if limit, ok := rateLimiter.Limits[command]; ok {
+		return command, limit
+	}
+	return command, rateLimiter.Default
+}
+
+// sweep forgets buckets that have refilled, they would be recreated full anyway
+func (rateLimiter *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rateLimiter.lastSweep) < bucketSweepInterval {
+		return
+	}
+	rateLimiter.lastSweep = now
+	for key, bucket := range rateLimiter.buckets {
+		bucket.refill(now)
+		if bucket.tokens >= float64(bucket.limit.Burst) {
+			delete(rateLimiter.buckets, key)
+		}
+	}
+}
+
+func (bucket *tokenBucket) refill(now time.Time) {
+	if bucket.limit.Every > 0 {
+		bucket.tokens += float64(now.Sub(bucket.updated)) / float64(bucket.limit.Every)
+	}
+	if bucket.tokens > float64(bucket.limit.Burst) {
+		bucket.tokens = float64(bucket.limit.Burst)
+	}
+	bucket.updated = now
+}
@@ -0,0 +1,59 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/flamingolog"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	rateLimiter := NewRateLimiter(&flamingolog.FlamingoMetricsClient{Local: true}, RateLimit{}, nil, nil)
+	limit := RateLimit{Burst: 2, Every: 10 * time.Second}
+	now := time.Now()
+	for i := 0; i < limit.Burst; i++ {
+		if !rateLimiter.take("key", limit, now) {
+			t.Fatalf("expected take %d of a full bucket to be allowed", i+1)
+		}
+	}
+	if rateLimiter.take("key", limit, now) {
+		t.Fatal("expected an empty bucket to be throttled")
+	}
+	if !rateLimiter.take("other", limit, now) {
+		t.Fatal("expected another key to have its own bucket")
+	}
+	if rateLimiter.take("key", limit, now.Add(5*time.Second)) {
+		t.Fatal("expected half a token not to be enough")
+	}
+	if !rateLimiter.take("key", limit, now.Add(10*time.Second)) {
+		t.Fatal("expected a refilled token to be allowed")
+	}
+	if rateLimiter.take("key", limit, now.Add(10*time.Second)) {
+		t.Fatal("expected the refilled token to be spent")
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		tokens      float64
+		limit       RateLimit
+		elapsed     time.Duration
+		tokensAfter float64
+	}{
+		{name: "partial", tokens: 0, limit: RateLimit{Burst: 3, Every: 10 * time.Second}, elapsed: 5 * time.Second, tokensAfter: 0.5},
+		{name: "several", tokens: 0.5, limit: RateLimit{Burst: 3, Every: 10 * time.Second}, elapsed: 20 * time.Second, tokensAfter: 2.5},
+		{name: "capped at burst", tokens: 1, limit: RateLimit{Burst: 3, Every: 10 * time.Second}, elapsed: time.Hour, tokensAfter: 3},
+		{name: "never refilled", tokens: 0, limit: RateLimit{Burst: 3}, elapsed: time.Hour, tokensAfter: 0},
+	}
+	for _, test := range tests {
+		bucket := &tokenBucket{tokens: test.tokens, updated: now, limit: test.limit}
+		bucket.refill(now.Add(test.elapsed))
+		if bucket.tokens != test.tokensAfter {
+			t.Errorf("%s: expected %v tokens, got %v", test.name, test.tokensAfter, bucket.tokens)
+		}
+		if !bucket.updated.Equal(now.Add(test.elapsed)) {
+			t.Errorf("%s: expected the bucket to be updated", test.name)
+		}
+	}
+}
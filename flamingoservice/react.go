@@ -1,21 +1,20 @@
 package flamingoservice
 
 import (
-	"FlamingoV2/flamingolog"
 	"FlamingoV2/assets"
+	"FlamingoV2/flamingolog"
 	"bytes"
-	"image"
+	"context"
+	"fmt"
 	"image/png"
 	"log"
-	"net/http"
 	"strings"
 
-
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/bwmarrin/discordgo"
-	"github.com/nfnt/resize"
 )
 
 const (
@@ -26,18 +25,26 @@ const (
 // ReactClient is responsible for handling "react" commands
 type ReactClient struct {
 	S3Client           *s3.S3
+	DynamoClient       *dynamodb.DynamoDB
 	MetricsClient      *flamingolog.FlamingoMetricsClient
 	AuthClient         *AuthClient
+	SettingsClient     *SettingsClient
+	AuditClient        *AuditClient
+	ImageFetcher       *ImageFetcher
 	ReactServiceLogger *log.Logger
 	ReactErrorLogger   *log.Logger
 }
 
 // NewReactClient constructs a ReactClient
-func NewReactClient(s3Client *s3.S3, metricsClient *flamingolog.FlamingoMetricsClient, authClient *AuthClient) *ReactClient {
+func NewReactClient(s3Client *s3.S3, dynamoClient *dynamodb.DynamoDB, metricsClient *flamingolog.FlamingoMetricsClient, authClient *AuthClient, settingsClient *SettingsClient, auditClient *AuditClient) *ReactClient {
 	return &ReactClient{
 		S3Client:           s3Client,
+		DynamoClient:       dynamoClient,
 		MetricsClient:      metricsClient,
 		AuthClient:         authClient,
+		SettingsClient:     settingsClient,
+		AuditClient:        auditClient,
+		ImageFetcher:       NewImageFetcher(),
 		ReactServiceLogger: flamingolog.BuildServiceLogger(strikeServiceName),
 		ReactErrorLogger:   flamingolog.BuildServiceErrorLogger(strikeServiceName),
 	}
@@ -49,7 +56,7 @@ func (reactClient *ReactClient) IsCommand(message string) bool {
 }
 
 // Handle parses a command message and performs the commanded action
-func (reactClient *ReactClient) Handle(session *discordgo.Session, message *discordgo.Message) {
+func (reactClient *ReactClient) Handle(ctx context.Context, session *discordgo.Session, message *discordgo.Message) {
 	//first word is always "react", safe to remove
 	args := strings.Fields(message.Content)[1:]
 	if len(args) < 1 {
@@ -60,23 +67,60 @@ func (reactClient *ReactClient) Handle(session *discordgo.Session, message *disc
 	switch args[0] {
 	case "get":
 		if len(args) < 2 {
-			session.ChannelMessageSend(message.ChannelID, "Please specify an alias.")
+			session.ChannelMessageSend(message.ChannelID, "Please specify an alias or tag.")
 			return
 		}
-		if reactClient.AuthClient.Authorize(message.GuildID, message.Author.ID, reactCommand, "get") {
-			reaction, err := reactClient.GetReaction(message.ChannelID, message.Author.ID, args[1])
+		if reactClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, reactCommand, "get") {
+			reaction, err := reactClient.GetReaction(ctx, message.ChannelID, message.Author.ID, args[1])
 			ParseServiceResponse(session, message.ChannelID, reaction, err)
 		} else {
 			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
 		}
 	case "save":
-		if len(args) < 2 || len(message.Attachments) < 1 {
-			session.ChannelMessageSend(message.ChannelID, "Please upload an image or specify an alias.")
+		aliases, links, err := parseReactionSaveArgs(message.Content)
+		if err != nil {
+			ParseServiceResponse(session, message.ChannelID, nil, err)
 			return
 		}
-		if reactClient.AuthClient.Authorize(message.GuildID, message.Author.ID, reactCommand, "save") {
-			_, err := reactClient.PutReaction(message.ChannelID, message.Author.ID, args[1], message.Attachments[0].URL)
-			ParseServiceResponse(session, message.ChannelID, "Reaction with alias "+args[1]+" saved.", err)
+		if len(aliases) < 1 {
+			session.ChannelMessageSend(message.ChannelID, "Please specify an alias.")
+			return
+		}
+		if reactClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, reactCommand, "save") {
+			transform, err := parseReactionTransform(message.Content)
+			if err != nil {
+				ParseServiceResponse(session, message.ChannelID, nil, err)
+				return
+			}
+			sources, err := resolveReactionSources(session, message, links)
+			if err != nil {
+				ParseServiceResponse(session, message.ChannelID, nil, err)
+				return
+			}
+			switch {
+			case len(sources) < 1:
+				session.ChannelMessageSend(message.ChannelID, "Please upload an image, link one or reply to a message with one.")
+				return
+			case len(aliases) == 1:
+				sources = sources[:1]
+			case len(aliases) != len(sources):
+				session.ChannelMessageSend(message.ChannelID,
+					fmt.Sprintf("Found %d images for %d aliases. Please specify one alias per image.", len(sources), len(aliases)))
+				return
+			}
+			size, err := reactClient.GetReactionSize(ctx, message.GuildID, message.Author.ID)
+			if err != nil {
+				ParseServiceResponse(session, message.ChannelID, nil, err)
+				return
+			}
+			for i, alias := range aliases {
+				_, err = reactClient.PutReaction(ctx, message.ChannelID, message.Author.ID, alias, sources[i], size, transform)
+				ParseServiceResponse(session, message.ChannelID, "Reaction with alias "+alias+" saved.", err)
+				//The remaining reactions would time out too
+				if IsTimeout(err) {
+					return
+				}
+			}
 		} else {
 			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
 		}
@@ -85,14 +129,36 @@ func (reactClient *ReactClient) Handle(session *discordgo.Session, message *disc
 			session.ChannelMessageSend(message.ChannelID, "Please specify an alias.")
 			return
 		}
-		if reactClient.AuthClient.Authorize(message.GuildID, message.Author.ID, reactCommand, "delete") {
-			result, err := reactClient.DeleteReaction(message.ChannelID, message.Author.ID, args[1])
+		if reactClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, reactCommand, "delete") {
+			result, err := reactClient.DeleteReaction(ctx, message.GuildID, message.ChannelID, message.Author.ID, args[1])
 			ParseServiceResponse(session, message.ChannelID, result, err)
 		} else {
 			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
 		}
+	case "tag", "untag":
+		if len(args) < 3 {
+			session.ChannelMessageSend(message.ChannelID, "Please specify an alias and at least one tag.")
+			return
+		}
+		if reactClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, reactCommand, args[0]) {
+			result, err := reactClient.TagReaction(ctx, message.Author.ID, args[1], args[2:], args[0] == "untag")
+			ParseServiceResponse(session, message.ChannelID, result, err)
+		} else {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+		}
+	case "random":
+		if reactClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, reactCommand, "random") {
+			reaction, err := reactClient.RandomReaction(ctx, message.Author.ID)
+			ParseServiceResponse(session, message.ChannelID, reaction, err)
+		} else {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+		}
+	case "promote", "promotions", "approve", "reject":
+		reactClient.handlePromotion(ctx, session, message, args)
+	case "size":
+		reactClient.handleSize(ctx, session, message, args[1:])
 	case "list":
-		reactClient.ListReactions(session, message.ChannelID, message.Author.ID)
+		reactClient.ListReactions(ctx, session, message.ChannelID, message.Author.ID)
 	case "help":
 		reactClient.Help(session, message.ChannelID)
 	default:
@@ -100,37 +166,85 @@ func (reactClient *ReactClient) Handle(session *discordgo.Session, message *disc
 	}
 }
 
+func (reactClient *ReactClient) handleSize(ctx context.Context, session *discordgo.Session, message *discordgo.Message, args []string) {
+	if len(args) < 1 {
+		size, err := reactClient.GetReactionSize(ctx, message.GuildID, message.Author.ID)
+		if err != nil {
+			ParseServiceResponse(session, message.ChannelID, nil, err)
+			return
+		}
+		ParseServiceResponse(session, message.ChannelID,
+			fmt.Sprintf("Your reactions are saved at up to %dx%d.", size.MaxWidth, size.MaxHeight), nil)
+		return
+	}
+	scope := UserScope(message.Author.ID)
+	if args[0] == "guild" {
+		if len(args) < 2 {
+			session.ChannelMessageSend(message.ChannelID, "Please specify a size.")
+			return
+		}
+		if !reactClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, reactCommand, "size") ||
+			!HasDiscordPermission(session, message.Author.ID, message.ChannelID, discordgo.PermissionManageServer) {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+			return
+		}
+		scope = GuildScope(message.GuildID)
+		args = args[1:]
+	}
+	result, err := reactClient.SetReactionSize(ctx, scope, args[0])
+	ParseServiceResponse(session, message.ChannelID, result, err)
+}
+
+// GetReactionSize resolves the thumbnail size for a user's reactions
+// user presets take precedence over guild presets, which take precedence over the default
+func (reactClient *ReactClient) GetReactionSize(ctx context.Context, guildID, userID string) (ReactionSize, error) {
+	for _, scope := range []string{UserScope(userID), GuildScope(guildID)} {
+		preset, ok, err := reactClient.SettingsClient.GetSetting(ctx, scope, reactionSizeSetting)
+		if err != nil {
+			return ReactionSize{}, err
+		}
+		if size, valid := ReactionSizes[preset]; ok && valid {
+			return size, nil
+		}
+	}
+	return ReactionSizes[defaultReactionSize], nil
+}
+
+// SetReactionSize sets the thumbnail size preset for a guild or user scope
+// the preset "default" removes the setting
+func (reactClient *ReactClient) SetReactionSize(ctx context.Context, scope, preset string) (string, error) {
+	if preset == "default" {
+		err := reactClient.SettingsClient.DeleteSetting(ctx, scope, reactionSizeSetting)
+		return "Reaction size reset to default.", err
+	}
+	if _, ok := ReactionSizes[preset]; !ok {
+		return "Reaction size must be one of small, medium, large or default.", nil
+	}
+	err := reactClient.SettingsClient.SetSetting(ctx, scope, reactionSizeSetting, preset)
+	return "Reaction size set to " + preset + ".", err
+}
+
 // PutReaction saves an aspect-ratio preserved thumbnail of an image for later use
-func (reactClient *ReactClient) PutReaction(channelID, userID, alias, url string) (bool, error) {
-	response, err := http.Get(url)
+func (reactClient *ReactClient) PutReaction(ctx context.Context, channelID, userID, alias, url string, size ReactionSize, transform *ReactionTransform) (bool, error) {
+	image, err := reactClient.ImageFetcher.Fetch(ctx, url)
 	if err != nil {
 		reactClient.ReactErrorLogger.Println(err)
 		return false, err
 	}
-	defer response.Body.Close()
 
-	image, _, err := image.Decode(response.Body)
+	image, err = transformReaction(image, size, transform)
 	if err != nil {
 		reactClient.ReactErrorLogger.Println(err)
 		return false, err
 	}
 
-	x := float64(image.Bounds().Size().X)
-	y := float64(image.Bounds().Size().Y)
-
-	reszieRatio := 128.0 / x
-	dx := uint(x * reszieRatio)
-	dy := uint(y * reszieRatio)
-
-	image = resize.Resize(dx, dy, image, resize.Bicubic)
-
 	buffer := new(bytes.Buffer)
 	err = png.Encode(buffer, image)
 	if err != nil {
 		reactClient.ReactErrorLogger.Println(err)
 		return false, err
 	}
-	_, err = reactClient.S3Client.PutObject(&s3.PutObjectInput{
+	_, err = reactClient.S3Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
 		Bucket:        aws.String(assets.BucketName),
 		Key:           aws.String(buildReactionKey(userID, alias)),
 		Body:          bytes.NewReader(buffer.Bytes()),
@@ -143,38 +257,76 @@ func (reactClient *ReactClient) PutReaction(channelID, userID, alias, url string
 		reactClient.ReactErrorLogger.Println(err)
 		return false, err
 	}
+	err = reactClient.indexReaction(ctx, userID, alias)
+	if err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
 // GetReaction retrieves a reaction by alias and returns the url
-func (reactClient *ReactClient) GetReaction(channelID, userID, alias string) (string, error) {
-	//Dirty way to test if object exists w/o querying it directly (ACL is smaller than the image)
-	_, err := reactClient.S3Client.GetObjectAcl(&s3.GetObjectAclInput{
-		Bucket: aws.String(assets.BucketName),
-		Key:    aws.String(buildReactionKey(userID, alias)),
-	})
+// if no reaction has the alias, a random reaction tagged with it is returned instead
+func (reactClient *ReactClient) GetReaction(ctx context.Context, channelID, userID, alias string) (string, error) {
+	exists, err := reactClient.reactionExists(ctx, userID, alias)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case s3.ErrCodeNoSuchKey:
-				return "No reaction with alias " + alias + " exists.", nil
-			default:
-				reactClient.ReactErrorLogger.Println(err)
-				return "", err
-			}
-		} else {
-			reactClient.ReactErrorLogger.Println(err)
+		return "", err
+	}
+	if !exists {
+		tagged, err := reactClient.ListIndexedReactions(ctx, userID, alias)
+		if err != nil {
 			return "", err
 		}
+		reaction := pickReaction(tagged)
+		if reaction == nil {
+			return "No reaction with alias or tag " + alias + " exists.", nil
+		}
+		alias = reaction.Alias
 	}
 	//Discord unmarshalling gives better results than sending the file
 	return "mfw " + buildReactionURL(userID, alias), nil
 }
 
+// RandomReaction retrieves a random reaction from a user's library and returns the url
+func (reactClient *ReactClient) RandomReaction(ctx context.Context, userID string) (string, error) {
+	reactions, err := reactClient.ListIndexedReactions(ctx, userID, "")
+	if err != nil {
+		return "", err
+	}
+	reaction := pickReaction(reactions)
+	if reaction == nil {
+		return "You have no reactions saved.", nil
+	}
+	return "mfw " + buildReactionURL(userID, reaction.Alias), nil
+}
+
+// TagReaction adds or removes tags from a user's reaction
+func (reactClient *ReactClient) TagReaction(ctx context.Context, userID, alias string, tags []string, remove bool) (string, error) {
+	tags = uniqueTags(tags)
+	if len(tags) < 1 {
+		return "Please specify at least one tag.", nil
+	}
+	exists, err := reactClient.reactionExists(ctx, userID, alias)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "No reaction with alias " + alias + " exists.", nil
+	}
+	err = reactClient.updateReactionTags(ctx, userID, alias, tags, remove)
+	if err != nil {
+		return "", err
+	}
+	if remove {
+		return "Removed tags " + strings.Join(tags, ", ") + " from " + alias + ".", nil
+	}
+	return "Tagged " + alias + " with " + strings.Join(tags, ", ") + ".", nil
+}
+
 // DeleteReaction deletes a users reaction image by alias
-func (reactClient *ReactClient) DeleteReaction(channelID, userID, alias string) (string, error) {
+// the deletion is audited in the guild it was requested from
+func (reactClient *ReactClient) DeleteReaction(ctx context.Context, guildID, channelID, userID, alias string) (string, error) {
 	key := buildReactionKey(userID, alias)
-	_, err := reactClient.S3Client.DeleteObject(&s3.DeleteObjectInput{
+	_, err := reactClient.S3Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(assets.BucketName),
 		Key:    aws.String(key),
 	})
@@ -193,7 +345,7 @@ func (reactClient *ReactClient) DeleteReaction(channelID, userID, alias string)
 		}
 	}
 
-	err = reactClient.S3Client.WaitUntilObjectNotExists(&s3.HeadObjectInput{
+	err = reactClient.S3Client.WaitUntilObjectNotExistsWithContext(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(assets.BucketName),
 		Key:    aws.String(key),
 	})
@@ -201,18 +353,91 @@ func (reactClient *ReactClient) DeleteReaction(channelID, userID, alias string)
 		reactClient.ReactErrorLogger.Println(err)
 		return "", err
 	}
+	err = reactClient.unindexReaction(ctx, userID, alias)
+	if err != nil {
+		return "", err
+	}
+	reactClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  userID,
+		Action: "react delete",
+		Target: alias,
+		Before: buildReactionURL(userID, alias),
+	})
 	return "Reaction with alias " + alias + " deleted.", nil
 }
 
+// DeleteAllReactions deletes every reaction a user has saved, along with their index entries
+// returns the number of reaction images deleted
+func (reactClient *ReactClient) DeleteAllReactions(ctx context.Context, userID string) (int, error) {
+	deleted := 0
+	var deleteErr error
+	err := reactClient.S3Client.ListObjectsV2PagesWithContext(ctx,
+		&s3.ListObjectsV2Input{
+			Bucket: aws.String(assets.BucketName),
+			Prefix: aws.String(buildReactionKey(userID, "")),
+		},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			if len(page.Contents) < 1 {
+				return !lastPage
+			}
+			objects := make([]*s3.ObjectIdentifier, 0, len(page.Contents))
+			for _, v := range page.Contents {
+				objects = append(objects, &s3.ObjectIdentifier{Key: v.Key})
+			}
+			var result *s3.DeleteObjectsOutput
+			result, deleteErr = reactClient.S3Client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(assets.BucketName),
+				Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+			})
+			if deleteErr == nil && len(result.Errors) > 0 {
+				deleteErr = fmt.Errorf("could not delete %d reactions, the first failed with %s", len(result.Errors), aws.StringValue(result.Errors[0].Code))
+			}
+			if deleteErr != nil {
+				return false
+			}
+			deleted += len(objects)
+			return !lastPage
+		})
+	if err == nil {
+		err = deleteErr
+	}
+	if err != nil {
+		reactClient.ReactErrorLogger.Println(err)
+		return deleted, err
+	}
+	//The index can hold reactions whose images are already gone
+	indexed, err := reactClient.ListIndexedReactions(ctx, userID, "")
+	if err != nil {
+		return deleted, err
+	}
+	for _, reaction := range indexed {
+		if err = reactClient.unindexReaction(ctx, userID, reaction.Alias); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
 // ListReactions lists all reactions a user has saved via dm
-func (reactClient *ReactClient) ListReactions(session *discordgo.Session, channelID, userID string) {
+// reactions missing from the index are indexed as they are listed
+func (reactClient *ReactClient) ListReactions(ctx context.Context, session *discordgo.Session, channelID, userID string) {
 	dmChannel, err := session.UserChannelCreate(userID)
 	if err != nil {
 		session.ChannelMessageSend(channelID, "An error occurred. Could not DM <@"+userID+">.")
 		reactClient.ReactErrorLogger.Println(err)
 		return
 	}
-	err = reactClient.S3Client.ListObjectsV2Pages(
+	indexed, err := reactClient.ListIndexedReactions(ctx, userID, "")
+	if err != nil {
+		session.ChannelMessageSend(dmChannel.ID, "An error occured. Please try again later.")
+		return
+	}
+	aliasTagMap := make(map[string][]string)
+	for _, reaction := range indexed {
+		aliasTagMap[reaction.Alias] = reaction.Tags
+	}
+	err = reactClient.S3Client.ListObjectsV2PagesWithContext(ctx,
 		&s3.ListObjectsV2Input{
 			Bucket:  aws.String(assets.BucketName),
 			Prefix:  aws.String(buildReactionKey(userID, "")),
@@ -229,9 +454,17 @@ func (reactClient *ReactClient) ListReactions(session *discordgo.Session, channe
 			}
 			for _, v := range page.Contents {
 				alias := strings.Split(*v.Key, "/")[1]
+				tags, ok := aliasTagMap[alias]
+				if !ok {
+					reactClient.indexReaction(ctx, userID, alias)
+				}
+				value := assets.BucketURL + "/" + *v.Key
+				if len(tags) > 0 {
+					value += "\nTags: " + strings.Join(tags, ", ")
+				}
 				reactionList = append(reactionList, &discordgo.MessageEmbedField{
 					Name:   alias,
-					Value:  "https://s3.amazonaws.com/" + assets.BucketName + "/" + *v.Key,
+					Value:  value,
 					Inline: true,
 				})
 			}
@@ -264,23 +497,55 @@ func (reactClient *ReactClient) Help(session *discordgo.Session, channelID strin
 			Fields: []*discordgo.MessageEmbedField{
 				&discordgo.MessageEmbedField{
 					Name: "get",
-					Value: "Retrieves a reaction image by alias and posts it. Alias can by any alphanumeric string with no whitespace.\n" +
-						"Usage: ```~react get $alias```",
+					Value: "Retrieves a reaction image by alias and posts it. Alias can by any alphanumeric string with no whitespace. If no reaction has the alias, a random reaction with that tag is posted.\n" +
+						"Usage: ```" + CommandPrefix + "react get $alias_or_tag```",
+				},
+				&discordgo.MessageEmbedField{
+					Name: "random",
+					Value: "Posts a random reaction image from your reactions.\n" +
+						"Usage: ```" + CommandPrefix + "react random```",
+				},
+				&discordgo.MessageEmbedField{
+					Name: "tag",
+					Value: "Adds tags to a reaction so it can be retrieved by tag.\n" +
+						"Usage: ```" + CommandPrefix + "react tag $alias $tag1 $tag2 ...```",
+				},
+				&discordgo.MessageEmbedField{
+					Name: "untag",
+					Value: "Removes tags from a reaction.\n" +
+						"Usage: ```" + CommandPrefix + "react untag $alias $tag1 $tag2 ...```",
 				},
 				&discordgo.MessageEmbedField{
 					Name: "save",
-					Value: "Saves a new a reaction by alias. Reactions are images uploaded to Discord. They are thumbnailed and saved for later reacall. Alias can by any alphanumeric string with no whitespace. Can be used to overwrite an existing reaction.\n" +
-						"Usage: ```~react save $alias```",
+					Value: "Saves a new a reaction by alias. Reactions are images uploaded to Discord. They are thumbnailed and saved for later reacall. Alias can by any alphanumeric string with no whitespace. Can be used to overwrite an existing reaction. " +
+						"The image can be attached, linked, or found in a linked message or the message being replied to. Several attachments can be saved at once with one alias each. " +
+						"Optionally crops (in pixels), rotates clockwise, flips and captions the image before saving.\n" +
+						"Usage: ```" + CommandPrefix + "react save $alias1 *$alias2 ... *$link *crop=$x,$y,$width,$height *rotate=90|180|270 *flip=h|v|hv *top=\"text\" *bottom=\"text\"```",
 				},
 				&discordgo.MessageEmbedField{
 					Name: "delete",
 					Value: "Deletes a reaction image and makes it unavailable for use. Alias can by any alphanumeric string with no whitespace.\n" +
-						"Usage: ```~react delete $alias```",
+						"Usage: ```" + CommandPrefix + "react delete $alias```",
+				},
+				&discordgo.MessageEmbedField{
+					Name: "promote",
+					Value: "Submits one of your reactions to become a server emoji or sticker. An admin who can manage emojis must approve it. Name defaults to the alias.\n" +
+						"Usage: ```" + CommandPrefix + "react promote $alias emoji|sticker *$name```",
+				},
+				&discordgo.MessageEmbedField{
+					Name: "approve/reject",
+					Value: "Approves or rejects a submitted reaction. Approved reactions are resized to fit Discord's limits and uploaded. Requires permission to manage emojis.\n" +
+						"Usage: ```" + CommandPrefix + "react approve|reject @owner $alias emoji|sticker``` ```" + CommandPrefix + "react promotions```",
+				},
+				&discordgo.MessageEmbedField{
+					Name: "size",
+					Value: "Shows or sets the size reactions are thumbnailed to when saved. Images are never upscaled. Setting the guild size requires permission and the Discord permission to manage the server, and applies to users without their own size.\n" +
+						"Usage: ```" + CommandPrefix + "react size *guild small|medium|large|default```",
 				},
 				&discordgo.MessageEmbedField{
 					Name: "list",
 					Value: "Retrieves a list of all the reaction images saved and DMs them to the caller.\n" +
-						"Usage: ```~react list```",
+						"Usage: ```" + CommandPrefix + "react list```",
 				},
 				&discordgo.MessageEmbedField{
 					Name:  "help",
@@ -296,6 +561,6 @@ func buildReactionKey(userID, alias string) (key string) {
 }
 
 func buildReactionURL(userID, alias string) (s3url string) {
-	s3url = "https://s3.amazonaws.com/" + assets.BucketName + "/" + userID + "/" + alias
+	s3url = assets.BucketURL + "/" + buildReactionKey(userID, alias)
 	return
 }
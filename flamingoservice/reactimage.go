@@ -0,0 +1,264 @@
+package flamingoservice
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	reactionSizeSetting = "react!size"
+	defaultReactionSize = "medium"
+)
+
+var (
+	// ReactionSizes are the presets a guild or user may choose to bound reaction thumbnails
+	ReactionSizes = map[string]ReactionSize{
+		"small":  {MaxWidth: 64, MaxHeight: 64},
+		"medium": {MaxWidth: 128, MaxHeight: 128},
+		"large":  {MaxWidth: 256, MaxHeight: 256},
+	}
+
+	crop, _       = regexp.Compile(`crop=\d+,\d+,\d+,\d+`)
+	rotate, _     = regexp.Compile(`rotate=(90|180|270)\b`)
+	flip, _       = regexp.Compile(`flip=(h|v|hv|vh)\b`)
+	topText, _    = regexp.Compile(`top="[^"]*"`)
+	bottomText, _ = regexp.Compile(`bottom="[^"]*"`)
+
+	captionFont, _ = opentype.Parse(gobold.TTF)
+)
+
+// ReactionSize bounds the dimensions of a reaction thumbnail
+type ReactionSize struct {
+	MaxWidth  uint
+	MaxHeight uint
+}
+
+// ReactionTransform describes the optional edits applied to a reaction when it is saved
+type ReactionTransform struct {
+	Crop           *image.Rectangle
+	Rotate         int
+	FlipHorizontal bool
+	FlipVertical   bool
+	TopText        string
+	BottomText     string
+}
+
+// parseReactionTransform reads transform arguments out of a react save command
+func parseReactionTransform(content string) (*ReactionTransform, error) {
+	transform := &ReactionTransform{}
+	if cropArg := crop.FindString(content); cropArg != "" {
+		bounds := strings.Split(strings.Split(cropArg, "=")[1], ",")
+		values := make([]int, len(bounds))
+		for i, v := range bounds {
+			value, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, &UserError{Reason: "The crop must be given in pixels within the image."}
+			}
+			values[i] = value
+		}
+		if values[2] < 1 || values[3] < 1 {
+			return nil, &UserError{Reason: "The crop width and height must be positive."}
+		}
+		rect := image.Rect(values[0], values[1], values[0]+values[2], values[1]+values[3])
+		transform.Crop = &rect
+	}
+	if rotateArg := rotate.FindString(content); rotateArg != "" {
+		degrees, err := strconv.Atoi(strings.Split(rotateArg, "=")[1])
+		if err != nil {
+			return nil, &UserError{Reason: "Reactions can only be rotated by 90, 180 or 270 degrees."}
+		}
+		transform.Rotate = degrees
+	}
+	if flipArg := flip.FindString(content); flipArg != "" {
+		axes := strings.Split(flipArg, "=")[1]
+		transform.FlipHorizontal = strings.Contains(axes, "h")
+		transform.FlipVertical = strings.Contains(axes, "v")
+	}
+	if top := topText.FindString(content); top != "" {
+		top = strings.SplitN(top, "=", 2)[1]
+		transform.TopText = top[1 : len(top)-1]
+	}
+	if bottom := bottomText.FindString(content); bottom != "" {
+		bottom = strings.SplitN(bottom, "=", 2)[1]
+		transform.BottomText = bottom[1 : len(bottom)-1]
+	}
+	return transform, nil
+}
+
+// transformReaction applies crop, thumbnailing, rotation, flips and captions to an image, in that order
+// thumbnailing before rotating and flipping keeps the per-pixel work to the size of the thumbnail
+func transformReaction(img image.Image, size ReactionSize, transform *ReactionTransform) (image.Image, error) {
+	if transform.Crop != nil {
+		if !transform.Crop.In(img.Bounds().Sub(img.Bounds().Min)) {
+			return nil, &UserError{Reason: "The crop must be inside the image."}
+		}
+		cropped := image.NewNRGBA(image.Rect(0, 0, transform.Crop.Dx(), transform.Crop.Dy()))
+		draw.Draw(cropped, cropped.Bounds(), img, img.Bounds().Min.Add(transform.Crop.Min), draw.Src)
+		img = cropped
+	}
+	//Quarter turns swap the width and height, so the thumbnail is bounded by the size turned the same way
+	if transform.Rotate == 90 || transform.Rotate == 270 {
+		size = ReactionSize{MaxWidth: size.MaxHeight, MaxHeight: size.MaxWidth}
+	}
+	img = thumbnailImage(img, size)
+	if transform.Rotate != 0 {
+		img = rotateImage(img, transform.Rotate)
+	}
+	if transform.FlipHorizontal || transform.FlipVertical {
+		img = flipImage(img, transform.FlipHorizontal, transform.FlipVertical)
+	}
+	if transform.TopText != "" || transform.BottomText != "" {
+		return captionImage(img, transform.TopText, transform.BottomText)
+	}
+	return img, nil
+}
+
+// thumbnailImage scales an image down to fit within size, preserving aspect ratio
+// images already within bounds are never upscaled
+func thumbnailImage(img image.Image, size ReactionSize) image.Image {
+	x := float64(img.Bounds().Dx())
+	y := float64(img.Bounds().Dy())
+	resizeRatio := float64(size.MaxWidth) / x
+	if heightRatio := float64(size.MaxHeight) / y; heightRatio < resizeRatio {
+		resizeRatio = heightRatio
+	}
+	if resizeRatio >= 1 {
+		return img
+	}
+	dx := uint(x * resizeRatio)
+	dy := uint(y * resizeRatio)
+	if dx < 1 {
+		dx = 1
+	}
+	if dy < 1 {
+		dy = 1
+	}
+	return resize.Resize(dx, dy, img, resize.Bicubic)
+}
+
+// rotateImage rotates an image clockwise by 90, 180 or 270 degrees
+func rotateImage(img image.Image, degrees int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	var rotated *image.NRGBA
+	if degrees == 180 {
+		rotated = image.NewNRGBA(image.Rect(0, 0, w, h))
+	} else {
+		rotated = image.NewNRGBA(image.Rect(0, 0, h, w))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			pixel := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			switch degrees {
+			case 90:
+				rotated.Set(h-1-y, x, pixel)
+			case 180:
+				rotated.Set(w-1-x, h-1-y, pixel)
+			case 270:
+				rotated.Set(y, w-1-x, pixel)
+			}
+		}
+	}
+	return rotated
+}
+
+// flipImage mirrors an image across its vertical and/or horizontal axis
+func flipImage(img image.Image, horizontal, vertical bool) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	flipped := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x, y
+			if horizontal {
+				dx = w - 1 - x
+			}
+			if vertical {
+				dy = h - 1 - y
+			}
+			flipped.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return flipped
+}
+
+// captionImage renders top and bottom meme text onto an image with the bundled Go Bold font
+func captionImage(img image.Image, top, bottom string) (image.Image, error) {
+	if captionFont == nil {
+		return nil, errors.New("caption font failed to load")
+	}
+	captioned := image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(captioned, captioned.Bounds(), img, img.Bounds().Min, draw.Src)
+	if top != "" {
+		if err := drawCaption(captioned, strings.ToUpper(top), true); err != nil {
+			return nil, err
+		}
+	}
+	if bottom != "" {
+		if err := drawCaption(captioned, strings.ToUpper(bottom), false); err != nil {
+			return nil, err
+		}
+	}
+	return captioned, nil
+}
+
+// drawCaption draws a line of outlined text centered at the top or bottom of an image
+// the font shrinks until the text fits the width of the image
+func drawCaption(dst *image.NRGBA, text string, isTop bool) error {
+	width := dst.Bounds().Dx()
+	height := dst.Bounds().Dy()
+	outline := 1 + height/128
+	fontSize := float64(height) / 7
+	var face font.Face
+	for {
+		var err error
+		face, err = opentype.NewFace(captionFont, &opentype.FaceOptions{
+			Size:    fontSize,
+			DPI:     72,
+			Hinting: font.HintingFull,
+		})
+		if err != nil {
+			return err
+		}
+		if font.MeasureString(face, text).Ceil()+2*outline <= width || fontSize <= 6 {
+			break
+		}
+		face.Close()
+		fontSize--
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	textWidth := font.MeasureString(face, text).Ceil()
+	x := (width - textWidth) / 2
+	y := outline + metrics.Ascent.Ceil()
+	if !isTop {
+		y = height - outline - metrics.Descent.Ceil()
+	}
+	drawer := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(color.Black),
+		Face: face,
+	}
+	for dy := -outline; dy <= outline; dy++ {
+		for dx := -outline; dx <= outline; dx++ {
+			drawer.Dot = fixed.P(x+dx, y+dy)
+			drawer.DrawString(text)
+		}
+	}
+	drawer.Src = image.NewUniform(color.White)
+	drawer.Dot = fixed.P(x, y)
+	drawer.DrawString(text)
+	return nil
+}
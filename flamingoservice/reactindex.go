@@ -0,0 +1,180 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/assets"
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Reaction represents the schema of a reaction's metadata stored in DDB
+// the image itself is stored in S3 under the same owner and alias
+type Reaction struct {
+	Owner string   `dynamodbav:"owner"`
+	Alias string   `dynamodbav:"alias"`
+	Tags  []string `dynamodbav:"tags,stringset,omitempty"`
+	Saved int64    `dynamodbav:"saved"`
+}
+
+// URL is where the reaction's image is served from
+func (reaction *Reaction) URL() string {
+	return buildReactionURL(reaction.Owner, reaction.Alias)
+}
+
+// ReactionKey is a convenience struct for marshalling Go types into a key for DDB requests for a given reaction
+type ReactionKey struct {
+	Owner string `dynamodbav:"owner"`
+	Alias string `dynamodbav:"alias"`
+}
+
+// indexReaction records a reaction in the index, preserving its tags if it already exists
+func (reactClient *ReactClient) indexReaction(ctx context.Context, userID, alias string) error {
+	_, err := reactClient.DynamoClient.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(assets.ReactionTableName),
+		Key:              buildReactionIndexKey(userID, alias),
+		UpdateExpression: aws.String("SET saved=:s"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+		},
+	})
+	if err != nil {
+		reactClient.ReactErrorLogger.Println(err)
+	}
+	return err
+}
+
+// unindexReaction removes a reaction and its tags from the index
+func (reactClient *ReactClient) unindexReaction(ctx context.Context, userID, alias string) error {
+	_, err := reactClient.DynamoClient.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(assets.ReactionTableName),
+		Key:       buildReactionIndexKey(userID, alias),
+	})
+	if err != nil {
+		reactClient.ReactErrorLogger.Println(err)
+	}
+	return err
+}
+
+// reactionExists checks the index for a reaction
+// reactions saved before the index existed are found in S3 and indexed on first use
+func (reactClient *ReactClient) reactionExists(ctx context.Context, userID, alias string) (bool, error) {
+	result, err := reactClient.DynamoClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(assets.ReactionTableName),
+		Key:       buildReactionIndexKey(userID, alias),
+	})
+	if err != nil {
+		reactClient.ReactErrorLogger.Println(err)
+		return false, err
+	}
+	if _, ok := result.Item["alias"]; ok {
+		return true, nil
+	}
+	_, err = reactClient.S3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(assets.BucketName),
+		Key:    aws.String(buildReactionKey(userID, alias)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound") {
+			return false, nil
+		}
+		reactClient.ReactErrorLogger.Println(err)
+		return false, err
+	}
+	return true, reactClient.indexReaction(ctx, userID, alias)
+}
+
+// ListIndexedReactions retrieves the metadata of every reaction a user has indexed
+// if tag is not empty, only reactions with that tag are returned
+func (reactClient *ReactClient) ListIndexedReactions(ctx context.Context, userID, tag string) ([]*Reaction, error) {
+	query := &dynamodb.QueryInput{
+		TableName:              aws.String(assets.ReactionTableName),
+		KeyConditionExpression: aws.String("#o=:o"),
+		ExpressionAttributeNames: map[string]*string{
+			"#o": aws.String("owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":o": &dynamodb.AttributeValue{S: aws.String(userID)},
+		},
+	}
+	if tag != "" {
+		query.FilterExpression = aws.String("contains(tags, :t)")
+		query.ExpressionAttributeValues[":t"] = &dynamodb.AttributeValue{S: aws.String(tag)}
+	}
+	reactions := make([]*Reaction, 0, 30)
+	var unmarshalErr error
+	err := reactClient.DynamoClient.QueryPagesWithContext(ctx, query,
+		func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			pageReactions := make([]*Reaction, 0, len(page.Items))
+			unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageReactions)
+			reactions = append(reactions, pageReactions...)
+			return unmarshalErr == nil
+		})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		reactClient.ReactErrorLogger.Println(err)
+		return nil, err
+	}
+	return reactions, nil
+}
+
+// updateReactionTags adds or removes tags from an indexed reaction
+// DynamoDB rejects string sets with duplicate or empty values, so those are dropped first
+func (reactClient *ReactClient) updateReactionTags(ctx context.Context, userID, alias string, tags []string, remove bool) error {
+	tags = uniqueTags(tags)
+	if len(tags) < 1 {
+		return nil
+	}
+	updateExpression := "ADD tags :t"
+	if remove {
+		updateExpression = "DELETE tags :t"
+	}
+	_, err := reactClient.DynamoClient.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(assets.ReactionTableName),
+		Key:                 buildReactionIndexKey(userID, alias),
+		ConditionExpression: aws.String("attribute_exists(alias)"),
+		UpdateExpression:    aws.String(updateExpression),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":t": &dynamodb.AttributeValue{SS: aws.StringSlice(tags)},
+		},
+	})
+	if err != nil {
+		reactClient.ReactErrorLogger.Println(err)
+	}
+	return err
+}
+
+// uniqueTags drops empty and repeated tags, keeping the order they were given in
+func uniqueTags(tags []string) []string {
+	unique := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag != "" && !containsString(unique, tag) {
+			unique = append(unique, tag)
+		}
+	}
+	return unique
+}
+
+func pickReaction(reactions []*Reaction) *Reaction {
+	if len(reactions) < 1 {
+		return nil
+	}
+	return reactions[rand.Intn(len(reactions))]
+}
+
+func buildReactionIndexKey(userID, alias string) map[string]*dynamodb.AttributeValue {
+	//err != nil will get caught in the request
+	key, _ := dynamodbattribute.MarshalMap(ReactionKey{
+		Owner: userID,
+		Alias: alias,
+	})
+	return key
+}
@@ -0,0 +1,448 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/assets"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"mime/multipart"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	promotionPending  = "pending"
+	promotionApproved = "approved"
+	promotionRejected = "rejected"
+	// promotionApproving claims a promotion while its emoji or sticker is uploaded, so concurrent approvals upload it once
+	promotionApproving = "approving"
+	// promotionReleaseTimeout bounds releasing the claim on a promotion whose upload failed
+	promotionReleaseTimeout = 10 * time.Second
+	// promotionListLimit is the most promotions listed, Discord shows at most 25 fields in an embed
+	promotionListLimit = 25
+
+	emojiKind       = "emoji"
+	emojiMaxSize    = 128
+	emojiMaxBytes   = 256 << 10
+	stickerKind     = "sticker"
+	stickerSize     = 320
+	stickerMaxBytes = 512 << 10
+)
+
+var (
+	invalidEmojiName, _ = regexp.Compile(`[^\w]`)
+)
+
+// Promotion represents the schema of a request to promote a reaction to a guild emoji or sticker
+type Promotion struct {
+	Guild     string `dynamodbav:"guild"`
+	ID        string `dynamodbav:"promotion"`
+	Owner     string `dynamodbav:"owner"`
+	Alias     string `dynamodbav:"alias"`
+	Kind      string `dynamodbav:"kind"`
+	Name      string `dynamodbav:"name"`
+	Status    string `dynamodbav:"status"`
+	Reviewer  string `dynamodbav:"reviewer,omitempty"`
+	DiscordID string `dynamodbav:"discordID,omitempty"`
+	Requested int64  `dynamodbav:"requested"`
+}
+
+// PromotionKey is a convenience struct for marshalling Go types into a key for DDB requests for a given promotion
+type PromotionKey struct {
+	Guild string `dynamodbav:"guild"`
+	ID    string `dynamodbav:"promotion"`
+}
+
+func (reactClient *ReactClient) handlePromotion(ctx context.Context, session *discordgo.Session, message *discordgo.Message, args []string) {
+	switch args[0] {
+	case "promote":
+		if len(args) < 3 || (args[2] != emojiKind && args[2] != stickerKind) {
+			session.ChannelMessageSend(message.ChannelID, "Please specify an alias and emoji or sticker.")
+			return
+		}
+		if !reactClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, reactCommand, "promote") {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+			return
+		}
+		name := args[1]
+		if len(args) > 3 {
+			name = args[3]
+		}
+		result, err := reactClient.RequestPromotion(ctx, message.GuildID, message.Author.ID, args[1], args[2], name)
+		ParseServiceResponse(session, message.ChannelID, result, err)
+	case "promotions":
+		if !reactClient.canReviewPromotions(ctx, session, message, args[0]) {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+			return
+		}
+		result, err := reactClient.ListPromotions(ctx, message.GuildID)
+		ParseServiceResponse(session, message.ChannelID, result, err)
+	case "approve", "reject":
+		if len(message.Mentions) < 1 || len(args) < 4 {
+			session.ChannelMessageSend(message.ChannelID, "Please mention the owner and specify an alias and emoji or sticker.")
+			return
+		}
+		if !reactClient.canReviewPromotions(ctx, session, message, args[0]) {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+			return
+		}
+		var result string
+		var err error
+		if args[0] == "approve" {
+			result, err = reactClient.ApprovePromotion(ctx, session, message.GuildID, message.Author.ID, message.Mentions[0].ID, args[2], args[3])
+		} else {
+			result, err = reactClient.RejectPromotion(ctx, message.GuildID, message.Author.ID, message.Mentions[0].ID, args[2], args[3])
+		}
+		ParseServiceResponse(session, message.ChannelID, result, err)
+	}
+}
+
+// canReviewPromotions requires reviewers to be authorized for the review action and able to manage the guild's emojis themselves
+func (reactClient *ReactClient) canReviewPromotions(ctx context.Context, session *discordgo.Session, message *discordgo.Message, action string) bool {
+	return reactClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, reactCommand, action) &&
+		HasDiscordPermission(session, message.Author.ID, message.ChannelID, discordgo.PermissionManageEmojis)
+}
+
+// RequestPromotion records a request to promote a user's reaction to a guild emoji or sticker, pending admin approval
+func (reactClient *ReactClient) RequestPromotion(ctx context.Context, guildID, userID, alias, kind, name string) (string, error) {
+	exists, err := reactClient.reactionExists(ctx, userID, alias)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "No reaction with alias " + alias + " exists.", nil
+	}
+	name = invalidEmojiName.ReplaceAllString(name, "_")
+	if len(name) < 2 || len(name) > 30 {
+		return "Names must be between 2 and 30 letters, numbers or underscores.", nil
+	}
+	item, _ := dynamodbattribute.MarshalMap(Promotion{
+		Guild:     guildID,
+		ID:        buildPromotionID(userID, alias, kind),
+		Owner:     userID,
+		Alias:     alias,
+		Kind:      kind,
+		Name:      name,
+		Status:    promotionPending,
+		Requested: time.Now().Unix(),
+	})
+	_, err = reactClient.DynamoClient.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(assets.PromotionTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(guild) or #s=:r"),
+		ExpressionAttributeNames: map[string]*string{
+			"#s": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":r": &dynamodb.AttributeValue{S: aws.String(promotionRejected)},
+		},
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return "Reaction " + alias + " has already been submitted as a " + kind + ".", nil
+		}
+		reactClient.ReactErrorLogger.Println(err)
+		return "", err
+	}
+	return "Reaction " + alias + " submitted as a " + kind + ". An admin can approve it with " +
+		"`" + CommandPrefix + "react approve @user " + alias + " " + kind + "`.", nil
+}
+
+// ListPromotions lists the promotions requested in a guild and their status
+func (reactClient *ReactClient) ListPromotions(ctx context.Context, guildID string) (interface{}, error) {
+	promotions := make([]*Promotion, 0)
+	err := reactClient.DynamoClient.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(assets.PromotionTableName),
+		KeyConditionExpression: aws.String("guild=:g"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":g": &dynamodb.AttributeValue{S: aws.String(guildID)},
+		},
+	},
+		func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				promotion := &Promotion{}
+				dynamodbattribute.UnmarshalMap(item, promotion)
+				promotions = append(promotions, promotion)
+			}
+			return !lastPage
+		})
+	if err != nil {
+		reactClient.ReactErrorLogger.Println(err)
+		return nil, err
+	}
+	if len(promotions) < 1 {
+		return "No reactions have been submitted in this server.", nil
+	}
+	//Pending promotions are the ones waiting on reviewers, so they are listed before those already reviewed
+	sort.SliceStable(promotions, func(i, j int) bool {
+		return promotions[i].Status == promotionPending && promotions[j].Status != promotionPending
+	})
+	description := "Reactions submitted as emojis and stickers"
+	if len(promotions) > promotionListLimit {
+		description += fmt.Sprintf(", showing %d of %d", promotionListLimit, len(promotions))
+		promotions = promotions[:promotionListLimit]
+	}
+	promotionList := make([]*discordgo.MessageEmbedField, 0, len(promotions))
+	for _, promotion := range promotions {
+		promotionList = append(promotionList, &discordgo.MessageEmbedField{
+			Name:  promotion.Alias + " (" + promotion.Kind + ")",
+			Value: "Owner: <@" + promotion.Owner + ">\nName: " + promotion.Name + "\nStatus: " + promotion.Status,
+		})
+	}
+	return &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{},
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: assets.AvatarURL,
+		},
+		Color:       0x0000ff,
+		Description: description,
+		Fields:      promotionList,
+		Title:       "Promotions",
+	}, nil
+}
+
+// ApprovePromotion uploads a pending promotion's reaction to the guild as an emoji or sticker
+func (reactClient *ReactClient) ApprovePromotion(ctx context.Context, session *discordgo.Session, guildID, reviewerID, ownerID, alias, kind string) (string, error) {
+	promotion, err := reactClient.getPromotion(ctx, guildID, ownerID, alias, kind)
+	if err != nil {
+		return "", err
+	}
+	if promotion == nil || promotion.Status != promotionPending {
+		return "No pending " + kind + " for " + alias + " found.", nil
+	}
+	//Another reviewer may approve or reject the promotion between reading and claiming it
+	claimed, err := reactClient.reviewPromotion(ctx, promotion, reviewerID, promotionPending, promotionApproving, "")
+	if err != nil {
+		return "", err
+	}
+	if !claimed {
+		return "No pending " + kind + " for " + alias + " found.", nil
+	}
+	discordID, mention, err := reactClient.uploadPromotion(ctx, session, promotion)
+	if err != nil {
+		//Released so the promotion can be approved again once whatever failed is fixed, even if the command timed out
+		releaseCtx, cancel := context.WithTimeout(context.Background(), promotionReleaseTimeout)
+		reactClient.reviewPromotion(releaseCtx, promotion, "", promotionApproving, promotionPending, "")
+		cancel()
+		return "", err
+	}
+	_, err = reactClient.reviewPromotion(ctx, promotion, reviewerID, promotionApproving, promotionApproved, discordID)
+	if err != nil {
+		return "", err
+	}
+	reactClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  reviewerID,
+		Action: "react approve",
+		Target: "<@" + ownerID + "> " + alias,
+		Before: promotionPending,
+		After:  mention,
+	})
+	return "Reaction " + alias + " by <@" + ownerID + "> is now " + mention + ".", nil
+}
+
+// uploadPromotion uploads a promotion's reaction to its guild, returning the ID and mention of the emoji or sticker
+func (reactClient *ReactClient) uploadPromotion(ctx context.Context, session *discordgo.Session, promotion *Promotion) (string, string, error) {
+	object, err := reactClient.S3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(assets.BucketName),
+		Key:    aws.String(buildReactionKey(promotion.Owner, promotion.Alias)),
+	})
+	if err != nil {
+		reactClient.ReactErrorLogger.Println(err)
+		return "", "", err
+	}
+	defer object.Body.Close()
+	img, _, err := image.Decode(object.Body)
+	if err != nil {
+		reactClient.ReactErrorLogger.Println(err)
+		return "", "", err
+	}
+
+	if promotion.Kind == emojiKind {
+		data, err := encodeWithinLimit(img, emojiMaxSize, emojiMaxBytes, false)
+		if err != nil {
+			return "", "", err
+		}
+		emoji, err := session.GuildEmojiCreate(promotion.Guild, &discordgo.EmojiParams{
+			Name:  promotion.Name,
+			Image: "data:image/png;base64," + base64.StdEncoding.EncodeToString(data),
+		})
+		if err != nil {
+			reactClient.ReactErrorLogger.Println(err)
+			return "", "", err
+		}
+		return emoji.ID, emoji.MessageFormat(), nil
+	}
+	data, err := encodeWithinLimit(img, stickerSize, stickerMaxBytes, true)
+	if err != nil {
+		return "", "", err
+	}
+	sticker, err := createGuildSticker(session, promotion.Guild, promotion.Name, promotion.Alias, data)
+	if err != nil {
+		reactClient.ReactErrorLogger.Println(err)
+		return "", "", err
+	}
+	return sticker.ID, "sticker " + sticker.Name, nil
+}
+
+// RejectPromotion declines a pending promotion
+func (reactClient *ReactClient) RejectPromotion(ctx context.Context, guildID, reviewerID, ownerID, alias, kind string) (string, error) {
+	promotion, err := reactClient.getPromotion(ctx, guildID, ownerID, alias, kind)
+	if err != nil {
+		return "", err
+	}
+	if promotion == nil || promotion.Status != promotionPending {
+		return "No pending " + kind + " for " + alias + " found.", nil
+	}
+	rejected, err := reactClient.reviewPromotion(ctx, promotion, reviewerID, promotionPending, promotionRejected, "")
+	if err != nil {
+		return "", err
+	}
+	if !rejected {
+		return "No pending " + kind + " for " + alias + " found.", nil
+	}
+	reactClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  reviewerID,
+		Action: "react reject",
+		Target: "<@" + ownerID + "> " + alias,
+		Before: promotionPending,
+		After:  promotionRejected,
+	})
+	return "Reaction " + alias + " by <@" + ownerID + "> was not made into a " + kind + ".", nil
+}
+
+func (reactClient *ReactClient) getPromotion(ctx context.Context, guildID, ownerID, alias, kind string) (*Promotion, error) {
+	result, err := reactClient.DynamoClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(assets.PromotionTableName),
+		Key:       buildPromotionKey(guildID, buildPromotionID(ownerID, alias, kind)),
+	})
+	if err != nil {
+		reactClient.ReactErrorLogger.Println(err)
+		return nil, err
+	}
+	if _, ok := result.Item["promotion"]; !ok {
+		return nil, nil
+	}
+	promotion := &Promotion{}
+	dynamodbattribute.UnmarshalMap(result.Item, promotion)
+	return promotion, nil
+}
+
+// reviewPromotion moves a promotion from one status to another, returning false if it no longer has the status it is moved from
+func (reactClient *ReactClient) reviewPromotion(ctx context.Context, promotion *Promotion, reviewerID, from, to, discordID string) (bool, error) {
+	_, err := reactClient.DynamoClient.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(assets.PromotionTableName),
+		Key:                 buildPromotionKey(promotion.Guild, promotion.ID),
+		ConditionExpression: aws.String("#s=:f"),
+		UpdateExpression:    aws.String("SET #s=:t, reviewer=:r, discordID=:d"),
+		ExpressionAttributeNames: map[string]*string{
+			"#s": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":f": &dynamodb.AttributeValue{S: aws.String(from)},
+			":t": &dynamodb.AttributeValue{S: aws.String(to)},
+			":r": &dynamodb.AttributeValue{S: aws.String(reviewerID)},
+			":d": &dynamodb.AttributeValue{S: aws.String(discordID)},
+		},
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+		reactClient.ReactErrorLogger.Println(err)
+		return false, err
+	}
+	promotion.Status = to
+	promotion.Reviewer = reviewerID
+	promotion.DiscordID = discordID
+	return true, nil
+}
+
+// encodeWithinLimit encodes an image as a png no larger than size x size and maxBytes
+// the image is shrunk until it fits, and centered on a transparent size x size canvas if pad is true
+func encodeWithinLimit(img image.Image, size uint, maxBytes int, pad bool) ([]byte, error) {
+	for {
+		fitted := thumbnailImage(img, ReactionSize{MaxWidth: size, MaxHeight: size})
+		if pad {
+			canvas := image.NewNRGBA(image.Rect(0, 0, int(size), int(size)))
+			offset := image.Pt((int(size)-fitted.Bounds().Dx())/2, (int(size)-fitted.Bounds().Dy())/2)
+			draw.Draw(canvas, fitted.Bounds().Sub(fitted.Bounds().Min).Add(offset), fitted, fitted.Bounds().Min, draw.Over)
+			fitted = canvas
+		}
+		buffer := new(bytes.Buffer)
+		err := png.Encode(buffer, fitted)
+		if err != nil {
+			return nil, err
+		}
+		if buffer.Len() <= maxBytes {
+			return buffer.Bytes(), nil
+		}
+		img = thumbnailImage(img, ReactionSize{
+			MaxWidth:  uint(img.Bounds().Dx()) * 3 / 4,
+			MaxHeight: uint(img.Bounds().Dy()) * 3 / 4,
+		})
+		if img.Bounds().Dx() < 16 || img.Bounds().Dy() < 16 {
+			return nil, &UserError{Reason: "That reaction is too detailed to fit Discord's size limits."}
+		}
+	}
+}
+
+// createGuildSticker uploads a png as a guild sticker
+// discordgo has no sticker endpoints, so the multipart request is built by hand
+func createGuildSticker(session *discordgo.Session, guildID, name, tag string, data []byte) (*discordgo.Sticker, error) {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	writer.WriteField("name", name)
+	writer.WriteField("description", "Promoted from a Flamingo reaction")
+	writer.WriteField("tags", tag)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="file"; filename="`+name+`.png"`)
+	header.Set("Content-Type", "image/png")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	part.Write(data)
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := discordgo.EndpointGuildStickers(guildID)
+	response, err := session.RequestWithLockedBucket("POST", endpoint, writer.FormDataContentType(), body.Bytes(),
+		session.Ratelimiter.LockBucket(endpoint), 0)
+	if err != nil {
+		return nil, err
+	}
+	sticker := &discordgo.Sticker{}
+	err = json.Unmarshal(response, sticker)
+	return sticker, err
+}
+
+func buildPromotionID(ownerID, alias, kind string) string {
+	return ownerID + "!" + alias + "!" + kind
+}
+
+func buildPromotionKey(guildID, promotionID string) map[string]*dynamodb.AttributeValue {
+	//err != nil will get caught in the request
+	key, _ := dynamodbattribute.MarshalMap(PromotionKey{
+		Guild: guildID,
+		ID:    promotionID,
+	})
+	return key
+}
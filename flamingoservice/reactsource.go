@@ -0,0 +1,104 @@
+package flamingoservice
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	messageLink, _   = regexp.Compile(`^https://(?:(?:ptb|canary)\.)?discord(?:app)?\.com/channels/(\d+)/(\d+)/(\d+)$`)
+	transformArgs    = []*regexp.Regexp{crop, rotate, flip, topText, bottomText}
+	reactionAlias, _ = regexp.Compile(`^\w+$`)
+)
+
+// parseReactionSaveArgs splits the arguments of a react save command into aliases and links
+// transform arguments are ignored as they are parsed separately, any other argument with an = is a malformed one
+func parseReactionSaveArgs(content string) (aliases, links []string, err error) {
+	for _, transformArg := range transformArgs {
+		content = transformArg.ReplaceAllString(content, "")
+	}
+	//first two words are always "react save", safe to remove
+	args := strings.Fields(content)
+	if len(args) > 2 {
+		args = args[2:]
+	} else {
+		args = nil
+	}
+	for _, arg := range args {
+		arg = strings.Trim(arg, "<>")
+		switch {
+		case strings.HasPrefix(arg, "https://") || strings.HasPrefix(arg, "http://"):
+			links = append(links, arg)
+		case strings.Contains(arg, "="):
+			return nil, nil, &UserError{Reason: "Could not understand " + arg + ", see ```" + CommandPrefix + "react help``` for the options of save."}
+		case !reactionAlias.MatchString(arg):
+			return nil, nil, &UserError{Reason: "Aliases can only contain letters, numbers and underscores, " + arg + " is not a valid alias."}
+		default:
+			aliases = append(aliases, arg)
+		}
+	}
+	return aliases, links, nil
+}
+
+// resolveReactionSources finds the image URLs a react save command refers to
+// links take precedence over attachments, which take precedence over the message being replied to
+func resolveReactionSources(session *discordgo.Session, message *discordgo.Message, links []string) ([]string, error) {
+	if len(links) > 0 {
+		sources := make([]string, 0, len(links))
+		for _, link := range links {
+			linkParts := messageLink.FindStringSubmatch(link)
+			if linkParts == nil {
+				sources = append(sources, link)
+				continue
+			}
+			if linkParts[1] != message.GuildID {
+				return nil, &UserError{Reason: "Reactions can only be saved from messages in this server."}
+			}
+			permissions, err := session.UserChannelPermissions(message.Author.ID, linkParts[2])
+			if err != nil || permissions&discordgo.PermissionViewChannel == 0 {
+				return nil, &UserError{Reason: "Could not find the linked message."}
+			}
+			linked, err := session.ChannelMessage(linkParts[2], linkParts[3])
+			if err != nil {
+				return nil, &UserError{Reason: "Could not find the linked message."}
+			}
+			sources = append(sources, messageImages(linked)...)
+		}
+		return sources, nil
+	}
+	if sources := messageImages(message); len(sources) > 0 {
+		return sources, nil
+	}
+	if message.MessageReference != nil {
+		referenced := message.ReferencedMessage
+		if referenced == nil {
+			var err error
+			referenced, err = session.ChannelMessage(message.MessageReference.ChannelID, message.MessageReference.MessageID)
+			if err != nil {
+				return nil, &UserError{Reason: "Could not find the message being replied to."}
+			}
+		}
+		return messageImages(referenced), nil
+	}
+	return nil, nil
+}
+
+// messageImages lists the URLs of the images attached or embedded in a message
+func messageImages(message *discordgo.Message) []string {
+	images := make([]string, 0, len(message.Attachments))
+	for _, attachment := range message.Attachments {
+		if attachment.Width > 0 && attachment.Height > 0 {
+			images = append(images, attachment.URL)
+		}
+	}
+	for _, embed := range message.Embeds {
+		if embed.Image != nil && embed.Image.ProxyURL != "" {
+			images = append(images, embed.Image.ProxyURL)
+		} else if embed.Type == discordgo.EmbedTypeImage && embed.Thumbnail != nil && embed.Thumbnail.ProxyURL != "" {
+			images = append(images, embed.Thumbnail.ProxyURL)
+		}
+	}
+	return images
+}
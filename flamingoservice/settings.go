@@ -0,0 +1,147 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/assets"
+	"FlamingoV2/flamingolog"
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const (
+	settingsServiceName = "Settings"
+)
+
+// SettingsClient is responsible for persisting guild and user level settings
+type SettingsClient struct {
+	DynamoClient          *dynamodb.DynamoDB
+	MetricsClient         *flamingolog.FlamingoMetricsClient
+	SettingsServiceLogger *log.Logger
+	SettingsErrorLogger   *log.Logger
+}
+
+// Setting represents the schema of a setting stored in DDB
+type Setting struct {
+	Scope   string `dynamodbav:"scope"`
+	Setting string `dynamodbav:"setting"`
+	Value   string `dynamodbav:"value"`
+}
+
+// SettingKey is a convenience struct for marshalling Go types into a key for DDB requests for a given setting
+type SettingKey struct {
+	Scope   string `dynamodbav:"scope"`
+	Setting string `dynamodbav:"setting"`
+}
+
+// NewSettingsClient constructs a SettingsClient
+func NewSettingsClient(dynamoClient *dynamodb.DynamoDB, metricsClient *flamingolog.FlamingoMetricsClient) *SettingsClient {
+	return &SettingsClient{
+		DynamoClient:          dynamoClient,
+		MetricsClient:         metricsClient,
+		SettingsServiceLogger: flamingolog.BuildServiceLogger(settingsServiceName),
+		SettingsErrorLogger:   flamingolog.BuildServiceErrorLogger(settingsServiceName),
+	}
+}
+
+// GetSetting retrieves the value of a setting for a scope
+// returns false if the setting has never been set
+func (settingsClient *SettingsClient) GetSetting(ctx context.Context, scope, setting string) (string, bool, error) {
+	result, err := settingsClient.DynamoClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(assets.SettingsTableName),
+		Key:       buildSettingKey(scope, setting),
+	})
+	if err != nil {
+		settingsClient.SettingsErrorLogger.Println(err)
+		return "", false, err
+	}
+	value, ok := result.Item["value"]
+	if !ok {
+		return "", false, nil
+	}
+	return *value.S, true, nil
+}
+
+// SetSetting sets the value of a setting for a scope
+func (settingsClient *SettingsClient) SetSetting(ctx context.Context, scope, setting, value string) error {
+	item, _ := dynamodbattribute.MarshalMap(Setting{
+		Scope:   scope,
+		Setting: setting,
+		Value:   value,
+	})
+	_, err := settingsClient.DynamoClient.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(assets.SettingsTableName),
+		Item:      item,
+	})
+	if err != nil {
+		settingsClient.SettingsErrorLogger.Println(err)
+	}
+	return err
+}
+
+// DeleteSetting removes a setting for a scope, restoring the default
+func (settingsClient *SettingsClient) DeleteSetting(ctx context.Context, scope, setting string) error {
+	_, err := settingsClient.DynamoClient.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(assets.SettingsTableName),
+		Key:       buildSettingKey(scope, setting),
+	})
+	if err != nil {
+		settingsClient.SettingsErrorLogger.Println(err)
+	}
+	return err
+}
+
+// ListSettings retrieves every setting for a scope that begins with prefix, keyed by setting
+func (settingsClient *SettingsClient) ListSettings(ctx context.Context, scope, prefix string) (map[string]string, error) {
+	settings := make(map[string]string)
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(assets.SettingsTableName),
+		KeyConditionExpression: aws.String("#s=:s"),
+		ExpressionAttributeNames: map[string]*string{
+			"#s": aws.String("scope"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": &dynamodb.AttributeValue{S: aws.String(scope)},
+		},
+	}
+	//DynamoDB rejects begins_with with an empty prefix, so listing a whole scope drops the condition
+	if prefix != "" {
+		input.KeyConditionExpression = aws.String("#s=:s and begins_with(setting, :p)")
+		input.ExpressionAttributeValues[":p"] = &dynamodb.AttributeValue{S: aws.String(prefix)}
+	}
+	err := settingsClient.DynamoClient.QueryPagesWithContext(ctx, input,
+		func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				setting := &Setting{}
+				dynamodbattribute.UnmarshalMap(item, setting)
+				settings[setting.Setting] = setting.Value
+			}
+			return !lastPage
+		})
+	if err != nil {
+		settingsClient.SettingsErrorLogger.Println(err)
+		return nil, err
+	}
+	return settings, nil
+}
+
+// GuildScope builds the settings scope for a guild
+func GuildScope(guildID string) string {
+	return "guild!" + guildID
+}
+
+// UserScope builds the settings scope for a user
+func UserScope(userID string) string {
+	return "user!" + userID
+}
+
+func buildSettingKey(scope, setting string) map[string]*dynamodb.AttributeValue {
+	//err != nil will get caught in the request
+	key, _ := dynamodbattribute.MarshalMap(SettingKey{
+		Scope:   scope,
+		Setting: setting,
+	})
+	return key
+}
@@ -0,0 +1,251 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/flamingolog"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	shardServiceName = "Shard"
+	// identifyInterval is how long Discord requires between each batch of shards identifying
+	identifyInterval = 5 * time.Second
+)
+
+// Intents are the gateway events Flamingo subscribes to: guilds to keep state, guild and direct messages and their content for commands,
+// and reactions for revealing spoilers. Message content is privileged and must be enabled for the application on Discord.
+const Intents = discordgo.IntentsGuilds |
+	discordgo.IntentsGuildMessages |
+	discordgo.IntentsGuildMessageReactions |
+	discordgo.IntentsDirectMessages |
+	discordgo.IntentMessageContent
+
+// ErrShardOutOfRange is returned when a shard is configured that is not below the shard count
+var ErrShardOutOfRange = errors.New("Shard ID must be less than the shard count")
+
+// Shards is responsible for the gateway sessions this process runs, one per shard
+// Discord assigns each guild to a single shard by its ID, so a guild's state is only held by its shard's session
+type Shards struct {
+	Count              int
+	IDs                []int
+	Sessions           map[int]*discordgo.Session
+	MaxConcurrency     int
+	MetricsClient      *flamingolog.FlamingoMetricsClient
+	ShardServiceLogger *log.Logger
+	ShardErrorLogger   *log.Logger
+	closing            int32
+}
+
+// NewShards constructs a session for each shard this process runs, without connecting them
+// a count of 0 uses the count Discord recommends, and no IDs runs every shard
+func NewShards(token string, count int, ids []int, metricsClient *flamingolog.FlamingoMetricsClient) (*Shards, error) {
+	shards := &Shards{
+		Count:              count,
+		MaxConcurrency:     1,
+		Sessions:           make(map[int]*discordgo.Session),
+		MetricsClient:      metricsClient,
+		ShardServiceLogger: flamingolog.BuildServiceLogger(shardServiceName),
+		ShardErrorLogger:   flamingolog.BuildServiceErrorLogger(shardServiceName),
+	}
+	primary, err := discordgo.New(token)
+	if err != nil {
+		return nil, err
+	}
+	if shards.Count == 0 {
+		gateway, err := primary.GatewayBot()
+		if err != nil {
+			return nil, err
+		}
+		shards.Count = gateway.Shards
+		if gateway.SessionStartLimit.MaxConcurrency > 1 {
+			shards.MaxConcurrency = gateway.SessionStartLimit.MaxConcurrency
+		}
+		shards.ShardServiceLogger.Printf("Discord recommends %d shard(s)\n", shards.Count)
+	}
+	if len(ids) == 0 {
+		for id := 0; id < shards.Count; id++ {
+			ids = append(ids, id)
+		}
+	}
+	shards.IDs = append([]int(nil), ids...)
+	sort.Ints(shards.IDs)
+	for i, id := range shards.IDs {
+		if id < 0 || id >= shards.Count {
+			return nil, ErrShardOutOfRange
+		}
+		session := primary
+		if i > 0 {
+			if session, err = discordgo.New(token); err != nil {
+				return nil, err
+			}
+		}
+		session.ShardID = id
+		session.ShardCount = shards.Count
+		session.Identify.Intents = Intents
+		session.AddHandler(shards.connected)
+		session.AddHandler(shards.ready)
+		session.AddHandler(shards.disconnected)
+		session.AddHandler(shards.resumed)
+		session.AddHandler(shards.rateLimited)
+		shards.Sessions[id] = session
+	}
+	return shards, nil
+}
+
+// ShardFor resolves the shard Discord assigns a guild to
+func ShardFor(guildID string, count int) int {
+	id, _ := strconv.ParseUint(guildID, 10, 64)
+	if count < 1 {
+		return 0
+	}
+	return int((id >> 22) % uint64(count))
+}
+
+// Primary is the session of the lowest shard this process runs, for requests that aren't tied to a guild
+func (shards *Shards) Primary() *discordgo.Session {
+	return shards.Sessions[shards.IDs[0]]
+}
+
+// Runs identifies whether this process runs a shard
+func (shards *Shards) Runs(id int) bool {
+	_, ok := shards.Sessions[id]
+	return ok
+}
+
+// ForGuild resolves the session of a guild's shard, nil if another process runs it
+func (shards *Shards) ForGuild(guildID string) *discordgo.Session {
+	return shards.Sessions[ShardFor(guildID, shards.Count)]
+}
+
+// Guild resolves a guild from its shard's state, asking Discord if another process runs its shard
+func (shards *Shards) Guild(guildID string) (*discordgo.Guild, error) {
+	if session := shards.ForGuild(guildID); session != nil {
+		return session.State.Guild(guildID)
+	}
+	return shards.Primary().Guild(guildID)
+}
+
+// Member resolves a guild member from its guild's shard's state
+func (shards *Shards) Member(guildID, userID string) (*discordgo.Member, error) {
+	if session := shards.ForGuild(guildID); session != nil {
+		return session.State.Member(guildID, userID)
+	}
+	return nil, discordgo.ErrStateNotFound
+}
+
+// Channel resolves a guild's channel from its guild's shard's state
+func (shards *Shards) Channel(guildID, channelID string) (*discordgo.Channel, error) {
+	if session := shards.ForGuild(guildID); session != nil {
+		return session.State.Channel(channelID)
+	}
+	return shards.Primary().Channel(channelID)
+}
+
+// AddHandler registers an event handler on every shard, handlers are passed the session of the shard the event arrived on
+func (shards *Shards) AddHandler(handler interface{}) {
+	for _, id := range shards.IDs {
+		shards.Sessions[id].AddHandler(handler)
+	}
+}
+
+// Open connects every shard, in batches as large as Discord allows shards to identify at once
+func (shards *Shards) Open() error {
+	for i, id := range shards.IDs {
+		if i > 0 && i%shards.MaxConcurrency == 0 {
+			time.Sleep(identifyInterval)
+		}
+		if err := shards.Sessions[id].Open(); err != nil {
+			shards.ShardErrorLogger.Printf("Could not open shard %d of %d\n", id, shards.Count)
+			return err
+		}
+		shards.ShardServiceLogger.Printf("Opened shard %d of %d\n", id, shards.Count)
+	}
+	return nil
+}
+
+// Close disconnects every shard
+func (shards *Shards) Close() {
+	atomic.StoreInt32(&shards.closing, 1)
+	var wait sync.WaitGroup
+	for _, session := range shards.Sessions {
+		wait.Add(1)
+		go func(session *discordgo.Session) {
+			defer wait.Done()
+			session.Close()
+		}(session)
+	}
+	wait.Wait()
+}
+
+// ReportEvery publishes each shard's guild count, connection and heartbeat latency at every interval until done is closed
+func (shards *Shards) ReportEvery(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, session := range shards.Sessions {
+				dimensions := shardDimensions(session)
+				session.RLock()
+				connected := 0.0
+				if session.DataReady {
+					connected = 1
+				}
+				session.RUnlock()
+				session.State.RLock()
+				guilds := len(session.State.Guilds)
+				session.State.RUnlock()
+				shards.MetricsClient.PutMetric(shardServiceName, "Connected", connected, "Count", dimensions)
+				shards.MetricsClient.PutMetric(shardServiceName, "Guilds", float64(guilds), "Count", dimensions)
+				shards.MetricsClient.PutMetric(shardServiceName, "HeartbeatLatency", float64(session.HeartbeatLatency().Milliseconds()), "Milliseconds", dimensions)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (shards *Shards) connected(session *discordgo.Session, connect *discordgo.Connect) {
+	shards.ShardServiceLogger.Printf("Shard %d connected to the gateway\n", session.ShardID)
+}
+
+func (shards *Shards) ready(session *discordgo.Session, ready *discordgo.Ready) {
+	shards.ShardServiceLogger.Printf("Shard %d ready with %d guild(s)\n", session.ShardID, len(ready.Guilds))
+	shards.MetricsClient.Count(shardServiceName, "Ready", shardDimensions(session))
+}
+
+// disconnected records a shard losing its connection, discordgo reconnects and resumes it unless Flamingo is shutting down
+func (shards *Shards) disconnected(session *discordgo.Session, disconnect *discordgo.Disconnect) {
+	if atomic.LoadInt32(&shards.closing) == 1 {
+		shards.ShardServiceLogger.Printf("Shard %d disconnected\n", session.ShardID)
+		return
+	}
+	shards.ShardErrorLogger.Printf("Shard %d disconnected from the gateway, reconnecting\n", session.ShardID)
+	shards.MetricsClient.Count(shardServiceName, "Disconnect", shardDimensions(session))
+}
+
+func (shards *Shards) resumed(session *discordgo.Session, resumed *discordgo.Resumed) {
+	shards.ShardServiceLogger.Printf("Shard %d resumed its session\n", session.ShardID)
+	shards.MetricsClient.Count(shardServiceName, "Resume", shardDimensions(session))
+}
+
+// rateLimited records a REST request that hit a rate limit, discordgo waits and retries it
+func (shards *Shards) rateLimited(session *discordgo.Session, limit *discordgo.RateLimit) {
+	retryAfter := time.Duration(0)
+	if limit.TooManyRequests != nil {
+		retryAfter = limit.RetryAfter
+	}
+	shards.ShardErrorLogger.Printf("Shard %d was rate limited on %s, retrying after %s\n", session.ShardID, limit.URL, retryAfter)
+	shards.MetricsClient.Count(shardServiceName, "RateLimit", shardDimensions(session))
+}
+
+func shardDimensions(session *discordgo.Session) map[string]string {
+	return map[string]string{"Shard": strconv.Itoa(session.ShardID)}
+}
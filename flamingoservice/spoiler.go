@@ -1,41 +1,411 @@
 package flamingoservice
 
 import (
+	"FlamingoV2/assets"
+	"FlamingoV2/flamingolog"
+	"context"
+	"log"
 	"regexp"
 	"strings"
+	"unicode"
 
 	"github.com/bwmarrin/discordgo"
 )
 
 const (
 	spoilerServiceName = "Spoil"
+	spoilerCommand     = "spoiler"
+
+	spoilerModeSetting       = "spoiler!mode"
+	spoilerSubscriberSetting = "spoiler!subscriber!"
+	spoilerEmojiSetting      = "spoiler!emoji"
+	revealCommandName        = "Reveal spoilers"
+	spoilerModeOff           = "off"
+	spoilerModeDM            = "dm"
+	spoilerModePublic        = "public"
+
+	spoilerAttachmentPrefix = "SPOILER_"
+	maxRevealLength         = 1900
 )
 
 var (
-	spoiler, _ = regexp.Compile(`\|\|.*\|\|`)
+	spoiler, _     = regexp.Compile(`(?s)\|\|.+\|\|`)
+	customEmoji, _ = regexp.Compile(`^<a?:(\w+:\d+)>$`)
 )
 
-// SpoilerClient is responsible for auto-decoding spoilers
+// SpoilerClient is responsible for revealing spoilers in guilds and to users that opt in
 type SpoilerClient struct {
-	AuthClient *AuthClient
+	AuthClient           *AuthClient
+	SettingsClient       *SettingsClient
+	Cache                *Cache
+	SpoilerServiceLogger *log.Logger
+	SpoilerErrorLogger   *log.Logger
 }
 
 // NewSpoilerClient constructs a SpoilerClient
-func NewSpoilerClient(authClient *AuthClient) *SpoilerClient {
+func NewSpoilerClient(authClient *AuthClient, settingsClient *SettingsClient, cache *Cache) *SpoilerClient {
 	return &SpoilerClient{
-		AuthClient: authClient,
+		AuthClient:           authClient,
+		SettingsClient:       settingsClient,
+		Cache:                cache,
+		SpoilerServiceLogger: flamingolog.BuildServiceLogger(spoilerServiceName),
+		SpoilerErrorLogger:   flamingolog.BuildServiceErrorLogger(spoilerServiceName),
 	}
 }
 
 // IsCommand identifies a message as a potential command
 func (spoilerClient *SpoilerClient) IsCommand(message string) bool {
-	return spoiler.MatchString(message)
+	return strings.HasPrefix(message, spoilerCommand)
 }
 
 // Handle parses a command message and performs the commanded action
-func (spoilerClient *SpoilerClient) Handle(session *discordgo.Session, message *discordgo.Message) {
-	if !spoilerClient.AuthClient.Authorize(message.GuildID, message.Author.ID, "spoiler", "") {
-		contents := strings.Replace(message.Content, "||", "", -1)
-		ParseServiceResponse(session, message.ChannelID, contents, nil)
+func (spoilerClient *SpoilerClient) Handle(ctx context.Context, session *discordgo.Session, message *discordgo.Message) {
+	//first word is always "spoiler", safe to remove
+	args := strings.Fields(message.Content)[1:]
+	if len(args) < 2 {
+		spoilerClient.Help(session, message.ChannelID)
+		return
+	}
+	//sub-commands of spoiler
+	switch args[0] {
+	case "guild":
+		if !spoilerClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, spoilerCommand, "guild") ||
+			!HasDiscordPermission(session, message.Author.ID, message.ChannelID, discordgo.PermissionManageServer) {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+			return
+		}
+		result, err := spoilerClient.SetGuildMode(ctx, message.GuildID, args[1])
+		ParseServiceResponse(session, message.ChannelID, result, err)
+	case "me":
+		result, err := spoilerClient.SetSubscription(ctx, message.GuildID, message.Author.ID, args[1])
+		ParseServiceResponse(session, message.ChannelID, result, err)
+	case "emoji":
+		if !spoilerClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, spoilerCommand, "emoji") ||
+			!HasDiscordPermission(session, message.Author.ID, message.ChannelID, discordgo.PermissionManageServer) {
+			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
+			return
+		}
+		result, err := spoilerClient.SetRevealEmoji(ctx, message.GuildID, args[1])
+		ParseServiceResponse(session, message.ChannelID, result, err)
+	default:
+		spoilerClient.Help(session, message.ChannelID)
+	}
+}
+
+// IsSpoiler identifies a message as potentially containing spoilers
+func (spoilerClient *SpoilerClient) IsSpoiler(message *discordgo.Message) bool {
+	return spoiler.MatchString(message.Content) || len(spoilerAttachments(message)) > 0
+}
+
+// Reveal reveals the spoilers in a message according to the guild's mode and its subscribers
+func (spoilerClient *SpoilerClient) Reveal(ctx context.Context, session *discordgo.Session, message *discordgo.Message) {
+	mode, _, err := spoilerClient.SettingsClient.GetSetting(ctx, GuildScope(message.GuildID), spoilerModeSetting)
+	if err != nil || mode == "" || mode == spoilerModeOff {
+		return
+	}
+	revealed := revealSpoilers(message)
+	if revealed == "" {
+		return
+	}
+	if mode == spoilerModePublic {
+		spoilerClient.sendRevealed(session, message.ChannelID, "Spoilers from <@"+message.Author.ID+">:\n"+revealed)
+	}
+
+	subscribers, err := spoilerClient.SettingsClient.ListSettings(ctx, GuildScope(message.GuildID), spoilerSubscriberSetting)
+	if err != nil {
+		return
+	}
+	for setting := range subscribers {
+		userID := strings.TrimPrefix(setting, spoilerSubscriberSetting)
+		if userID == message.Author.ID {
+			continue
+		}
+		//Subscribers are only sent spoilers from channels they can see
+		if !HasDiscordPermission(session, userID, message.ChannelID, discordgo.PermissionViewChannel) {
+			continue
+		}
+		dmChannel, err := session.UserChannelCreate(userID)
+		if err != nil {
+			spoilerClient.SpoilerErrorLogger.Println(err)
+			continue
+		}
+		spoilerClient.sendRevealed(session, dmChannel.ID, "Spoilers from <@"+message.Author.ID+"> in <#"+message.ChannelID+">:\n"+revealed)
+	}
+}
+
+// sendRevealed sends revealed spoilers without pinging anyone they mention, since their text is written by users
+func (spoilerClient *SpoilerClient) sendRevealed(session *discordgo.Session, channelID, content string) {
+	_, err := session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content:         content,
+		AllowedMentions: &discordgo.MessageAllowedMentions{Parse: []discordgo.AllowedMentionType{}},
+	})
+	if err != nil {
+		spoilerClient.SpoilerErrorLogger.Println(err)
+	}
+}
+
+// CanRevealReaction filters out reactions that can't reveal anything, so they aren't queued behind commands
+// reactions are compared with the cached reveal emoji, guilds whose emoji isn't cached are left to RevealReaction to look up
+func (spoilerClient *SpoilerClient) CanRevealReaction(session *discordgo.Session, reaction *discordgo.MessageReactionAdd) bool {
+	if reaction.GuildID == "" || (session.State.User != nil && reaction.UserID == session.State.User.ID) {
+		return false
+	}
+	if reaction.Member != nil && reaction.Member.User != nil && reaction.Member.User.Bot {
+		return false
+	}
+	if emoji, ok := spoilerClient.Cache.Get(reaction.GuildID); ok {
+		return emoji.(string) != "" && emoji.(string) == reaction.Emoji.APIName()
+	}
+	return true
+}
+
+// IsRevealInteraction identifies an interaction as the reveal spoilers context menu command
+func (spoilerClient *SpoilerClient) IsRevealInteraction(interaction *discordgo.InteractionCreate) bool {
+	return interaction.Type == discordgo.InteractionApplicationCommand &&
+		interaction.ApplicationCommandData().Name == revealCommandName
+}
+
+// RevealReaction DMs the spoilers in a message to a user who reacts to it with the guild's reveal emoji
+// guilds have no reveal emoji until one is set, so reactions are left alone unless a guild opts in
+func (spoilerClient *SpoilerClient) RevealReaction(ctx context.Context, session *discordgo.Session, reaction *discordgo.MessageReactionAdd) {
+	if !spoilerClient.CanRevealReaction(session, reaction) {
+		return
+	}
+	emoji, err := spoilerClient.revealEmoji(ctx, reaction.GuildID)
+	if err != nil || emoji == "" || emoji != reaction.Emoji.APIName() {
+		return
+	}
+	message, err := session.ChannelMessage(reaction.ChannelID, reaction.MessageID)
+	if err != nil {
+		spoilerClient.SpoilerErrorLogger.Println(err)
+		return
+	}
+	//Reactions to messages without spoilers are ordinary reactions
+	if !spoilerClient.IsSpoiler(message) {
+		return
+	}
+	//Best effort to keep who revealed a spoiler private, requires permission to manage messages
+	session.MessageReactionRemove(reaction.ChannelID, reaction.MessageID, reaction.Emoji.APIName(), reaction.UserID)
+
+	dmChannel, err := session.UserChannelCreate(reaction.UserID)
+	if err != nil {
+		spoilerClient.SpoilerErrorLogger.Println(err)
+		return
+	}
+	revealed := revealSpoilers(message)
+	if revealed == "" {
+		session.ChannelMessageSend(dmChannel.ID, "That message has no spoilers.")
+		return
+	}
+	spoilerClient.sendRevealed(session, dmChannel.ID, "Spoilers from <@"+message.Author.ID+"> in <#"+message.ChannelID+">:\n"+revealed)
+}
+
+// revealEmoji resolves a guild's reveal emoji, empty if the guild hasn't set one
+// every reaction in every guild is checked against it, so it is cached
+func (spoilerClient *SpoilerClient) revealEmoji(ctx context.Context, guildID string) (string, error) {
+	if emoji, ok := spoilerClient.Cache.Get(guildID); ok {
+		return emoji.(string), nil
+	}
+	emoji, _, err := spoilerClient.SettingsClient.GetSetting(ctx, GuildScope(guildID), spoilerEmojiSetting)
+	if err != nil {
+		return "", err
+	}
+	spoilerClient.Cache.Put(guildID, emoji)
+	return emoji, nil
+}
+
+// RevealInteraction responds to the reveal spoilers context menu command with the spoilers in a message
+// the response is ephemeral so only the user who asked can see it
+func (spoilerClient *SpoilerClient) RevealInteraction(ctx context.Context, session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	if !spoilerClient.IsRevealInteraction(interaction) {
+		return
+	}
+	data := interaction.ApplicationCommandData()
+	if data.Resolved == nil {
+		return
+	}
+	revealed := "That message has no spoilers."
+	if message, ok := data.Resolved.Messages[data.TargetID]; ok {
+		if text := revealSpoilers(message); text != "" {
+			revealed = text
+		}
+	}
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         revealed,
+			Flags:           discordgo.MessageFlagsEphemeral,
+			AllowedMentions: &discordgo.MessageAllowedMentions{Parse: []discordgo.AllowedMentionType{}},
+		},
+	})
+	if err != nil {
+		spoilerClient.SpoilerErrorLogger.Println(err)
+	}
+}
+
+// RegisterCommands registers the reveal spoilers context menu command, replacing Flamingo's existing global commands
+// the overwrite is idempotent, so it only needs doing once per start rather than on every connection
+func (spoilerClient *SpoilerClient) RegisterCommands(session *discordgo.Session) {
+	_, err := session.ApplicationCommandBulkOverwrite(session.State.User.ID, "", []*discordgo.ApplicationCommand{
+		&discordgo.ApplicationCommand{
+			Name: revealCommandName,
+			Type: discordgo.MessageApplicationCommand,
+		},
+	})
+	if err != nil {
+		spoilerClient.SpoilerErrorLogger.Println(err)
+	}
+}
+
+// SetRevealEmoji sets the emoji users react with to have a message's spoilers sent to them
+// off disables revealing spoilers by reaction
+func (spoilerClient *SpoilerClient) SetRevealEmoji(ctx context.Context, guildID, emoji string) (string, error) {
+	defer spoilerClient.Cache.Invalidate(guildID)
+	if emoji == spoilerModeOff {
+		err := spoilerClient.SettingsClient.DeleteSetting(ctx, GuildScope(guildID), spoilerEmojiSetting)
+		return "Spoilers can no longer be revealed by reaction.", err
+	}
+	//Custom emojis are stored in the name:id form reactions report them in
+	apiName := emoji
+	if custom := customEmoji.FindStringSubmatch(emoji); custom != nil {
+		apiName = custom[1]
+	}
+	err := spoilerClient.SettingsClient.SetSetting(ctx, GuildScope(guildID), spoilerEmojiSetting, apiName)
+	return "React with " + emoji + " to be sent the spoilers in a message.", err
+}
+
+// SetGuildMode sets how spoilers are revealed in a guild
+// off reveals nothing, dm only reveals spoilers to subscribers and public also reveals them in the channel
+func (spoilerClient *SpoilerClient) SetGuildMode(ctx context.Context, guildID, mode string) (string, error) {
+	switch mode {
+	case spoilerModeOff:
+		err := spoilerClient.SettingsClient.DeleteSetting(ctx, GuildScope(guildID), spoilerModeSetting)
+		return "Spoilers will no longer be revealed in this server.", err
+	case spoilerModeDM:
+		err := spoilerClient.SettingsClient.SetSetting(ctx, GuildScope(guildID), spoilerModeSetting, mode)
+		return "Spoilers will be revealed by DM to users who opt in with `" + CommandPrefix + "spoiler me dm`.", err
+	case spoilerModePublic:
+		err := spoilerClient.SettingsClient.SetSetting(ctx, GuildScope(guildID), spoilerModeSetting, mode)
+		return "Spoilers will be revealed in the channel they are posted in.", err
+	}
+	return "Mode must be one of off, dm or public.", nil
+}
+
+// SetSubscription opts a user in or out of receiving revealed spoilers from a guild by DM
+func (spoilerClient *SpoilerClient) SetSubscription(ctx context.Context, guildID, userID, mode string) (string, error) {
+	switch mode {
+	case spoilerModeOff:
+		err := spoilerClient.SettingsClient.DeleteSetting(ctx, GuildScope(guildID), spoilerSubscriberSetting+userID)
+		return "You will no longer be sent spoilers from this server.", err
+	case spoilerModeDM:
+		err := spoilerClient.SettingsClient.SetSetting(ctx, GuildScope(guildID), spoilerSubscriberSetting+userID, mode)
+		return "You will be sent spoilers from this server by DM while it has spoilers turned on.", err
+	}
+	return "Mode must be one of off or dm.", nil
+}
+
+// Help provides assistance with the spoiler command by sending a help dialogue
+func (spoilerClient *SpoilerClient) Help(session *discordgo.Session, channelID string) {
+	session.ChannelMessageSendEmbed(channelID,
+		&discordgo.MessageEmbed{
+			Author: &discordgo.MessageEmbedAuthor{},
+			Thumbnail: &discordgo.MessageEmbedThumbnail{
+				URL: assets.AvatarURL,
+			},
+			Color:       0xff0000,
+			Title:       "You need help!",
+			Description: "The commands for spoiler are:",
+			Fields: []*discordgo.MessageEmbedField{
+				&discordgo.MessageEmbedField{
+					Name: "guild",
+					Value: "Sets how spoilers are revealed in the server. off reveals nothing, dm reveals spoilers only to users who opt in and public also reveals them in the channel. Requires permission to manage the server.\n" +
+						"Usage: ```" + CommandPrefix + "spoiler guild off|dm|public```",
+				},
+				&discordgo.MessageEmbedField{
+					Name: "me",
+					Value: "Opts in or out of being sent the spoilers posted in the server by DM.\n" +
+						"Usage: ```" + CommandPrefix + "spoiler me dm|off```",
+				},
+				&discordgo.MessageEmbedField{
+					Name: "emoji",
+					Value: "Sets the emoji to react to a message with to be sent its spoilers by DM. Spoilers can also be revealed privately with the Reveal spoilers app command on any message. Requires permission to manage the server.\n" +
+						"Usage: ```" + CommandPrefix + "spoiler emoji $emoji|off```",
+				},
+				&discordgo.MessageEmbedField{
+					Name:  "help",
+					Value: "Shows this help message.",
+				},
+			},
+		})
+}
+
+// revealSpoilers builds a message containing only the spoilered text and attachments of a message
+func revealSpoilers(message *discordgo.Message) string {
+	revealed := findSpoilers(message.Content)
+	revealed = append(revealed, spoilerAttachments(message)...)
+	text := strings.Join(revealed, "\n")
+	//Truncated by character, so a multi-byte character is never split
+	if runes := []rune(text); len(runes) > maxRevealLength {
+		text = string(runes[:maxRevealLength]) + "..."
+	}
+	return text
+}
+
+// spoilerAttachments lists the URLs of attachments uploaded as spoilers
+func spoilerAttachments(message *discordgo.Message) []string {
+	attachments := make([]string, 0)
+	for _, attachment := range message.Attachments {
+		if strings.HasPrefix(attachment.Filename, spoilerAttachmentPrefix) {
+			attachments = append(attachments, attachment.URL)
+		}
+	}
+	return attachments
+}
+
+// findSpoilers extracts the text of each outermost spoiler span in a message
+// delimiters inside code blocks and inline code are ignored. A delimiter inside a span opens a nested span
+// when it is preceded by whitespace and followed by text, otherwise it closes the innermost span.
+// Nested delimiters are removed from the revealed text. Unclosed spans are not spoilers and are ignored.
+func findSpoilers(content string) []string {
+	spans := make([]string, 0)
+	span := new(strings.Builder)
+	depth := 0
+	inCodeBlock, inInlineCode := false, false
+	for i := 0; i < len(content); {
+		switch {
+		case !inInlineCode && strings.HasPrefix(content[i:], "```"):
+			inCodeBlock = !inCodeBlock
+			if depth > 0 {
+				span.WriteString("```")
+			}
+			i += 3
+		case !inCodeBlock && content[i] == '`':
+			inInlineCode = !inInlineCode
+			if depth > 0 {
+				span.WriteByte('`')
+			}
+			i++
+		case !inCodeBlock && !inInlineCode && strings.HasPrefix(content[i:], "||"):
+			precededBySpace := i == 0 || unicode.IsSpace(rune(content[i-1]))
+			followedBySpace := i+2 >= len(content) || unicode.IsSpace(rune(content[i+2]))
+			if depth == 0 || (precededBySpace && !followedBySpace) {
+				depth++
+			} else {
+				depth--
+				if depth == 0 {
+					if text := strings.TrimSpace(span.String()); text != "" {
+						spans = append(spans, text)
+					}
+					span.Reset()
+				}
+			}
+			i += 2
+		default:
+			if depth > 0 {
+				span.WriteByte(content[i])
+			}
+			i++
+		}
 	}
+	return spans
 }
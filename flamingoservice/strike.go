@@ -3,12 +3,12 @@ package flamingoservice
 import (
 	"FlamingoV2/assets"
 	"FlamingoV2/flamingolog"
+	"context"
 	"errors"
 	"log"
 	"strconv"
 	"strings"
 
-
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
@@ -25,6 +25,7 @@ type StrikeClient struct {
 	DynamoClient        *dynamodb.DynamoDB
 	MetricsClient       *flamingolog.FlamingoMetricsClient
 	AuthClient          *AuthClient
+	AuditClient         *AuditClient
 	StrikeServiceLogger *log.Logger
 	StrikeErrorLogger   *log.Logger
 }
@@ -41,11 +42,12 @@ type StrikeKey struct {
 }
 
 // NewStrikeClient constructs a StrikeClient
-func NewStrikeClient(dynamoClient *dynamodb.DynamoDB, metricsClient *flamingolog.FlamingoMetricsClient, authClient *AuthClient) *StrikeClient {
+func NewStrikeClient(dynamoClient *dynamodb.DynamoDB, metricsClient *flamingolog.FlamingoMetricsClient, authClient *AuthClient, auditClient *AuditClient) *StrikeClient {
 	return &StrikeClient{
 		DynamoClient:        dynamoClient,
 		MetricsClient:       metricsClient,
 		AuthClient:          authClient,
+		AuditClient:         auditClient,
 		StrikeServiceLogger: flamingolog.BuildServiceLogger(strikeServiceName),
 		StrikeErrorLogger:   flamingolog.BuildServiceErrorLogger(strikeServiceName),
 	}
@@ -57,7 +59,7 @@ func (strikeClient *StrikeClient) IsCommand(message string) bool {
 }
 
 // Handle parses a command message and performs the commanded action
-func (strikeClient *StrikeClient) Handle(session *discordgo.Session, message *discordgo.Message) {
+func (strikeClient *StrikeClient) Handle(ctx context.Context, session *discordgo.Session, message *discordgo.Message) {
 	//first word is always "strike", safe to remove
 	args := strings.SplitN(message.Content, " ", 3)[1:]
 	if len(args) < 1 {
@@ -71,21 +73,21 @@ func (strikeClient *StrikeClient) Handle(session *discordgo.Session, message *di
 		case 0:
 			session.ChannelMessageSend(message.ChannelID, "Please mention somone!")
 		case 1:
-			strikes, err := strikeClient.GetStrikesForUser(message.GuildID, message.ChannelID, message.Mentions[0].ID)
+			strikes, err := strikeClient.GetStrikesForUser(ctx, message.GuildID, message.ChannelID, message.Mentions[0].ID)
 			ParseServiceResponse(session, message.ChannelID, strikes, err)
 		default:
-			strikes, err := strikeClient.BatchGetStrikesForUser(message.GuildID, message.ChannelID, message.Mentions)
+			strikes, err := strikeClient.BatchGetStrikesForUser(ctx, message.GuildID, message.ChannelID, message.Mentions)
 			ParseServiceResponse(session, message.ChannelID, strikes, err)
 		}
 
 	case "clear":
-		if strikeClient.AuthClient.Authorize(message.GuildID, message.Author.ID, strikeCommand, "clear") {
+		if strikeClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, strikeCommand, "clear") {
 			if len(message.Mentions) < 1 {
 				session.ChannelMessageSend(message.ChannelID, "Please mention somone!")
 				return
 			}
 			for _, v := range message.Mentions {
-				strikes, err := strikeClient.ClearStrikesForUser(message.GuildID, message.ChannelID, v.ID)
+				strikes, err := strikeClient.ClearStrikesForUser(ctx, message.GuildID, message.ChannelID, message.Author.ID, v.ID)
 				ParseServiceResponse(session, message.ChannelID, strikes, err)
 			}
 		} else {
@@ -97,9 +99,9 @@ func (strikeClient *StrikeClient) Handle(session *discordgo.Session, message *di
 			strikeClient.Help(session, message.ChannelID)
 			return
 		}
-		if strikeClient.AuthClient.Authorize(message.GuildID, message.Author.ID, strikeCommand, "super") {
+		if strikeClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, strikeCommand, "super") {
 			for _, v := range message.Mentions {
-				strikes, err := strikeClient.SuperStrikeUser(message.GuildID, message.ChannelID, v.ID)
+				strikes, err := strikeClient.SuperStrikeUser(ctx, message.GuildID, message.ChannelID, message.Author.ID, v.ID)
 				ParseServiceResponse(session, message.ChannelID, strikes, err)
 			}
 		} else {
@@ -113,9 +115,9 @@ func (strikeClient *StrikeClient) Handle(session *discordgo.Session, message *di
 			strikeClient.Help(session, message.ChannelID)
 			return
 		}
-		if strikeClient.AuthClient.Authorize(message.GuildID, message.Author.ID, strikeCommand, "") {
+		if strikeClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, strikeCommand, "") {
 			for _, v := range message.Mentions {
-				strikes, err := strikeClient.StrikeUser(message.GuildID, message.ChannelID, v.ID)
+				strikes, err := strikeClient.StrikeUser(ctx, message.GuildID, message.ChannelID, message.Author.ID, v.ID)
 				ParseServiceResponse(session, message.ChannelID, strikes, err)
 			}
 		} else {
@@ -125,69 +127,118 @@ func (strikeClient *StrikeClient) Handle(session *discordgo.Session, message *di
 }
 
 // StrikeUser adds 1 to the strike count of a user
-func (strikeClient *StrikeClient) StrikeUser(guildID, channelID, userID string) (string, error) {
-	result, err := strikeClient.DynamoClient.UpdateItem(&dynamodb.UpdateItemInput{
-		TableName:                 aws.String(assets.StrikeTableName),
-		Key:                       buildStrikeKey(guildID, userID),
-		UpdateExpression:          aws.String("ADD strikes :s"),
-		ExpressionAttributeValues: buildStrikeUpdateExpression(1),
-		ReturnValues:              aws.String("UPDATED_NEW"),
-	})
+func (strikeClient *StrikeClient) StrikeUser(ctx context.Context, guildID, channelID, requester, userID string) (string, error) {
+	strikes, err := strikeClient.AddStrikes(ctx, guildID, requester, userID, 1)
 	if err != nil {
-		strikeClient.StrikeErrorLogger.Println(err)
-		return "", nil
-	}
-	strikeCount, ok := result.Attributes["strikes"]
-	if ok {
-		return "<@" + userID + "> has " + *strikeCount.N + " strikes.", nil
+		return "", err
 	}
-	strikeClient.StrikeErrorLogger.Printf("strike attribute not found after update guildID=%s userID=%s", guildID, userID)
-	return "", errors.New("strike attribute not found after update")
+	return "<@" + userID + "> has " + strconv.Itoa(strikes) + " strikes.", nil
 }
 
 // SuperStrikeUser adds 10 to the strike count of a user
-func (strikeClient *StrikeClient) SuperStrikeUser(guildID, channelID, userID string) (string, error) {
-	result, err := strikeClient.DynamoClient.UpdateItem(&dynamodb.UpdateItemInput{
+func (strikeClient *StrikeClient) SuperStrikeUser(ctx context.Context, guildID, channelID, requester, userID string) (string, error) {
+	strikes, err := strikeClient.AddStrikes(ctx, guildID, requester, userID, 10)
+	if err != nil {
+		return "", err
+	}
+	return "<@" + userID + "> has " + strconv.Itoa(strikes) + " strikes.", nil
+}
+
+// AddStrikes adds to the strike count of a user, returning their new count
+// strikes are audited so a guild has a history of who was struck by whom
+func (strikeClient *StrikeClient) AddStrikes(ctx context.Context, guildID, requester, userID string, strikes int) (int, error) {
+	result, err := strikeClient.DynamoClient.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
 		TableName:                 aws.String(assets.StrikeTableName),
 		Key:                       buildStrikeKey(guildID, userID),
 		UpdateExpression:          aws.String("ADD strikes :s"),
-		ExpressionAttributeValues: buildStrikeUpdateExpression(10),
+		ExpressionAttributeValues: buildStrikeUpdateExpression(strikes),
 		ReturnValues:              aws.String("UPDATED_NEW"),
 	})
 	if err != nil {
 		strikeClient.StrikeErrorLogger.Println(err)
-		return "", nil
+		return 0, err
 	}
 	strikeCount, ok := result.Attributes["strikes"]
 	if ok {
-		return "<@" + userID + "> has " + *strikeCount.N + " strikes.", nil
+		total, err := strconv.Atoi(*strikeCount.N)
+		if err != nil {
+			return 0, err
+		}
+		strikeClient.AuditClient.Record(ctx, AuditEntry{
+			Guild:  guildID,
+			Actor:  requester,
+			Action: "strike add",
+			Target: "<@" + userID + ">",
+			Before: strconv.Itoa(total - strikes),
+			After:  strconv.Itoa(total),
+		})
+		return total, nil
 	}
 	strikeClient.StrikeErrorLogger.Printf("strike attribute not found after update guildID=%s userID=%s", guildID, userID)
-	return "", errors.New("strike attribute not found after update")
+	return 0, errors.New("strike attribute not found after update")
 }
 
 // GetStrikesForUser retreives the number of strikes a user has
-func (strikeClient *StrikeClient) GetStrikesForUser(guildID, channelID, userID string) (string, error) {
-	result, err := strikeClient.DynamoClient.GetItem(&dynamodb.GetItemInput{
+func (strikeClient *StrikeClient) GetStrikesForUser(ctx context.Context, guildID, channelID, userID string) (string, error) {
+	strikes, err := strikeClient.CountStrikes(ctx, guildID, userID)
+	if err != nil {
+		return "", err
+	}
+	switch strikes {
+	case 0:
+		return "<@" + userID + "> has no strikes.", nil
+	case 1:
+		return "<@" + userID + "> has 1 strike.", nil
+	}
+	return "<@" + userID + "> has " + strconv.Itoa(strikes) + " strikes.", nil
+}
+
+// CountStrikes retrieves the number of strikes a user has, 0 if they have never been struck
+func (strikeClient *StrikeClient) CountStrikes(ctx context.Context, guildID, userID string) (int, error) {
+	result, err := strikeClient.DynamoClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(assets.StrikeTableName),
 		Key:       buildStrikeKey(guildID, userID),
 	})
 	if err != nil {
 		strikeClient.StrikeErrorLogger.Println(err)
-		return "", err
+		return 0, err
 	}
-	strikeCount, ok := result.Item["strikes"]
-	if ok {
-		if *strikeCount.N == "1" {
-			return "<@" + userID + "> has " + *strikeCount.N + " strike.", nil
-		}
-		return "<@" + userID + "> has " + *strikeCount.N + " strikes.", nil
+	strike := Strike{}
+	dynamodbattribute.UnmarshalMap(result.Item, &strike)
+	return strike.Strikes, nil
+}
+
+// ListStrikes retrieves the strikes of every user in a guild
+// strikes are keyed by guild and user together, so they can only be found by scanning
+func (strikeClient *StrikeClient) ListStrikes(ctx context.Context, guildID string) ([]Strike, error) {
+	strikes := make([]Strike, 0)
+	err := strikeClient.DynamoClient.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:        aws.String(assets.StrikeTableName),
+		FilterExpression: aws.String("begins_with(#i, :g)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#i": aws.String("guild!user"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":g": &dynamodb.AttributeValue{S: aws.String(guildID + "!")},
+		},
+	},
+		func(page *dynamodb.ScanOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				strike := Strike{}
+				dynamodbattribute.UnmarshalMap(item, &strike)
+				strikes = append(strikes, strike)
+			}
+			return !lastPage
+		})
+	if err != nil {
+		strikeClient.StrikeErrorLogger.Println(err)
+		return nil, err
 	}
-	return "<@" + userID + "> has no strikes.", nil
+	return strikes, nil
 }
 
 // BatchGetStrikesForUser retreives the number of strikes for up to 20 users
-func (strikeClient *StrikeClient) BatchGetStrikesForUser(guildID, channelID string, users []*discordgo.User) (interface{}, error) {
+func (strikeClient *StrikeClient) BatchGetStrikesForUser(ctx context.Context, guildID, channelID string, users []*discordgo.User) (interface{}, error) {
 	if len(users) > 20 {
 		return "You may only call get for up to 20 users. Please retry with fewer users.", nil
 	}
@@ -197,7 +248,7 @@ func (strikeClient *StrikeClient) BatchGetStrikesForUser(guildID, channelID stri
 		userIDxuserNameMap[v.ID] = v.Username
 		keys = append(keys, buildStrikeKey(guildID, v.ID))
 	}
-	result, err := strikeClient.DynamoClient.BatchGetItem(&dynamodb.BatchGetItemInput{
+	result, err := strikeClient.DynamoClient.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
 		RequestItems: map[string]*dynamodb.KeysAndAttributes{
 			assets.StrikeTableName: &dynamodb.KeysAndAttributes{
 				Keys: keys[:len(keys)],
@@ -253,15 +304,26 @@ func (strikeClient *StrikeClient) BatchGetStrikesForUser(guildID, channelID stri
 }
 
 // ClearStrikesForUser resets the strikes of a user
-func (strikeClient *StrikeClient) ClearStrikesForUser(guildID, channelID, userID string) (string, error) {
-	_, err := strikeClient.DynamoClient.DeleteItem(&dynamodb.DeleteItemInput{
-		TableName: aws.String(assets.StrikeTableName),
-		Key:       buildStrikeKey(guildID, userID),
+func (strikeClient *StrikeClient) ClearStrikesForUser(ctx context.Context, guildID, channelID, requester, userID string) (string, error) {
+	result, err := strikeClient.DynamoClient.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:    aws.String(assets.StrikeTableName),
+		Key:          buildStrikeKey(guildID, userID),
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
 	})
 	if err != nil {
 		strikeClient.StrikeErrorLogger.Println(err)
-		return "", nil
+		return "", err
 	}
+	previous := Strike{}
+	dynamodbattribute.UnmarshalMap(result.Attributes, &previous)
+	strikeClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  requester,
+		Action: "strike clear",
+		Target: "<@" + userID + ">",
+		Before: strconv.Itoa(previous.Strikes),
+		After:  "0",
+	})
 	return "<@" + userID + "> has no strikes.", nil
 }
 
@@ -280,12 +342,12 @@ func (strikeClient *StrikeClient) Help(session *discordgo.Session, channelID str
 				&discordgo.MessageEmbedField{
 					Name: "@user",
 					Value: "Issues a strike to all mentioned users.\n" +
-						"Usage: ```~strike @user1 @user2 ...```",
+						"Usage: ```" + CommandPrefix + "strike @user1 @user2 ...```",
 				},
 				&discordgo.MessageEmbedField{
 					Name: "get",
 					Value: "Retrieves the strike count of mentioned users. \n" +
-						"Usage: ```~strike get @user1 @user2 ...```",
+						"Usage: ```" + CommandPrefix + "strike get @user1 @user2 ...```",
 				},
 				&discordgo.MessageEmbedField{
 					Name:  "help",
@@ -0,0 +1,218 @@
+package flamingoservice
+
+import (
+	"FlamingoV2/flamingolog"
+	"context"
+	"errors"
+	"log"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	supervisorServiceName = "Supervisor"
+	// directMessageQueue is the queue shared by handlers outside of a guild
+	directMessageQueue = "direct"
+)
+
+var (
+	// ErrShuttingDown is returned when work is submitted after shutdown has begun
+	ErrShuttingDown = errors.New("shutting down")
+	// ErrQueueFull is returned when work is shed because its guild's queue is full
+	ErrQueueFull = errors.New("queue full")
+)
+
+// Supervisor runs handlers on a bounded pool of workers and tracks them so they can be drained on shutdown
+// each guild has its own bounded queue and worker limit, so one busy guild can't starve the rest
+// handlers receive a context that is cancelled if they outlive the shutdown deadline
+type Supervisor struct {
+	MetricsClient           *flamingolog.FlamingoMetricsClient
+	SupervisorServiceLogger *log.Logger
+	SupervisorErrorLogger   *log.Logger
+	// GuildWorkers is the number of handlers that may run concurrently for a single guild
+	GuildWorkers int
+	// GuildQueueSize is the number of handlers that may wait for a worker in a single guild
+	GuildQueueSize int
+	ctx            context.Context
+	cancel         context.CancelFunc
+	workers        chan struct{}
+	lock           sync.Mutex
+	closing        bool
+	queues         map[string]*guildQueue
+	inFlight       sync.WaitGroup
+	inFlightCount  int64
+	runningCount   int64
+}
+
+// guildQueue holds the handlers waiting to run for a guild
+type guildQueue struct {
+	handlers chan func(ctx context.Context)
+	workers  int
+	shed     int
+}
+
+// NewSupervisor constructs a Supervisor
+// workers bounds the number of handlers running at once across every guild
+func NewSupervisor(metricsClient *flamingolog.FlamingoMetricsClient, workers, guildWorkers, guildQueueSize int) *Supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Supervisor{
+		MetricsClient:           metricsClient,
+		SupervisorServiceLogger: flamingolog.BuildServiceLogger(supervisorServiceName),
+		SupervisorErrorLogger:   flamingolog.BuildServiceErrorLogger(supervisorServiceName),
+		GuildWorkers:            guildWorkers,
+		GuildQueueSize:          guildQueueSize,
+		ctx:                     ctx,
+		cancel:                  cancel,
+		workers:                 make(chan struct{}, workers),
+		queues:                  make(map[string]*guildQueue),
+	}
+}
+
+// Go queues handler to run on behalf of a guild
+// returns ErrShuttingDown or ErrQueueFull if the handler was rejected
+func (supervisor *Supervisor) Go(guildID string, handler func(ctx context.Context)) error {
+	if guildID == "" {
+		guildID = directMessageQueue
+	}
+	err := supervisor.enqueue(guildID, handler)
+	//Metrics are recorded after the lock is released so a metrics flush never stalls the queues
+	switch err {
+	case ErrShuttingDown:
+		supervisor.MetricsClient.PutMetric(supervisorServiceName, "Rejected", 1, "Count", nil)
+	case ErrQueueFull:
+		supervisor.MetricsClient.PutMetric(supervisorServiceName, "Shed", 1, "Count", nil)
+	}
+	return err
+}
+
+// enqueue adds handler to the guild's queue, starting a worker if the guild has capacity
+func (supervisor *Supervisor) enqueue(guildID string, handler func(ctx context.Context)) error {
+	supervisor.lock.Lock()
+	defer supervisor.lock.Unlock()
+	if supervisor.closing {
+		return ErrShuttingDown
+	}
+	queue, ok := supervisor.queues[guildID]
+	if !ok {
+		queue = &guildQueue{handlers: make(chan func(ctx context.Context), supervisor.GuildQueueSize)}
+		supervisor.queues[guildID] = queue
+	}
+	select {
+	case queue.handlers <- handler:
+	default:
+		//Only log the first handler shed so a flood doesn't flood the logs too
+		if queue.shed == 0 {
+			supervisor.SupervisorErrorLogger.Printf("Queue for %s is full, shedding load\n", guildID)
+		}
+		queue.shed++
+		return ErrQueueFull
+	}
+	supervisor.inFlight.Add(1)
+	atomic.AddInt64(&supervisor.inFlightCount, 1)
+	if queue.workers < supervisor.GuildWorkers {
+		queue.workers++
+		go supervisor.work(guildID, queue)
+	}
+	return nil
+}
+
+// work runs a guild's queued handlers until the queue is empty
+func (supervisor *Supervisor) work(guildID string, queue *guildQueue) {
+	for {
+		supervisor.lock.Lock()
+		var handler func(ctx context.Context)
+		select {
+		case handler = <-queue.handlers:
+		default:
+		}
+		if handler == nil {
+			queue.workers--
+			if queue.workers == 0 {
+				if queue.shed > 0 {
+					supervisor.SupervisorServiceLogger.Printf("Queue for %s drained after shedding %d handlers\n", guildID, queue.shed)
+				}
+				delete(supervisor.queues, guildID)
+			}
+			supervisor.lock.Unlock()
+			return
+		}
+		supervisor.lock.Unlock()
+		supervisor.run(handler)
+	}
+}
+
+// run runs a handler once a worker is free
+func (supervisor *Supervisor) run(handler func(ctx context.Context)) {
+	defer supervisor.inFlight.Done()
+	defer atomic.AddInt64(&supervisor.inFlightCount, -1)
+	supervisor.workers <- struct{}{}
+	defer func() { <-supervisor.workers }()
+	atomic.AddInt64(&supervisor.runningCount, 1)
+	defer atomic.AddInt64(&supervisor.runningCount, -1)
+	//Handlers still queued at the shutdown deadline are dropped rather than run with a cancelled context
+	if supervisor.ctx.Err() != nil {
+		return
+	}
+	//A panicking handler shouldn't take the rest of the bot down with it
+	defer func() {
+		if r := recover(); r != nil {
+			supervisor.SupervisorErrorLogger.Printf("Handler panicked: %v\n%s", r, debug.Stack())
+			supervisor.MetricsClient.PutMetric(supervisorServiceName, "Panic", 1, "Count", nil)
+		}
+	}()
+	handler(supervisor.ctx)
+}
+
+// ReportEvery publishes queue depth metrics on an interval until done is closed
+func (supervisor *Supervisor) ReportEvery(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			running := atomic.LoadInt64(&supervisor.runningCount)
+			queued := atomic.LoadInt64(&supervisor.inFlightCount) - running
+			supervisor.lock.Lock()
+			deepest := 0
+			for _, queue := range supervisor.queues {
+				if len(queue.handlers) > deepest {
+					deepest = len(queue.handlers)
+				}
+			}
+			supervisor.lock.Unlock()
+			supervisor.MetricsClient.PutMetric(supervisorServiceName, "Running", float64(running), "Count", nil)
+			supervisor.MetricsClient.PutMetric(supervisorServiceName, "QueueDepth", float64(queued), "Count", nil)
+			supervisor.MetricsClient.PutMetric(supervisorServiceName, "MaxGuildQueueDepth", float64(deepest), "Count", nil)
+		case <-done:
+			return
+		}
+	}
+}
+
+// Shutdown rejects new handlers and waits up to timeout for queued and in-flight handlers to finish
+// handlers still running at the deadline have their context cancelled and are abandoned
+func (supervisor *Supervisor) Shutdown(timeout time.Duration) error {
+	supervisor.lock.Lock()
+	supervisor.closing = true
+	supervisor.lock.Unlock()
+	supervisor.SupervisorServiceLogger.Printf("Draining %d in-flight handlers\n", atomic.LoadInt64(&supervisor.inFlightCount))
+
+	drained := make(chan struct{})
+	go func() {
+		supervisor.inFlight.Wait()
+		close(drained)
+	}()
+	defer supervisor.cancel()
+	select {
+	case <-drained:
+		supervisor.SupervisorServiceLogger.Println("All handlers finished")
+		return nil
+	case <-time.After(timeout):
+		remaining := atomic.LoadInt64(&supervisor.inFlightCount)
+		supervisor.MetricsClient.PutMetric(supervisorServiceName, "Abandoned", float64(remaining), "Count", nil)
+		return errors.New(strconv.FormatInt(remaining, 10) + " handlers did not finish within " + timeout.String())
+	}
+}
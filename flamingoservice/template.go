@@ -3,6 +3,7 @@ package flamingoservice
 import (
 	"FlamingoV2/assets"
 	"FlamingoV2/flamingolog"
+	"context"
 	"fmt"
 	"log"
 	"strings"
@@ -24,6 +25,8 @@ type TemplateClient struct {
 	DynamoClient          *dynamodb.DynamoDB
 	MetricsClient         *flamingolog.FlamingoMetricsClient
 	AuthClient            *AuthClient
+	AuditClient           *AuditClient
+	Cache                 *Cache
 	TemplateServiceLogger *log.Logger
 	TemplateErrorLogger   *log.Logger
 }
@@ -41,11 +44,13 @@ type TemplateKey struct {
 	Alias string `dynamodbav:"alias"`
 }
 
-func NewTemplateClient(dynamoClient *dynamodb.DynamoDB, metricsClient *flamingolog.FlamingoMetricsClient, authClient *AuthClient) *TemplateClient {
+func NewTemplateClient(dynamoClient *dynamodb.DynamoDB, metricsClient *flamingolog.FlamingoMetricsClient, authClient *AuthClient, auditClient *AuditClient, cache *Cache) *TemplateClient {
 	return &TemplateClient{
 		DynamoClient:          dynamoClient,
 		MetricsClient:         metricsClient,
 		AuthClient:            authClient,
+		AuditClient:           auditClient,
+		Cache:                 cache,
 		TemplateServiceLogger: flamingolog.BuildServiceLogger(templateServiceName),
 		TemplateErrorLogger:   flamingolog.BuildServiceErrorLogger(templateServiceName),
 	}
@@ -55,7 +60,7 @@ func (templateClient *TemplateClient) IsCommand(message string) bool {
 	return strings.HasPrefix(message, templateCommand)
 }
 
-func (templateClient *TemplateClient) Handle(session *discordgo.Session, message *discordgo.Message) {
+func (templateClient *TemplateClient) Handle(ctx context.Context, session *discordgo.Session, message *discordgo.Message) {
 	args := strings.SplitN(message.Content, " ", 4)[1:]
 	if len(args) < 1 {
 		templateClient.Help(session, message.ChannelID)
@@ -88,8 +93,8 @@ func (templateClient *TemplateClient) Handle(session *discordgo.Session, message
 			return
 		}
 
-		if templateClient.AuthClient.Authorize(message.GuildID, message.Author.ID, templateCommand, "get") {
-			template, err := templateClient.GetTemplate(message.GuildID, args[1], args[2])
+		if templateClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, templateCommand, "get") {
+			template, err := templateClient.GetTemplate(ctx, message.GuildID, args[1], args[2])
 			ParseServiceResponse(session, message.ChannelID, template, err)
 		} else {
 			ParseServiceResponse(session, message.ChannelID, "<@"+message.Author.ID+"> is unauthorized to issue that command!", nil)
@@ -114,8 +119,8 @@ func (templateClient *TemplateClient) Handle(session *discordgo.Session, message
 			return
 		}
 
-		if templateClient.AuthClient.Authorize(message.GuildID, message.Author.ID, templateCommand, "get") {
-			result, err := templateClient.SaveTemplate(message.GuildID, message.Author.ID, args[1], args[2])
+		if templateClient.AuthClient.Authorize(ctx, message.GuildID, message.ChannelID, message.Author.ID, message.Member, templateCommand, "get") {
+			result, err := templateClient.SaveTemplate(ctx, message.GuildID, message.Author.ID, args[1], args[2])
 			if result {
 				ParseServiceResponse(session, message.ChannelID, "Template with alias "+args[1]+" saved.", err)
 			} else {
@@ -143,10 +148,10 @@ func (templateClient *TemplateClient) Handle(session *discordgo.Session, message
 			return
 		}
 
-		result, err := templateClient.EditTemplate(message.GuildID, message.ChannelID, message.Author.ID, args[1], args[2])
+		result, err := templateClient.EditTemplate(ctx, message.GuildID, message.ChannelID, message.Author.ID, args[1], args[2])
 		ParseServiceResponse(session, message.ChannelID, result, err)
 	case "list":
-		templateClient.ListTemplate(session, message.GuildID, message.ChannelID, message.Author.ID)
+		templateClient.ListTemplate(ctx, session, message.GuildID, message.ChannelID, message.Author.ID)
 	case "help":
 		templateClient.Help(session, message.ChannelID)
 	default:
@@ -168,22 +173,22 @@ func (templateClient *TemplateClient) Help(session *discordgo.Session, channelID
 				{
 					Name: "get",
 					Value: "Retrieves a template by alias and substitutes the given string. Alias can be any alphanumeric string with no whitespace.\n" +
-						"Usage: ```~template get $alias $substitute```",
+						"Usage: ```" + CommandPrefix + "template get $alias $substitute```",
 				},
 				{
 					Name: "save",
 					Value: "Saves a new template by alias. Alias can be any alphanumeric string with no whitespace. Must include a %s substitute.\n" +
-						"Usage: ```~template save $alias $template```",
+						"Usage: ```" + CommandPrefix + "template save $alias $template```",
 				},
 				{
 					Name: "edit",
 					Value: "Updates an existing template by alias. The alias must exist and be authored by the caller for this to succeed.\n" +
-						"Usage: ```~template edit $alias $new_template```",
+						"Usage: ```" + CommandPrefix + "template edit $alias $new_template```",
 				},
 				{
 					Name: "list",
 					Value: "Retrieves a paginated list of templates saved to the current server and DMs them to the caller.\n" +
-						"Usage: ```~template list```",
+						"Usage: ```" + CommandPrefix + "template list```",
 				},
 				{
 					Name:  "help",
@@ -198,8 +203,8 @@ func (templateClient *TemplateClient) Help(session *discordgo.Session, channelID
 	}
 }
 
-func (templateClient *TemplateClient) SaveTemplate(guildID, owner, alias, template string) (bool, error) {
-	_, err := templateClient.DynamoClient.PutItem(&dynamodb.PutItemInput{
+func (templateClient *TemplateClient) SaveTemplate(ctx context.Context, guildID, owner, alias, template string) (bool, error) {
+	_, err := templateClient.DynamoClient.PutItemWithContext(ctx, &dynamodb.PutItemInput{
 		TableName:           aws.String(assets.PastaTableName),
 		Item:                buildTemplate(guildID, owner, alias, template),
 		ConditionExpression: aws.String("attribute_not_exists(guild) and attribute_not_exists(alias)"),
@@ -214,6 +219,7 @@ func (templateClient *TemplateClient) SaveTemplate(guildID, owner, alias, templa
 		}
 		return false, err
 	}
+	templateClient.Cache.Invalidate(guildID + "!" + alias)
 	return true, nil
 }
 
@@ -229,7 +235,7 @@ func buildTemplate(guildID, owner, alias, template string) map[string]*dynamodb.
 	return item
 }
 
-func (templateClient *TemplateClient) ListTemplate(session *discordgo.Session, guildID, channelID, userID string) {
+func (templateClient *TemplateClient) ListTemplate(ctx context.Context, session *discordgo.Session, guildID, channelID, userID string) {
 	var guildName string
 	guild, err := session.Guild(guildID)
 	if err != nil {
@@ -257,7 +263,7 @@ func (templateClient *TemplateClient) ListTemplate(session *discordgo.Session, g
 		Limit: aws.Int64(15),
 	}
 
-	err = templateClient.DynamoClient.QueryPages(templateList,
+	err = templateClient.DynamoClient.QueryPagesWithContext(ctx, templateList,
 		func(page *dynamodb.QueryOutput, lastPage bool) bool {
 			//List templates in chat
 			guildTemplateList := buildTemplatePage(page)
@@ -303,8 +309,8 @@ func buildTemplatePage(templates *dynamodb.QueryOutput) []*discordgo.MessageEmbe
 	return guildTemplateList[:]
 }
 
-func (templateClient *TemplateClient) EditTemplate(guildID, channelID, requester, alias, template string) (string, error) {
-	_, err := templateClient.DynamoClient.UpdateItem(&dynamodb.UpdateItemInput{
+func (templateClient *TemplateClient) EditTemplate(ctx context.Context, guildID, channelID, requester, alias, template string) (string, error) {
+	result, err := templateClient.DynamoClient.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
 		TableName:           aws.String(assets.PastaTableName),
 		Key:                 buildTemplateKey(guildID, alias),
 		ConditionExpression: aws.String("#o=:r"),
@@ -316,12 +322,13 @@ func (templateClient *TemplateClient) EditTemplate(guildID, channelID, requester
 			":r": {S: aws.String(requester)},
 		},
 		UpdateExpression: aws.String("SET template=:t"),
+		ReturnValues:     aws.String(dynamodb.ReturnValueUpdatedOld),
 	})
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok {
 			switch awsErr.Code() {
 			case dynamodb.ErrCodeConditionalCheckFailedException:
-				author, err := templateClient.DynamoClient.GetItem(&dynamodb.GetItemInput{
+				author, err := templateClient.DynamoClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 					TableName:            aws.String(assets.PastaTableName),
 					Key:                  buildTemplateKey(guildID, alias),
 					ProjectionExpression: aws.String("#o"),
@@ -343,11 +350,128 @@ func (templateClient *TemplateClient) EditTemplate(guildID, channelID, requester
 		templateClient.TemplateErrorLogger.Println(err)
 		return "", err
 	}
+	templateClient.Cache.Invalidate(guildID + "!" + alias)
+	previous := Template{}
+	dynamodbattribute.UnmarshalMap(result.Attributes, &previous)
+	templateClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  requester,
+		Action: "template edit",
+		Target: alias,
+		Before: previous.Template,
+		After:  template,
+	})
 	return fmt.Sprintf("Template with alias %s updated.", alias), nil
 }
 
-func (templateClient *TemplateClient) GetTemplate(guildID, alias, sub string) (string, error) {
-	result, err := templateClient.DynamoClient.GetItem(&dynamodb.GetItemInput{
+// LookupTemplate retrieves a guild template with its owner, nil if there is no template with the alias
+func (templateClient *TemplateClient) LookupTemplate(ctx context.Context, guildID, alias string) (*Template, error) {
+	result, err := templateClient.DynamoClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(assets.PastaTableName),
+		Key:       buildTemplateKey(guildID, alias),
+	})
+	if err != nil {
+		templateClient.TemplateErrorLogger.Println(err)
+		return nil, err
+	}
+	if _, ok := result.Item["template"]; !ok {
+		return nil, nil
+	}
+	template := &Template{}
+	dynamodbattribute.UnmarshalMap(result.Item, template)
+	return template, nil
+}
+
+// ListTemplates retrieves every template saved in a guild
+func (templateClient *TemplateClient) ListTemplates(ctx context.Context, guildID string) ([]Template, error) {
+	templates := make([]Template, 0)
+	err := templateClient.DynamoClient.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(assets.PastaTableName),
+		KeyConditionExpression: aws.String("guild=:g"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":g": {S: aws.String(guildID + "T")},
+		},
+	},
+		func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				template := Template{}
+				dynamodbattribute.UnmarshalMap(item, &template)
+				templates = append(templates, template)
+			}
+			return !lastPage
+		})
+	if err != nil {
+		templateClient.TemplateErrorLogger.Println(err)
+		return nil, err
+	}
+	return templates, nil
+}
+
+// ReplaceTemplate updates an existing template regardless of who authored it, returning false if it does not exist
+func (templateClient *TemplateClient) ReplaceTemplate(ctx context.Context, guildID, requester, alias, template string) (bool, error) {
+	result, err := templateClient.DynamoClient.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(assets.PastaTableName),
+		Key:                 buildTemplateKey(guildID, alias),
+		ConditionExpression: aws.String("attribute_exists(alias)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":t": {S: aws.String(template)},
+		},
+		UpdateExpression: aws.String("SET template=:t"),
+		ReturnValues:     aws.String(dynamodb.ReturnValueUpdatedOld),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+		templateClient.TemplateErrorLogger.Println(err)
+		return false, err
+	}
+	templateClient.Cache.Invalidate(guildID + "!" + alias)
+	previous := Template{}
+	dynamodbattribute.UnmarshalMap(result.Attributes, &previous)
+	templateClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  requester,
+		Action: "template edit",
+		Target: alias,
+		Before: previous.Template,
+		After:  template,
+	})
+	return true, nil
+}
+
+// DeleteTemplate deletes a template, returning false if it does not exist
+func (templateClient *TemplateClient) DeleteTemplate(ctx context.Context, guildID, requester, alias string) (bool, error) {
+	result, err := templateClient.DynamoClient.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:    aws.String(assets.PastaTableName),
+		Key:          buildTemplateKey(guildID, alias),
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if err != nil {
+		templateClient.TemplateErrorLogger.Println(err)
+		return false, err
+	}
+	templateClient.Cache.Invalidate(guildID + "!" + alias)
+	if len(result.Attributes) < 1 {
+		return false, nil
+	}
+	previous := Template{}
+	dynamodbattribute.UnmarshalMap(result.Attributes, &previous)
+	templateClient.AuditClient.Record(ctx, AuditEntry{
+		Guild:  guildID,
+		Actor:  requester,
+		Action: "template delete",
+		Target: alias,
+		Before: previous.Template,
+	})
+	return true, nil
+}
+
+func (templateClient *TemplateClient) GetTemplate(ctx context.Context, guildID, alias, sub string) (string, error) {
+	if template, ok := templateClient.Cache.Get(guildID + "!" + alias); ok {
+		return strings.Replace(template.(string), "%s", sub, -1), nil
+	}
+	result, err := templateClient.DynamoClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(assets.PastaTableName),
 		Key:       buildTemplateKey(guildID, alias),
 	})
@@ -359,6 +483,7 @@ func (templateClient *TemplateClient) GetTemplate(guildID, alias, sub string) (s
 
 	template, ok := result.Item["template"]
 	if ok {
+		templateClient.Cache.Put(guildID+"!"+alias, *template.S)
 		return strings.Replace(*template.S, "%s", sub, -1), nil
 	}
 
@@ -0,0 +1,330 @@
+package flamingoweb
+
+import (
+	"FlamingoV2/flamingolog"
+	"FlamingoV2/flamingoservice"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	dashboardServiceName = "Dashboard"
+	// sessionCookie holds the ID of a logged in user's session
+	sessionCookie = "flamingo_session"
+	// stateCookie holds the OAuth2 state of a login in progress
+	stateCookie = "flamingo_oauth_state"
+	// dashboardMaxFormBytes bounds submitted forms, the largest is the permission matrix
+	dashboardMaxFormBytes = 1 << 20
+)
+
+var (
+	snowflake = regexp.MustCompile(`^\d+$`)
+)
+
+// OAuth2Config identifies the dashboard to Discord
+type OAuth2Config struct {
+	ClientID     string
+	ClientSecret string
+	// DiscordAPI is the base URL of the Discord API, e.g. https://discord.com/api, overridable to log in against a mock
+	DiscordAPI string
+}
+
+// Dashboard is responsible for serving the web dashboard, where guild admins manage their guild's data
+// admins log in with Discord, and may manage the guilds Flamingo is in that they own or can manage
+type Dashboard struct {
+	URL                    *url.URL
+	OAuth2                 OAuth2Config
+	SessionTTL             time.Duration
+	Timeout                time.Duration
+	Shards                 *flamingoservice.Shards
+	MetricsClient          *flamingolog.FlamingoMetricsClient
+	AuthClient             *flamingoservice.AuthClient
+	PastaClient            *flamingoservice.PastaClient
+	TemplateClient         *flamingoservice.TemplateClient
+	StrikeClient           *flamingoservice.StrikeClient
+	AuditClient            *flamingoservice.AuditClient
+	HTTPClient             *http.Client
+	HTTPServer             *http.Server
+	DashboardServiceLogger *log.Logger
+	DashboardErrorLogger   *log.Logger
+	pages                  map[string]*template.Template
+	sessionsMutex          sync.Mutex
+	sessions               map[string]*webSession
+}
+
+// webSession is a logged in user and the guilds they may manage, as Discord reported them at login
+type webSession struct {
+	UserID   string
+	Username string
+	CSRF     string
+	Guilds   map[string]string
+	Expires  time.Time
+}
+
+// NewDashboard constructs a Dashboard listening on listen and served from publicURL, which Discord redirects back to
+func NewDashboard(listen string,
+	publicURL *url.URL,
+	oauth2 OAuth2Config,
+	sessionTTL, timeout time.Duration,
+	shards *flamingoservice.Shards,
+	metricsClient *flamingolog.FlamingoMetricsClient,
+	authClient *flamingoservice.AuthClient,
+	pastaClient *flamingoservice.PastaClient,
+	templateClient *flamingoservice.TemplateClient,
+	strikeClient *flamingoservice.StrikeClient,
+	auditClient *flamingoservice.AuditClient) *Dashboard {
+	dashboard := &Dashboard{
+		URL:                    publicURL,
+		OAuth2:                 oauth2,
+		SessionTTL:             sessionTTL,
+		Timeout:                timeout,
+		Shards:                 shards,
+		MetricsClient:          metricsClient,
+		AuthClient:             authClient,
+		PastaClient:            pastaClient,
+		TemplateClient:         templateClient,
+		StrikeClient:           strikeClient,
+		AuditClient:            auditClient,
+		HTTPClient:             &http.Client{Timeout: 10 * time.Second},
+		DashboardServiceLogger: flamingolog.BuildServiceLogger(dashboardServiceName),
+		DashboardErrorLogger:   flamingolog.BuildServiceErrorLogger(dashboardServiceName),
+		pages:                  parsePages(),
+		sessions:               make(map[string]*webSession),
+	}
+	dashboard.HTTPServer = &http.Server{
+		Addr:              listen,
+		Handler:           dashboard,
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          dashboard.DashboardErrorLogger,
+	}
+	return dashboard
+}
+
+// ListenAndServe serves the dashboard until it is shut down
+func (dashboard *Dashboard) ListenAndServe() error {
+	dashboard.DashboardServiceLogger.Println("Listening on " + dashboard.HTTPServer.Addr + ", served from " + dashboard.URL.String())
+	err := dashboard.HTTPServer.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting requests and waits up to timeout for in-flight requests to finish
+func (dashboard *Dashboard) Shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return dashboard.HTTPServer.Shutdown(ctx)
+}
+
+// ServeHTTP routes a request to the page it names, requiring a session for every page but login
+func (dashboard *Dashboard) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src https:; form-action 'self'; frame-ancestors 'none'")
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.Header().Set("Referrer-Policy", "same-origin")
+	ctx, cancel := context.WithTimeout(request.Context(), dashboard.Timeout)
+	defer cancel()
+	request = request.WithContext(ctx)
+	request.Body = http.MaxBytesReader(writer, request.Body, dashboardMaxFormBytes)
+
+	switch request.URL.Path {
+	case "/login":
+		dashboard.login(writer, request)
+		return
+	case "/callback":
+		dashboard.callback(writer, request)
+		return
+	}
+	session := dashboard.currentSession(request)
+	if request.URL.Path == "/" {
+		if session == nil {
+			dashboard.render(writer, http.StatusOK, "login", pageData{Title: "Log in"})
+			return
+		}
+		dashboard.guilds(writer, request, session)
+		return
+	}
+	if session == nil {
+		http.Redirect(writer, request, "/", http.StatusSeeOther)
+		return
+	}
+	if request.URL.Path == "/logout" {
+		dashboard.logout(writer, request, session)
+		return
+	}
+
+	segments := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+	if len(segments) < 2 || segments[0] != "guilds" {
+		dashboard.renderError(writer, http.StatusNotFound, "That page doesn't exist.")
+		return
+	}
+	guild, ok := dashboard.manageableGuild(session, segments[1])
+	if !ok {
+		dashboard.renderError(writer, http.StatusForbidden, "You can't manage that server, or Flamingo isn't in it.")
+		return
+	}
+	if request.Method == http.MethodPost && !validCSRF(session, request) {
+		dashboard.renderError(writer, http.StatusForbidden, "That form has expired, please go back and try again.")
+		return
+	}
+	//The guilds a session may manage are as Discord reported them at login, so changes are only made by current managers
+	if request.Method == http.MethodPost && !dashboard.canManage(ctx, guild, session.UserID) {
+		dashboard.MetricsClient.Count(dashboardServiceName, "NoLongerManager", nil)
+		dashboard.renderError(writer, http.StatusForbidden, "You can no longer manage that server.")
+		return
+	}
+	dashboard.MetricsClient.Count(dashboardServiceName, "Request", nil)
+	page := ""
+	if len(segments) > 2 {
+		page = segments[2]
+	}
+	switch {
+	case len(segments) == 2:
+		http.Redirect(writer, request, "/guilds/"+guild.ID+"/pastas", http.StatusSeeOther)
+	case len(segments) == 3 && page == "pastas" && request.Method == http.MethodGet:
+		dashboard.pastas(writer, request, session, guild)
+	case len(segments) == 3 && page == "strikes" && request.Method == http.MethodGet:
+		dashboard.strikes(writer, request, session, guild)
+	case len(segments) == 3 && page == "permissions" && request.Method == http.MethodGet:
+		dashboard.permissions(writer, request, session, guild)
+	case len(segments) == 3 && page == "permissions" && request.Method == http.MethodPost:
+		dashboard.savePermissions(writer, request, session, guild)
+	default:
+		dashboard.renderError(writer, http.StatusNotFound, "That page doesn't exist.")
+	}
+}
+
+// manageableGuild resolves a guild the session may manage, provided Flamingo is still in it
+func (dashboard *Dashboard) manageableGuild(session *webSession, guildID string) (*discordgo.Guild, bool) {
+	if _, ok := session.Guilds[guildID]; !ok {
+		return nil, false
+	}
+	guild, err := dashboard.Shards.Guild(guildID)
+	if err != nil {
+		return nil, false
+	}
+	return guild, true
+}
+
+// canManage checks a user owns a guild, or has a role granting Administrator or Manage Server in it
+func (dashboard *Dashboard) canManage(ctx context.Context, guild *discordgo.Guild, userID string) bool {
+	return dashboard.hasGuildPermission(ctx, guild, userID, discordgo.PermissionAdministrator|discordgo.PermissionManageServer)
+}
+
+// canEditAuth checks a user may change who can use auth, which Manage Server alone doesn't allow.
+// The owner and administrators may, as may members allowed auth set while permission rules are enforced.
+func (dashboard *Dashboard) canEditAuth(ctx context.Context, guild *discordgo.Guild, userID string) bool {
+	if dashboard.hasGuildPermission(ctx, guild, userID, discordgo.PermissionAdministrator) {
+		return true
+	}
+	return dashboard.AuthClient.Enforce && dashboard.AuthClient.Authorize(ctx, guild.ID, "", userID, nil, authCommand, "set")
+}
+
+// hasGuildPermission checks a user owns a guild, or has a role granting any of permissions in it
+// members are resolved from state, asking Discord if they aren't cached; failures deny rather than allow
+func (dashboard *Dashboard) hasGuildPermission(ctx context.Context, guild *discordgo.Guild, userID string, permissions int64) bool {
+	if guild.OwnerID == userID {
+		return true
+	}
+	member, err := dashboard.Shards.Member(guild.ID, userID)
+	if err != nil {
+		if member, err = dashboard.Shards.Primary().GuildMember(guild.ID, userID, discordgo.WithContext(ctx)); err != nil {
+			dashboard.DashboardErrorLogger.Println(err)
+			return false
+		}
+	}
+	var granted int64
+	for _, role := range guild.Roles {
+		//The @everyone role shares the guild's ID and applies to every member
+		if role.ID == guild.ID || containsString(member.Roles, role.ID) {
+			granted |= role.Permissions
+		}
+	}
+	return granted&permissions != 0
+}
+
+// startSession records a logged in user, sweeping expired sessions as it goes
+func (dashboard *Dashboard) startSession(writer http.ResponseWriter, session *webSession) {
+	id := randomToken()
+	session.CSRF = randomToken()
+	session.Expires = time.Now().Add(dashboard.SessionTTL)
+	dashboard.sessionsMutex.Lock()
+	now := time.Now()
+	for existing, expiring := range dashboard.sessions {
+		if now.After(expiring.Expires) {
+			delete(dashboard.sessions, existing)
+		}
+	}
+	dashboard.sessions[id] = session
+	dashboard.sessionsMutex.Unlock()
+	http.SetCookie(writer, dashboard.cookie(sessionCookie, id, dashboard.SessionTTL))
+}
+
+// currentSession resolves the session of a request, nil if it has none or it expired
+func (dashboard *Dashboard) currentSession(request *http.Request) *webSession {
+	cookie, err := request.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	dashboard.sessionsMutex.Lock()
+	defer dashboard.sessionsMutex.Unlock()
+	session, ok := dashboard.sessions[cookie.Value]
+	if !ok || time.Now().After(session.Expires) {
+		delete(dashboard.sessions, cookie.Value)
+		return nil
+	}
+	return session
+}
+
+// logout ends a session, only by form so other sites can't log users out
+func (dashboard *Dashboard) logout(writer http.ResponseWriter, request *http.Request, session *webSession) {
+	if request.Method != http.MethodPost || !validCSRF(session, request) {
+		http.Redirect(writer, request, "/", http.StatusSeeOther)
+		return
+	}
+	if cookie, err := request.Cookie(sessionCookie); err == nil {
+		dashboard.sessionsMutex.Lock()
+		delete(dashboard.sessions, cookie.Value)
+		dashboard.sessionsMutex.Unlock()
+	}
+	http.SetCookie(writer, dashboard.cookie(sessionCookie, "", -1))
+	http.Redirect(writer, request, "/", http.StatusSeeOther)
+}
+
+// cookie builds a cookie only sent to the dashboard, over HTTPS if the dashboard is served over HTTPS
+func (dashboard *Dashboard) cookie(name, value string, maxAge time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   dashboard.URL.Scheme == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func validCSRF(session *webSession, request *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(request.PostFormValue("csrf")), []byte(session.CSRF)) == 1
+}
+
+func randomToken() string {
+	token := make([]byte, 32)
+	//crypto/rand only fails if the OS has no source of randomness, nothing could be served securely
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(token)
+}
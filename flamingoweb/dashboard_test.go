@@ -0,0 +1,216 @@
+package flamingoweb
+
+import (
+	"FlamingoV2/flamingolog"
+	"FlamingoV2/flamingoservice"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	testGuildID = "200"
+	testUserID  = "100"
+	testCode    = "code"
+	testToken   = "token"
+)
+
+// mockDiscord serves the OAuth2 endpoints the dashboard logs in with, reporting userGuilds for the logged in user
+func mockDiscord(t *testing.T, userGuilds []map[string]interface{}) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		if request.URL.Path == "/oauth2/token" {
+			if request.Method != http.MethodPost || request.PostFormValue("code") != testCode ||
+				request.PostFormValue("client_secret") != "secret" {
+				writer.WriteHeader(http.StatusUnauthorized)
+				writer.Write([]byte(`{"error":"invalid_grant"}`))
+				return
+			}
+			json.NewEncoder(writer).Encode(map[string]string{"access_token": testToken, "token_type": "Bearer"})
+			return
+		}
+		if request.Header.Get("Authorization") != "Bearer "+testToken {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch request.URL.Path {
+		case "/users/@me":
+			json.NewEncoder(writer).Encode(map[string]string{"id": testUserID, "username": "admin"})
+		case "/users/@me/guilds":
+			json.NewEncoder(writer).Encode(userGuilds)
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+// testDashboard builds a dashboard logging in against discordAPI, whose state holds guild and its members
+func testDashboard(t *testing.T, discordAPI string, guild *discordgo.Guild, members ...*discordgo.Member) *Dashboard {
+	t.Helper()
+	session, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guild != nil {
+		if err := session.State.GuildAdd(guild); err != nil {
+			t.Fatal(err)
+		}
+		for _, member := range members {
+			if err := session.State.MemberAdd(member); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	shards := &flamingoservice.Shards{
+		Count:    1,
+		IDs:      []int{0},
+		Sessions: map[int]*discordgo.Session{0: session},
+	}
+	publicURL, _ := url.Parse("http://dashboard.test")
+	return NewDashboard(":0", publicURL,
+		OAuth2Config{ClientID: "client", ClientSecret: "secret", DiscordAPI: discordAPI},
+		time.Hour, 10*time.Second, shards, &flamingolog.FlamingoMetricsClient{Local: true},
+		nil, nil, nil, nil, nil)
+}
+
+// testGuild builds a guild owned by someone else, with a role granting permissions
+func testGuild(permissions int64) *discordgo.Guild {
+	return &discordgo.Guild{
+		ID:      testGuildID,
+		Name:    "Test",
+		OwnerID: "999",
+		Roles: []*discordgo.Role{
+			&discordgo.Role{ID: testGuildID, Name: "@everyone"},
+			&discordgo.Role{ID: "300", Name: "Mods", Permissions: permissions},
+		},
+	}
+}
+
+func callback(dashboard *Dashboard, state, cookieState, code string) *httptest.ResponseRecorder {
+	query := url.Values{"state": {state}, "code": {code}}
+	request := httptest.NewRequest(http.MethodGet, "/callback?"+query.Encode(), nil)
+	request.AddCookie(&http.Cookie{Name: stateCookie, Value: cookieState})
+	recorder := httptest.NewRecorder()
+	dashboard.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestCallbackRejectsStateMismatch(t *testing.T) {
+	discord := mockDiscord(t, nil)
+	defer discord.Close()
+	dashboard := testDashboard(t, discord.URL, nil)
+
+	recorder := callback(dashboard, "forged", "expected", testCode)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+	if len(dashboard.sessions) != 0 {
+		t.Fatalf("expected no session, got %d", len(dashboard.sessions))
+	}
+}
+
+func TestCallbackRejectsFailedExchange(t *testing.T) {
+	discord := mockDiscord(t, nil)
+	defer discord.Close()
+	dashboard := testDashboard(t, discord.URL, nil)
+
+	recorder := callback(dashboard, "state", "state", "wrong")
+	if recorder.Code != http.StatusBadGateway {
+		t.Fatalf("expected %d, got %d", http.StatusBadGateway, recorder.Code)
+	}
+	if len(dashboard.sessions) != 0 {
+		t.Fatalf("expected no session, got %d", len(dashboard.sessions))
+	}
+}
+
+func TestCallbackOnlyKeepsManagedGuilds(t *testing.T) {
+	discord := mockDiscord(t, []map[string]interface{}{
+		{"id": "1", "name": "Owned", "owner": true, "permissions": "0"},
+		{"id": "2", "name": "Administered", "owner": false, "permissions": strconv.FormatInt(discordgo.PermissionAdministrator, 10)},
+		{"id": "3", "name": "Managed", "owner": false, "permissions": strconv.FormatInt(discordgo.PermissionManageServer, 10)},
+		{"id": "4", "name": "Member", "owner": false, "permissions": strconv.FormatInt(discordgo.PermissionSendMessages, 10)},
+	})
+	defer discord.Close()
+	dashboard := testDashboard(t, discord.URL, nil)
+
+	recorder := callback(dashboard, "state", "state", testCode)
+	if recorder.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d, got %d: %s", http.StatusSeeOther, recorder.Code, recorder.Body.String())
+	}
+	if len(dashboard.sessions) != 1 {
+		t.Fatalf("expected one session, got %d", len(dashboard.sessions))
+	}
+	for _, session := range dashboard.sessions {
+		if session.UserID != testUserID {
+			t.Errorf("expected user %s, got %s", testUserID, session.UserID)
+		}
+		for _, id := range []string{"1", "2", "3"} {
+			if _, ok := session.Guilds[id]; !ok {
+				t.Errorf("expected guild %s to be manageable", id)
+			}
+		}
+		if _, ok := session.Guilds["4"]; ok {
+			t.Error("expected guild 4 not to be manageable")
+		}
+	}
+}
+
+func TestPostRequiresCurrentManager(t *testing.T) {
+	//The member no longer has the Mods role that let them manage the guild at login
+	dashboard := testDashboard(t, "", testGuild(discordgo.PermissionManageServer),
+		&discordgo.Member{GuildID: testGuildID, User: &discordgo.User{ID: testUserID}})
+	session := &webSession{UserID: testUserID, CSRF: "csrf", Guilds: map[string]string{testGuildID: "Test"}, Expires: time.Now().Add(time.Hour)}
+	dashboard.sessions["session"] = session
+
+	request := httptest.NewRequest(http.MethodPost, "/guilds/"+testGuildID+"/permissions", strings.NewReader(url.Values{"csrf": {"csrf"}}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.AddCookie(&http.Cookie{Name: sessionCookie, Value: "session"})
+	recorder := httptest.NewRecorder()
+	dashboard.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), "no longer manage") {
+		t.Fatalf("expected %d for a former manager, got %d: %s", http.StatusForbidden, recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestCanManage(t *testing.T) {
+	guild := testGuild(discordgo.PermissionManageServer)
+	dashboard := testDashboard(t, "", guild,
+		&discordgo.Member{GuildID: testGuildID, User: &discordgo.User{ID: testUserID}, Roles: []string{"300"}},
+		&discordgo.Member{GuildID: testGuildID, User: &discordgo.User{ID: "101"}})
+	if !dashboard.canManage(context.Background(), guild, "999") {
+		t.Error("expected the owner to manage the guild")
+	}
+	if !dashboard.canManage(context.Background(), guild, testUserID) {
+		t.Error("expected a member with a managing role to manage the guild")
+	}
+	if dashboard.canManage(context.Background(), guild, "101") {
+		t.Error("expected a member without a managing role not to manage the guild")
+	}
+}
+
+func TestCanEditAuth(t *testing.T) {
+	guild := testGuild(discordgo.PermissionManageServer)
+	guild.Roles = append(guild.Roles, &discordgo.Role{ID: "301", Name: "Admins", Permissions: discordgo.PermissionAdministrator})
+	dashboard := testDashboard(t, "", guild,
+		&discordgo.Member{GuildID: testGuildID, User: &discordgo.User{ID: testUserID}, Roles: []string{"300"}},
+		&discordgo.Member{GuildID: testGuildID, User: &discordgo.User{ID: "102"}, Roles: []string{"301"}})
+	dashboard.AuthClient = &flamingoservice.AuthClient{}
+	if !dashboard.canEditAuth(context.Background(), guild, "999") {
+		t.Error("expected the owner to edit auth")
+	}
+	if !dashboard.canEditAuth(context.Background(), guild, "102") {
+		t.Error("expected an administrator to edit auth")
+	}
+	if dashboard.canEditAuth(context.Background(), guild, testUserID) {
+		t.Error("expected a member who can only manage the server not to edit auth")
+	}
+}
@@ -0,0 +1,141 @@
+package flamingoweb
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// oauthScopes are the least Discord must share to know who a user is and which guilds they manage
+	oauthScopes = "identify guilds"
+	// oauthStateTTL bounds how long a user may take to authorize the dashboard on Discord
+	oauthStateTTL = 10 * time.Minute
+)
+
+// oauthToken is Discord's reply to a code exchange
+type oauthToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+// login sends a user to Discord to authorize the dashboard, remembering the state to expect back
+func (dashboard *Dashboard) login(writer http.ResponseWriter, request *http.Request) {
+	state := randomToken()
+	http.SetCookie(writer, dashboard.cookie(stateCookie, state, oauthStateTTL))
+	query := url.Values{
+		"client_id":     {dashboard.OAuth2.ClientID},
+		"redirect_uri":  {dashboard.redirectURI()},
+		"response_type": {"code"},
+		"scope":         {oauthScopes},
+		"state":         {state},
+		"prompt":        {"none"},
+	}
+	http.Redirect(writer, request, dashboard.OAuth2.DiscordAPI+"/oauth2/authorize?"+query.Encode(), http.StatusFound)
+}
+
+// callback completes a login once Discord redirects back, starting a session for the guilds the user may manage
+func (dashboard *Dashboard) callback(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	state, err := request.Cookie(stateCookie)
+	if err != nil || query.Get("state") == "" ||
+		subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state.Value)) != 1 {
+		dashboard.renderError(writer, http.StatusBadRequest, "That login has expired, please try again.")
+		return
+	}
+	http.SetCookie(writer, dashboard.cookie(stateCookie, "", -1))
+	if query.Get("error") != "" || query.Get("code") == "" {
+		dashboard.renderError(writer, http.StatusForbidden, "Flamingo wasn't authorized to log you in.")
+		return
+	}
+	ctx := request.Context()
+	token, err := dashboard.exchangeCode(ctx, query.Get("code"))
+	if err != nil {
+		dashboard.DashboardErrorLogger.Println(err)
+		dashboard.MetricsClient.Count(dashboardServiceName, "LoginFailed", nil)
+		dashboard.renderError(writer, http.StatusBadGateway, "Discord couldn't log you in, please try again later.")
+		return
+	}
+	user := discordgo.User{}
+	if err := dashboard.discordGet(ctx, token, "/users/@me", &user); err != nil {
+		dashboard.DashboardErrorLogger.Println(err)
+		dashboard.MetricsClient.Count(dashboardServiceName, "LoginFailed", nil)
+		dashboard.renderError(writer, http.StatusBadGateway, "Discord couldn't log you in, please try again later.")
+		return
+	}
+	userGuilds := []discordgo.UserGuild{}
+	if err := dashboard.discordGet(ctx, token, "/users/@me/guilds", &userGuilds); err != nil {
+		dashboard.DashboardErrorLogger.Println(err)
+		dashboard.MetricsClient.Count(dashboardServiceName, "LoginFailed", nil)
+		dashboard.renderError(writer, http.StatusBadGateway, "Discord couldn't log you in, please try again later.")
+		return
+	}
+	guilds := make(map[string]string)
+	for _, guild := range userGuilds {
+		if guild.Owner || guild.Permissions&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) != 0 {
+			guilds[guild.ID] = guild.Name
+		}
+	}
+	dashboard.startSession(writer, &webSession{UserID: user.ID, Username: user.Username, Guilds: guilds})
+	dashboard.DashboardServiceLogger.Println("User " + user.ID + " logged in")
+	dashboard.MetricsClient.Count(dashboardServiceName, "Login", nil)
+	http.Redirect(writer, request, "/", http.StatusSeeOther)
+}
+
+// exchangeCode trades an authorization code for an access token
+func (dashboard *Dashboard) exchangeCode(ctx context.Context, code string) (string, error) {
+	form := url.Values{
+		"client_id":     {dashboard.OAuth2.ClientID},
+		"client_secret": {dashboard.OAuth2.ClientSecret},
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {dashboard.redirectURI()},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, dashboard.OAuth2.DiscordAPI+"/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	token := oauthToken{}
+	if err := dashboard.doJSON(request, &token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("token exchange returned no access token")
+	}
+	return token.AccessToken, nil
+}
+
+// discordGet fetches a Discord API resource as the logged in user
+func (dashboard *Dashboard) discordGet(ctx context.Context, token, path string, body interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, dashboard.OAuth2.DiscordAPI+path, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return dashboard.doJSON(request, body)
+}
+
+func (dashboard *Dashboard) doJSON(request *http.Request, body interface{}) error {
+	request.Header.Set("Accept", "application/json")
+	response, err := dashboard.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s returned %s", request.Method, request.URL.Path, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(body)
+}
+
+func (dashboard *Dashboard) redirectURI() string {
+	return strings.TrimSuffix(dashboard.URL.String(), "/") + "/callback"
+}
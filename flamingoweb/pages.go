@@ -0,0 +1,461 @@
+package flamingoweb
+
+import (
+	"FlamingoV2/flamingoservice"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// strikeHistoryCount is how many strike audit entries the strikes page shows
+	strikeHistoryCount = 50
+	// ruleField prefixes the name of every cell of the permission matrix form
+	ruleField = "rule:"
+	// authCommand is the command whose rules decide who may change permission rules
+	authCommand = "auth"
+)
+
+// pageData is what every page template is rendered with, Content holds the page's own view
+type pageData struct {
+	Title   string
+	User    string
+	CSRF    string
+	Guild   *discordgo.Guild
+	Page    string
+	Message string
+	Content interface{}
+}
+
+type guildLink struct {
+	ID   string
+	Name string
+}
+
+type contentRow struct {
+	Alias   string
+	Owner   string
+	Content string
+}
+
+type contentView struct {
+	Query     string
+	Pastas    []contentRow
+	Templates []contentRow
+}
+
+type strikeRow struct {
+	Rank    int
+	UserID  string
+	User    string
+	Strikes int
+}
+
+type historyRow struct {
+	Time   string
+	Action string
+	Actor  string
+	Target string
+	Change string
+}
+
+type strikesView struct {
+	User    string
+	Leaders []strikeRow
+	History []historyRow
+}
+
+type permissionColumn struct {
+	Kind string
+	ID   string
+	Name string
+}
+
+type permissionCell struct {
+	Name  string
+	Value string
+}
+
+type permissionRow struct {
+	Label  string
+	Locked bool
+	Cells  []permissionCell
+}
+
+type permissionsView struct {
+	Permissive string
+	// AuthLocked disables the auth rows and the permissive flag for managers who can't change who may use auth
+	AuthLocked bool
+	Columns    []permissionColumn
+	Rows       []permissionRow
+}
+
+// guilds lists the guilds a user may manage that Flamingo is in
+func (dashboard *Dashboard) guilds(writer http.ResponseWriter, request *http.Request, session *webSession) {
+	links := make([]guildLink, 0, len(session.Guilds))
+	for id := range session.Guilds {
+		if guild, ok := dashboard.manageableGuild(session, id); ok {
+			links = append(links, guildLink{ID: guild.ID, Name: guild.Name})
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return strings.ToLower(links[i].Name) < strings.ToLower(links[j].Name)
+	})
+	dashboard.render(writer, http.StatusOK, "guilds", pageData{
+		Title:   "Servers",
+		User:    session.Username,
+		CSRF:    session.CSRF,
+		Content: links,
+	})
+}
+
+// pastas lists a guild's pastas and templates, narrowed to those whose alias or content contain ?q=
+func (dashboard *Dashboard) pastas(writer http.ResponseWriter, request *http.Request, session *webSession, guild *discordgo.Guild) {
+	ctx := request.Context()
+	query := strings.TrimSpace(request.URL.Query().Get("q"))
+	pastas, err := dashboard.PastaClient.ListPastas(ctx, guild.ID)
+	if err != nil {
+		dashboard.renderFailure(writer, err)
+		return
+	}
+	templates, err := dashboard.TemplateClient.ListTemplates(ctx, guild.ID)
+	if err != nil {
+		dashboard.renderFailure(writer, err)
+		return
+	}
+	view := contentView{Query: query, Pastas: make([]contentRow, 0, len(pastas)), Templates: make([]contentRow, 0, len(templates))}
+	for _, pasta := range pastas {
+		if matchesQuery(query, pasta.Alias, pasta.Pasta) {
+			view.Pastas = append(view.Pastas, contentRow{Alias: pasta.Alias, Owner: dashboard.displayName(guild.ID, pasta.Owner), Content: pasta.Pasta})
+		}
+	}
+	for _, template := range templates {
+		if matchesQuery(query, template.Alias, template.Template) {
+			view.Templates = append(view.Templates, contentRow{Alias: template.Alias, Owner: dashboard.displayName(guild.ID, template.Owner), Content: template.Template})
+		}
+	}
+	dashboard.render(writer, http.StatusOK, "pastas", pageData{
+		Title:   "Pastas and templates",
+		User:    session.Username,
+		CSRF:    session.CSRF,
+		Guild:   guild,
+		Page:    "pastas",
+		Content: view,
+	})
+}
+
+// strikes ranks a guild's users by strikes and shows recent strike history, narrowed to one user by ?user=
+func (dashboard *Dashboard) strikes(writer http.ResponseWriter, request *http.Request, session *webSession, guild *discordgo.Guild) {
+	ctx := request.Context()
+	strikes, err := dashboard.StrikeClient.ListStrikes(ctx, guild.ID)
+	if err != nil {
+		dashboard.renderFailure(writer, err)
+		return
+	}
+	sort.SliceStable(strikes, func(i, j int) bool {
+		return strikes[i].Strikes > strikes[j].Strikes
+	})
+	view := strikesView{Leaders: make([]strikeRow, 0, len(strikes))}
+	for _, strike := range strikes {
+		if strike.Strikes == 0 {
+			continue
+		}
+		userID := strings.TrimPrefix(strike.ID, guild.ID+"!")
+		view.Leaders = append(view.Leaders, strikeRow{
+			Rank:    len(view.Leaders) + 1,
+			UserID:  userID,
+			User:    dashboard.displayName(guild.ID, userID),
+			Strikes: strike.Strikes,
+		})
+	}
+
+	filter := "strike"
+	userID := request.URL.Query().Get("user")
+	if snowflake.MatchString(userID) {
+		filter = userID
+		view.User = dashboard.displayName(guild.ID, userID)
+	}
+	entries, err := dashboard.AuditClient.List(ctx, guild.ID, filter, strikeHistoryCount)
+	if err != nil {
+		dashboard.renderFailure(writer, err)
+		return
+	}
+	view.History = make([]historyRow, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Action, "strike") || (view.User != "" && !strings.Contains(entry.Target, userID)) {
+			continue
+		}
+		row := historyRow{
+			Time:   time.Unix(entry.Timestamp, 0).UTC().Format("2006-01-02 15:04 MST"),
+			Action: entry.Action,
+			Actor:  dashboard.displayName(guild.ID, entry.Actor),
+			Target: dashboard.displayName(guild.ID, strings.Trim(entry.Target, "<@!>")),
+		}
+		if entry.Before != "" || entry.After != "" {
+			row.Change = entry.Before + " → " + entry.After
+		}
+		view.History = append(view.History, row)
+	}
+	dashboard.render(writer, http.StatusOK, "strikes", pageData{
+		Title:   "Strikes",
+		User:    session.Username,
+		CSRF:    session.CSRF,
+		Guild:   guild,
+		Page:    "strikes",
+		Content: view,
+	})
+}
+
+// permissions shows a guild's permission rules as a matrix of commands against roles and users
+// a user without rules gets a column by naming them with ?user=
+func (dashboard *Dashboard) permissions(writer http.ResponseWriter, request *http.Request, session *webSession, guild *discordgo.Guild) {
+	ctx := request.Context()
+	existing, err := dashboard.listRules(request, guild.ID)
+	if err != nil {
+		dashboard.renderFailure(writer, err)
+		return
+	}
+	permissive := ""
+	value, err := dashboard.AuthClient.GetPermissiveFlagValue(ctx, guild.ID)
+	if err == nil {
+		permissive = strconv.FormatBool(value)
+	} else if !errors.Is(err, flamingoservice.ErrPermissiveFlagNotFound) {
+		dashboard.renderFailure(writer, err)
+		return
+	}
+
+	roles := make([]*discordgo.Role, len(guild.Roles))
+	copy(roles, guild.Roles)
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Position > roles[j].Position
+	})
+	view := permissionsView{Permissive: permissive, AuthLocked: !dashboard.canEditAuth(ctx, guild, session.UserID)}
+	for _, role := range roles {
+		view.Columns = append(view.Columns, permissionColumn{Kind: "role", ID: role.ID, Name: "@" + strings.TrimPrefix(role.Name, "@")})
+	}
+	users := make(map[string]bool)
+	if userID := request.URL.Query().Get("user"); snowflake.MatchString(userID) {
+		users[userID] = true
+	}
+	for key := range existing {
+		parts := strings.Split(strings.TrimPrefix(key, ruleField), ":")
+		if parts[2] == "user" {
+			users[parts[3]] = true
+		}
+	}
+	userIDs := make([]string, 0, len(users))
+	for userID := range users {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+	for _, userID := range userIDs {
+		view.Columns = append(view.Columns, permissionColumn{Kind: "user", ID: userID, Name: dashboard.displayName(guild.ID, userID)})
+	}
+
+	for _, key := range commandActions() {
+		row := permissionRow{Label: key[0] + " " + key[1], Locked: view.AuthLocked && key[0] == authCommand}
+		if key[1] == "" {
+			row.Label = key[0] + " (every action)"
+		}
+		for _, column := range view.Columns {
+			cell := permissionCell{Name: ruleKey(key[0], key[1], column.Kind, column.ID)}
+			if allow, ok := existing[cell.Name]; ok {
+				cell.Value = formatRule(allow)
+			}
+			row.Cells = append(row.Cells, cell)
+		}
+		view.Rows = append(view.Rows, row)
+	}
+
+	message := ""
+	if saved := request.URL.Query().Get("saved"); saved != "" {
+		message = "Saved " + saved + " change(s)."
+	}
+	dashboard.render(writer, http.StatusOK, "permissions", pageData{
+		Title:   "Permissions",
+		User:    session.Username,
+		CSRF:    session.CSRF,
+		Guild:   guild,
+		Page:    "permissions",
+		Message: message,
+		Content: view,
+	})
+}
+
+// savePermissions applies the permission matrix form, changing only the rules that differ from those stored
+func (dashboard *Dashboard) savePermissions(writer http.ResponseWriter, request *http.Request, session *webSession, guild *discordgo.Guild) {
+	ctx := request.Context()
+	existing, err := dashboard.listRules(request, guild.ID)
+	if err != nil {
+		dashboard.renderFailure(writer, err)
+		return
+	}
+	//Managers could otherwise allow themselves auth, which only the owner has by default
+	authLocked := !dashboard.canEditAuth(ctx, guild, session.UserID)
+	changes := 0
+	for field, values := range request.PostForm {
+		if !strings.HasPrefix(field, ruleField) || len(values) != 1 {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(field, ruleField), ":")
+		if len(parts) != 4 || !validRule(guild, parts[0], parts[1], parts[2], parts[3]) {
+			dashboard.renderError(writer, http.StatusBadRequest, "That form names a rule Flamingo doesn't know, please go back and try again.")
+			return
+		}
+		command, action, kind, id := parts[0], parts[1], parts[2], parts[3]
+		allow, exists := existing[field]
+		deleted := values[0] == "" && exists
+		set := (values[0] == "allow" || values[0] == "deny") && (!exists || formatRule(allow) != values[0])
+		if (deleted || set) && authLocked && command == authCommand {
+			dashboard.renderError(writer, http.StatusForbidden, "Only the server owner, administrators and members allowed to use auth can change who may use auth.")
+			return
+		}
+		switch {
+		case deleted:
+			err = dashboard.AuthClient.DeletePermission(ctx, guild.ID, session.UserID, id, command, action, flamingoservice.PermissionScope{}, kind == "role")
+			changes++
+		case set:
+			err = dashboard.AuthClient.SetPermission(ctx, guild.ID, session.UserID, id, command, action, flamingoservice.PermissionScope{}, kind == "role", values[0] == "allow")
+			changes++
+		}
+		if err != nil {
+			dashboard.renderFailure(writer, err)
+			return
+		}
+	}
+
+	if permissive := request.PostFormValue("permissive"); permissive == "true" || permissive == "false" {
+		current, err := dashboard.AuthClient.GetPermissiveFlagValue(ctx, guild.ID)
+		if err != nil && !errors.Is(err, flamingoservice.ErrPermissiveFlagNotFound) {
+			dashboard.renderFailure(writer, err)
+			return
+		}
+		if err != nil || strconv.FormatBool(current) != permissive {
+			if authLocked {
+				dashboard.renderError(writer, http.StatusForbidden, "Only the server owner, administrators and members allowed to use auth can change the permissive flag.")
+				return
+			}
+			if err := dashboard.AuthClient.SetPermissiveFlagValue(ctx, guild.ID, session.UserID, permissive == "true"); err != nil {
+				dashboard.renderFailure(writer, err)
+				return
+			}
+			changes++
+		}
+	}
+	dashboard.MetricsClient.Count(dashboardServiceName, "PermissionsSaved", nil)
+	http.Redirect(writer, request, "/guilds/"+guild.ID+"/permissions?saved="+strconv.Itoa(changes), http.StatusSeeOther)
+}
+
+// listRules retrieves a guild's permission rules keyed by the name of their matrix cell
+func (dashboard *Dashboard) listRules(request *http.Request, guildID string) (map[string]bool, error) {
+	permissions, err := dashboard.AuthClient.ListPermissionRules(request.Context(), guildID)
+	if err != nil {
+		return nil, err
+	}
+	rules := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		//guild!command!action, the permissive flag has no command and is edited separately
+		//the matrix only shows guild-wide rules, rules limited to a channel or category are left as they are
+		hashKey := strings.SplitN(permission.Guild, "!", 3)
+		scope, kind, id := flamingoservice.SplitPermission(permission.Permission)
+		if len(hashKey) < 3 || hashKey[1] == "" || kind == "" || scope.Kind != "" {
+			continue
+		}
+		rules[ruleKey(hashKey[1], hashKey[2], kind, id)] = permission.Allow
+	}
+	return rules, nil
+}
+
+// displayName names a user by their nickname or username if Flamingo has seen them, otherwise by ID
+func (dashboard *Dashboard) displayName(guildID, userID string) string {
+	switch userID {
+	case flamingoservice.AuditSystem:
+		return "Flamingo"
+	case flamingoservice.AuditAPI:
+		return "Admin API"
+	}
+	member, err := dashboard.Shards.Member(guildID, userID)
+	if err != nil || member.User == nil {
+		return userID
+	}
+	if member.Nick != "" {
+		return member.Nick
+	}
+	return member.User.Username
+}
+
+// commandActions lists every command, then each of its actions, in the order the matrix shows them
+func commandActions() [][2]string {
+	commands := make([]string, 0, len(flamingoservice.Commands))
+	for command := range flamingoservice.Commands {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	keys := make([][2]string, 0)
+	for _, command := range commands {
+		keys = append(keys, [2]string{command, ""})
+		for _, action := range flamingoservice.Commands[command] {
+			if action != "" {
+				keys = append(keys, [2]string{command, action})
+			}
+		}
+	}
+	return keys
+}
+
+// validRule checks a submitted cell names a known command and action, and a role of the guild or a user
+func validRule(guild *discordgo.Guild, command, action, kind, id string) bool {
+	actions, ok := flamingoservice.Commands[command]
+	if !ok || !snowflake.MatchString(id) {
+		return false
+	}
+	if action != "" && !containsString(actions, action) {
+		return false
+	}
+	switch kind {
+	case "user":
+		return true
+	case "role":
+		for _, role := range guild.Roles {
+			if role.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ruleKey(command, action, kind, id string) string {
+	return ruleField + command + ":" + action + ":" + kind + ":" + id
+}
+
+func formatRule(allow bool) string {
+	if allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+func matchesQuery(query string, fields ...string) bool {
+	query = strings.ToLower(query)
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package flamingoweb
+
+import (
+	"FlamingoV2/flamingoservice"
+	"bytes"
+	"html/template"
+	"net/http"
+)
+
+// layoutTemplate frames every page, pages define "content"
+const layoutTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} · Flamingo</title>
+<style>
+body { font-family: sans-serif; margin: 0; background: #fafafa; color: #222; }
+header { background: #e75480; color: #fff; padding: 0.75em 1.5em; display: flex; align-items: center; gap: 1.5em; }
+header a { color: #fff; }
+header form { margin-left: auto; }
+nav a { margin-right: 1em; }
+nav a.current { font-weight: bold; text-decoration: none; }
+main { padding: 1.5em; }
+table { border-collapse: collapse; margin-bottom: 2em; background: #fff; }
+th, td { border: 1px solid #ddd; padding: 0.4em 0.6em; text-align: left; vertical-align: top; }
+td.content { max-width: 40em; white-space: pre-wrap; word-break: break-word; }
+.message { background: #e6ffe6; border: 1px solid #9c9; padding: 0.5em 1em; }
+.error { background: #ffe6e6; border: 1px solid #c99; padding: 0.5em 1em; }
+</style>
+</head>
+<body>
+<header>
+<strong><a href="/">Flamingo</a></strong>
+{{if .Guild}}<span>{{.Guild.Name}}</span>
+<nav>
+<a href="/guilds/{{.Guild.ID}}/pastas"{{if eq .Page "pastas"}} class="current"{{end}}>Pastas</a>
+<a href="/guilds/{{.Guild.ID}}/strikes"{{if eq .Page "strikes"}} class="current"{{end}}>Strikes</a>
+<a href="/guilds/{{.Guild.ID}}/permissions"{{if eq .Page "permissions"}} class="current"{{end}}>Permissions</a>
+</nav>{{end}}
+{{if .User}}<form method="post" action="/logout">{{.User}} <input type="hidden" name="csrf" value="{{.CSRF}}"><button>Log out</button></form>{{end}}
+</header>
+<main>
+<h1>{{.Title}}</h1>
+{{if .Message}}<p class="message">{{.Message}}</p>{{end}}
+{{template "content" .}}
+</main>
+</body>
+</html>`
+
+// pageTemplates are the pages of the dashboard, by name
+var pageTemplates = map[string]string{
+	"login": `{{define "content"}}
+<p>Log in with Discord to manage the servers you own or can manage.</p>
+<p><a href="/login">Log in with Discord</a></p>
+{{end}}`,
+
+	"error": `{{define "content"}}
+<p class="error">{{.Content}}</p>
+<p><a href="/">Back to your servers</a></p>
+{{end}}`,
+
+	"guilds": `{{define "content"}}
+{{if .Content}}<ul>
+{{range .Content}}<li><a href="/guilds/{{.ID}}/pastas">{{.Name}}</a></li>
+{{end}}</ul>
+{{else}}<p>Flamingo isn't in any server you own or can manage.</p>{{end}}
+{{end}}`,
+
+	"pastas": `{{define "content"}}
+<form method="get">
+<input type="search" name="q" value="{{.Content.Query}}" placeholder="Search aliases and content">
+<button>Search</button>
+</form>
+<h2>Pastas ({{len .Content.Pastas}})</h2>
+{{template "contentTable" .Content.Pastas}}
+<h2>Templates ({{len .Content.Templates}})</h2>
+{{template "contentTable" .Content.Templates}}
+{{end}}
+{{define "contentTable"}}{{if .}}<table>
+<tr><th>Alias</th><th>Owner</th><th>Content</th></tr>
+{{range .}}<tr><td>{{.Alias}}</td><td>{{.Owner}}</td><td class="content">{{.Content}}</td></tr>
+{{end}}</table>
+{{else}}<p>Nothing found.</p>{{end}}{{end}}`,
+
+	"strikes": `{{define "content"}}
+<h2>Leaderboard</h2>
+{{if .Content.Leaders}}<table>
+<tr><th>#</th><th>User</th><th>Strikes</th></tr>
+{{range .Content.Leaders}}<tr><td>{{.Rank}}</td><td><a href="?user={{.UserID}}">{{.User}}</a></td><td>{{.Strikes}}</td></tr>
+{{end}}</table>
+{{else}}<p>Nobody has any strikes.</p>{{end}}
+<h2>History{{if .Content.User}} of {{.Content.User}} (<a href="?">everyone</a>){{end}}</h2>
+{{if .Content.History}}<table>
+<tr><th>When</th><th>Action</th><th>By</th><th>User</th><th>Strikes</th></tr>
+{{range .Content.History}}<tr><td>{{.Time}}</td><td>{{.Action}}</td><td>{{.Actor}}</td><td>{{.Target}}</td><td>{{.Change}}</td></tr>
+{{end}}</table>
+{{else}}<p>No strikes have been recorded.</p>{{end}}
+{{end}}`,
+
+	"permissions": `{{define "content"}}
+<form method="get">
+<input type="text" name="user" pattern="[0-9]+" placeholder="User ID" required>
+<button>Add user column</button>
+</form>
+<form method="post">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<p><label>Permissive:
+<select name="permissive"{{if .Content.AuthLocked}} disabled{{end}}>
+{{if not .Content.Permissive}}<option value="" selected>not set</option>{{end}}
+<option value="true"{{if eq .Content.Permissive "true"}} selected{{end}}>true</option>
+<option value="false"{{if eq .Content.Permissive "false"}} selected{{end}}>false</option>
+</select></label></p>
+<table>
+<tr><th>Command</th>{{range .Content.Columns}}<th title="{{.Kind}} {{.ID}}">{{.Name}}</th>{{end}}</tr>
+{{range .Content.Rows}}<tr><td>{{.Label}}</td>{{$locked := .Locked}}{{range .Cells}}<td><select name="{{.Name}}"{{if $locked}} disabled{{end}}>
+<option value=""{{if eq .Value ""}} selected{{end}}></option>
+<option value="allow"{{if eq .Value "allow"}} selected{{end}}>allow</option>
+<option value="deny"{{if eq .Value "deny"}} selected{{end}}>deny</option>
+</select></td>{{end}}</tr>
+{{end}}</table>
+<button>Save</button>
+</form>
+<p>Rules limited to a channel or category aren't shown here, manage them with <code>~auth</code>.</p>
+{{if .Content.AuthLocked}}<p>Only the server owner, administrators and members allowed to use auth can change the auth rules and the permissive flag.</p>{{end}}
+{{end}}`,
+}
+
+// parsePages pairs every page with the layout, panicking on a malformed template as they are fixed at build time
+func parsePages() map[string]*template.Template {
+	pages := make(map[string]*template.Template, len(pageTemplates))
+	for name, page := range pageTemplates {
+		pages[name] = template.Must(template.Must(template.New(name).Parse(layoutTemplate)).Parse(page))
+	}
+	return pages
+}
+
+// render writes a page, rendering it fully first so a template error can't leave a half written page
+func (dashboard *Dashboard) render(writer http.ResponseWriter, status int, page string, data pageData) {
+	buffer := bytes.Buffer{}
+	if err := dashboard.pages[page].Execute(&buffer, data); err != nil {
+		dashboard.DashboardErrorLogger.Println(err)
+		http.Error(writer, "An error occurred, please try again later.", http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(status)
+	writer.Write(buffer.Bytes())
+}
+
+func (dashboard *Dashboard) renderError(writer http.ResponseWriter, status int, message string) {
+	dashboard.render(writer, status, "error", pageData{Title: http.StatusText(status), Content: message})
+}
+
+// renderFailure replies to a request whose service call failed
+// services log their own errors, so only the reply is written here
+func (dashboard *Dashboard) renderFailure(writer http.ResponseWriter, err error) {
+	if userErr, ok := err.(*flamingoservice.UserError); ok {
+		dashboard.renderError(writer, http.StatusBadRequest, userErr.Reason)
+		return
+	}
+	if flamingoservice.IsTimeout(err) {
+		dashboard.renderError(writer, http.StatusGatewayTimeout, "That took too long, please try again.")
+		return
+	}
+	dashboard.MetricsClient.Count(dashboardServiceName, "Failed", nil)
+	dashboard.renderError(writer, http.StatusInternalServerError, "An error occurred, please try again later.")
+}